@@ -10,6 +10,7 @@ import (
 
 	"github.com/tladugin/yaProject.git/internal/agent"
 	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
 	"github.com/tladugin/yaProject.git/internal/repository"
 	"golang.org/x/sync/errgroup"
 )
@@ -32,6 +33,31 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	labels, err := models.ParseLabels(config.Labels)
+	if err != nil {
+		log.Fatalf("Failed to parse labels: %v", err)
+	}
+
+	// Транспорт gRPC: пачки protobuf без JSON и gzip
+	var grpcSender *agent.GRPCSender
+	switch config.Transport {
+	case agent.TransportHTTP:
+	case agent.TransportGRPC:
+		if config.GRPCAddress == "" {
+			log.Fatal("grpc transport requires grpc_address")
+		}
+		if config.CryptoKey != "" {
+			sugar.Warn("crypto_key is not used by the gRPC transport")
+		}
+		grpcSender, err = agent.NewGRPCSender(config.GRPCAddress, config.Key)
+		if err != nil {
+			log.Fatalf("Failed to create gRPC sender: %v", err)
+		}
+		defer grpcSender.Close()
+	default:
+		log.Fatalf("Unknown transport %q, expected http or grpc", config.Transport)
+	}
+
 	workerPool, err := agent.NewWorkerPool(config.RateLimit)
 	if err != nil {
 		sugar.Fatal("Failed to create worker pool: ", err)
@@ -55,7 +81,7 @@ func main() {
 	})
 
 	g.Go(func() error {
-		return agent.ReportMetricsWithContext(ctx, storage, config.Address, config.Key, reportDuration, workerPool, sugar, &pollCounter, config.CryptoKey)
+		return agent.ReportMetricsWithContext(ctx, storage, config.Address, config.Key, reportDuration, workerPool, sugar, &pollCounter, config.CryptoKey, labels, grpcSender)
 	})
 
 	sugar.Info("Agent started. Press Ctrl+C to stop.")
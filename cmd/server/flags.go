@@ -1,11 +1,14 @@
 package main
 
 import (
+	"crypto/rsa"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tladugin/yaProject.git/internal/repository"
 )
 
 type ServerConfig struct {
@@ -19,6 +22,45 @@ type ServerConfig struct {
 	AuditFile     string `mapstructure:"audit_file"`
 	AuditURL      string `mapstructure:"audit_url"`
 	UsePprof      bool   `mapstructure:"use_pprof"`
+
+	HistorySize      int    `mapstructure:"history_size"`      // количество хранимых отсчетов на метрику
+	HistoryRetention string `mapstructure:"history_retention"` // максимальный возраст отсчета истории
+
+	MetricTTL   string `mapstructure:"metric_ttl"`   // срок без обновлений, после которого метрика устаревает ("0" - никогда)
+	StaleAction string `mapstructure:"stale_action"` // действие с устаревшими метриками: mark или evict
+
+	GroupCommitSize int    `mapstructure:"group_commit_size"` // метрик в группе записи (0 - каждое обновление пишется сразу)
+	GroupCommitWait string `mapstructure:"group_commit_wait"` // максимальное ожидание группы записи
+
+	SamplesRetention string `mapstructure:"samples_retention"` // срок хранения истории в PostgreSQL ("0" - бессрочно)
+
+	CacheFlushInterval string `mapstructure:"cache_flush_interval"` // период записи кэша в PostgreSQL ("0" - без кэша)
+	CacheFlushSize     int    `mapstructure:"cache_flush_size"`     // размер очереди кэша, при котором запись выполняется досрочно
+
+	// Параметры пула соединений с PostgreSQL (0 - значение pgx по умолчанию)
+	DBMaxConns          int32  `mapstructure:"db_max_conns"`
+	DBMinConns          int32  `mapstructure:"db_min_conns"`
+	DBMaxConnLifetime   string `mapstructure:"db_max_conn_lifetime"`
+	DBMaxConnIdleTime   string `mapstructure:"db_max_conn_idle_time"`
+	DBHealthCheckPeriod string `mapstructure:"db_health_check_period"`
+
+	// Хранение поколений файла бэкапа
+	BackupKeepLast   int    `mapstructure:"backup_keep_last"`   // количество последних поколений
+	BackupKeepHourly string `mapstructure:"backup_keep_hourly"` // период, за который хранится последнее поколение каждого часа
+	BackupKeepDaily  string `mapstructure:"backup_keep_daily"`  // период, за который хранится последнее поколение каждых суток
+
+	RestoreFrom   string `mapstructure:"restore_from"`   // момент восстановления: метка поколения или время RFC 3339
+	RestoreSource string `mapstructure:"restore_source"` // источник восстановления: auto, file или postgres
+	RestoreForce  bool   `mapstructure:"restore_force"`  // разрешить замену базы пустым бэкапом
+
+	// Шифрование файлов бэкапа и журнала (AES-256-GCM)
+	BackupKeyFile     string `mapstructure:"backup_key_file"`      // файл ключа; создается, если отсутствует ("" - без шифрования)
+	BackupKeyWrap     bool   `mapstructure:"backup_key_wrap"`      // ключи в файлах зашифрованы RSA ключом сервера (crypto_key)
+	BackupOldKeyFiles string `mapstructure:"backup_old_key_files"` // предыдущие ключи через запятую для чтения и перешифрования
+
+	WALSnapshotInterval string `mapstructure:"wal_snapshot_interval"` // период снимков и сжатия журнала в синхронном режиме ("0" - только при остановке)
+
+	GRPCAddress string `mapstructure:"grpc_address"` // адрес gRPC сервера ("" - gRPC выключен)
 }
 
 func GetServerConfig() (*ServerConfig, error) {
@@ -51,6 +93,67 @@ func GetServerConfig() (*ServerConfig, error) {
 	return &config, nil
 }
 
+// PoolConfig возвращает параметры пула соединений с PostgreSQL
+func (c *ServerConfig) PoolConfig() (repository.PoolConfig, error) {
+	cfg := repository.PoolConfig{MaxConns: c.DBMaxConns, MinConns: c.DBMinConns}
+
+	var err error
+	if cfg.MaxConnLifetime, err = time.ParseDuration(c.DBMaxConnLifetime); err != nil {
+		return repository.PoolConfig{}, fmt.Errorf("invalid db_max_conn_lifetime: %w", err)
+	}
+	if cfg.MaxConnIdleTime, err = time.ParseDuration(c.DBMaxConnIdleTime); err != nil {
+		return repository.PoolConfig{}, fmt.Errorf("invalid db_max_conn_idle_time: %w", err)
+	}
+	if cfg.HealthCheckPeriod, err = time.ParseDuration(c.DBHealthCheckPeriod); err != nil {
+		return repository.PoolConfig{}, fmt.Errorf("invalid db_health_check_period: %w", err)
+	}
+	return cfg, nil
+}
+
+// BackupRetention возвращает политику хранения поколений файла бэкапа
+func (c *ServerConfig) BackupRetention() (repository.RetentionPolicy, error) {
+	policy := repository.RetentionPolicy{KeepLast: c.BackupKeepLast}
+
+	var err error
+	if policy.Hourly, err = time.ParseDuration(c.BackupKeepHourly); err != nil {
+		return repository.RetentionPolicy{}, fmt.Errorf("invalid backup_keep_hourly: %w", err)
+	}
+	if policy.Daily, err = time.ParseDuration(c.BackupKeepDaily); err != nil {
+		return repository.RetentionPolicy{}, fmt.Errorf("invalid backup_keep_daily: %w", err)
+	}
+	return policy, nil
+}
+
+// BackupCipher загружает ключи шифрования бэкапов (nil, если шифрование не настроено).
+// wrapKey - приватный ключ сервера для ключей, сохраненных в зашифрованном виде
+func (c *ServerConfig) BackupCipher(wrapKey *rsa.PrivateKey) (*repository.BackupCipher, error) {
+	if c.BackupKeyFile == "" {
+		return nil, nil
+	}
+	if !c.BackupKeyWrap {
+		wrapKey = nil
+	} else if wrapKey == nil {
+		return nil, fmt.Errorf("backup_key_wrap requires crypto_key")
+	}
+
+	key, err := repository.LoadOrCreateBackupKey(c.BackupKeyFile, wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	var oldKeys [][]byte
+	for _, path := range strings.Split(c.BackupOldKeyFiles, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		oldKey, err := repository.LoadBackupKey(path, wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		oldKeys = append(oldKeys, oldKey)
+	}
+	return repository.NewBackupCipher(key, oldKeys...)
+}
+
 // setDefaults устанавливает значения по умолчанию
 func setDefaults(v *viper.Viper) {
 	v.SetDefault("address", "localhost:8080")
@@ -58,30 +161,92 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("store_file", "server_backup")
 	v.SetDefault("restore", false)
 	v.SetDefault("use_pprof", false)
+	v.SetDefault("history_size", 720)
+	v.SetDefault("history_retention", "1h")
+	v.SetDefault("metric_ttl", "0")
+	v.SetDefault("stale_action", "mark")
+	v.SetDefault("group_commit_size", 0)
+	v.SetDefault("group_commit_wait", "5ms")
+	v.SetDefault("samples_retention", "168h")
+	v.SetDefault("cache_flush_interval", "1s")
+	v.SetDefault("cache_flush_size", 1000)
+	v.SetDefault("db_max_conns", 0)
+	v.SetDefault("db_min_conns", 0)
+	v.SetDefault("db_max_conn_lifetime", "0")
+	v.SetDefault("db_max_conn_idle_time", "0")
+	v.SetDefault("db_health_check_period", "0")
+	v.SetDefault("backup_keep_last", 3)
+	v.SetDefault("backup_keep_hourly", "24h")
+	v.SetDefault("backup_keep_daily", "168h")
+	v.SetDefault("restore_from", "")
+	v.SetDefault("restore_source", "auto")
+	v.SetDefault("restore_force", false)
+	v.SetDefault("wal_snapshot_interval", "5m")
+	v.SetDefault("backup_key_file", "")
+	v.SetDefault("backup_key_wrap", false)
+	v.SetDefault("backup_old_key_files", "")
+	v.SetDefault("grpc_address", "")
 }
 
-// setupFlags настраивает флаги
+// setupFlags настраивает флаги командной строки
 func setupFlags(v *viper.Viper) {
-	// Создаем pflag set
-	pflag.StringP("address", "a", "localhost:8080", "address and port to run server")
-	pflag.StringP("store_interval", "i", "300", "saving server data interval")
-	pflag.StringP("store_file", "f", "server_backup", "path for server backup file")
-	pflag.BoolP("restore", "r", false, "restore server data")
-	pflag.StringP("database_dsn", "d", "", "database DSN")
-	pflag.StringP("key", "k", "", "key")
-	pflag.String("audit-file", "", "path for server audit file")
-	pflag.String("audit-url", "", "audit URL")
-	pflag.Bool("pprof", false, "use benchmark")
-	pflag.String("crypto-key", "", "path to private key for decryption")
-	pflag.StringP("config", "c", "", "path to config file")
+	registerFlags(pflag.CommandLine)
 
 	// Привязываем флаги к Viper
-	v.BindPFlags(pflag.CommandLine)
+	bindFlags(v, pflag.CommandLine)
 
 	// Парсим флаги
 	pflag.Parse()
 }
 
+// registerFlags регистрирует флаги сервера в fs
+func registerFlags(fs *pflag.FlagSet) {
+	fs.StringP("address", "a", "localhost:8080", "address and port to run server")
+	fs.StringP("store_interval", "i", "300", "saving server data interval")
+	fs.StringP("store_file", "f", "server_backup", "path for server backup file")
+	fs.BoolP("restore", "r", false, "restore server data")
+	fs.StringP("database_dsn", "d", "", "database DSN")
+	fs.StringP("key", "k", "", "key")
+	fs.String("audit-file", "", "path for server audit file")
+	fs.String("audit-url", "", "audit URL")
+	fs.Bool("pprof", false, "serve pprof and Postgres pool statistics (/debug/db) on :6060")
+	fs.String("crypto-key", "", "path to private key for decryption")
+	fs.StringP("config", "c", "", "path to config file")
+	fs.Int("history_size", 720, "number of history samples kept per metric (0 disables history)")
+	fs.String("history_retention", "1h", "max age of history samples")
+	fs.String("metric_ttl", "0", "time without updates after which a metric becomes stale (0 disables)")
+	fs.String("stale_action", "mark", "what to do with stale metrics: mark or evict")
+	fs.Int("group_commit_size", 0, "max metrics committed together in Postgres or sync backup mode (0 disables grouping)")
+	fs.String("group_commit_wait", "5ms", "max time an update waits for its commit group")
+	fs.String("cache_flush_interval", "1s", "how often the write-behind cache in front of Postgres is flushed. "+
+		"With the cache, updates are acknowledged before they are committed and may be lost on a crash; group commit is not used. "+
+		"0 disables the cache: every update is committed before the response and reads go to Postgres")
+	fs.Int("cache_flush_size", 1000, "number of cached updates that triggers an early write to Postgres")
+	fs.Int32("db_max_conns", 0, "max Postgres pool connections (0 uses the pgx default)")
+	fs.Int32("db_min_conns", 0, "min Postgres pool connections kept open")
+	fs.String("db_max_conn_lifetime", "0", "max lifetime of a Postgres connection (0 uses the pgx default)")
+	fs.String("db_max_conn_idle_time", "0", "max idle time of a Postgres connection (0 uses the pgx default)")
+	fs.String("db_health_check_period", "0", "period of Postgres pool health checks (0 uses the pgx default)")
+	fs.Int("backup_keep_last", 3, "number of most recent backup generations to keep")
+	fs.String("backup_keep_hourly", "24h", "keep the latest backup generation of each hour for this period")
+	fs.String("backup_keep_daily", "168h", "keep the latest backup generation of each day for this period")
+	fs.String("restore_from", "", "restore the latest backup generation at or before this time (generation stamp or RFC 3339)")
+	fs.String("restore_source", "auto", "where state is restored from: file, postgres or auto (postgres when database_dsn is set)")
+	fs.Bool("restore_force", false, "allow restore_source=file to replace the database with a backup that has no metrics")
+	fs.String("backup_key_file", "", "file with the AES key encrypting backups, created if missing (empty disables encryption)")
+	fs.Bool("backup_key_wrap", false, "backup key files are wrapped with the server RSA key (crypto-key)")
+	fs.String("backup_old_key_files", "", "comma-separated previous backup key files; older backups are re-encrypted with the current key")
+	fs.String("wal_snapshot_interval", "5m", "how often a snapshot compacts the update log in sync backup mode (0 snapshots only on shutdown)")
+	fs.String("grpc_address", "", "address and port of the gRPC server (empty disables gRPC)")
+	fs.String("samples_retention", "168h", "how long metric history is kept in Postgres, rounded up to whole days (0 keeps forever)")
+
+}
+
+// bindFlags привязывает флаги fs к одноименным ключам Viper
+func bindFlags(v *viper.Viper, fs *pflag.FlagSet) {
+	v.BindPFlags(fs)
+}
+
 // setupEnv настраивает переменные окружения
 func setupEnv(v *viper.Viper) {
 	// Автоматическое связывание переменных окружения
@@ -101,4 +266,29 @@ func setupEnv(v *viper.Viper) {
 	v.BindEnv("audit_url", "AUDIT_URL")
 	v.BindEnv("use_pprof", "USE_PPROF")
 	v.BindEnv("config", "CONFIG")
+	v.BindEnv("history_size", "HISTORY_SIZE")
+	v.BindEnv("history_retention", "HISTORY_RETENTION")
+	v.BindEnv("metric_ttl", "METRIC_TTL")
+	v.BindEnv("stale_action", "STALE_ACTION")
+	v.BindEnv("group_commit_size", "GROUP_COMMIT_SIZE")
+	v.BindEnv("group_commit_wait", "GROUP_COMMIT_WAIT")
+	v.BindEnv("samples_retention", "SAMPLES_RETENTION")
+	v.BindEnv("cache_flush_interval", "CACHE_FLUSH_INTERVAL")
+	v.BindEnv("cache_flush_size", "CACHE_FLUSH_SIZE")
+	v.BindEnv("db_max_conns", "DB_MAX_CONNS")
+	v.BindEnv("db_min_conns", "DB_MIN_CONNS")
+	v.BindEnv("db_max_conn_lifetime", "DB_MAX_CONN_LIFETIME")
+	v.BindEnv("db_max_conn_idle_time", "DB_MAX_CONN_IDLE_TIME")
+	v.BindEnv("db_health_check_period", "DB_HEALTH_CHECK_PERIOD")
+	v.BindEnv("backup_keep_last", "BACKUP_KEEP_LAST")
+	v.BindEnv("backup_keep_hourly", "BACKUP_KEEP_HOURLY")
+	v.BindEnv("backup_keep_daily", "BACKUP_KEEP_DAILY")
+	v.BindEnv("restore_from", "RESTORE_FROM")
+	v.BindEnv("restore_source", "RESTORE_SOURCE")
+	v.BindEnv("restore_force", "RESTORE_FORCE")
+	v.BindEnv("wal_snapshot_interval", "WAL_SNAPSHOT_INTERVAL")
+	v.BindEnv("backup_key_file", "BACKUP_KEY_FILE")
+	v.BindEnv("backup_key_wrap", "BACKUP_KEY_WRAP")
+	v.BindEnv("backup_old_key_files", "BACKUP_OLD_KEY_FILES")
+	v.BindEnv("grpc_address", "GRPC_ADDRESS")
 }
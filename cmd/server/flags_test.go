@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// parseFlags разбирает args набором флагов сервера и возвращает настроенный Viper
+func parseFlags(t *testing.T, args []string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	setDefaults(v)
+
+	fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	registerFlags(fs)
+	bindFlags(v, fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse(%v) error = %v", args, err)
+	}
+	setupEnv(v)
+	return v
+}
+
+func TestFlags_RestoreFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want string
+	}{
+		{name: "Default", want: ""},
+		{name: "Flag", args: []string{"--restore_from", "2026-10-17T10:00:00Z"}, want: "2026-10-17T10:00:00Z"},
+		{name: "Flag with equals sign", args: []string{"--restore_from=20261017T100000Z"}, want: "20261017T100000Z"},
+		{name: "Environment", env: "2026-10-16T00:00:00Z", want: "2026-10-16T00:00:00Z"},
+		{name: "Flag overrides environment", args: []string{"--restore_from", "2026-10-17T10:00:00Z"}, env: "2026-10-16T00:00:00Z", want: "2026-10-17T10:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("RESTORE_FROM", tt.env)
+			}
+			v := parseFlags(t, tt.args)
+			if got := v.GetString("restore_from"); got != tt.want {
+				t.Errorf("restore_from = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlags_RestoreHyphenRejected(t *testing.T) {
+	// Флаги пишутся через подчеркивание, как ключи конфигурации
+	for _, arg := range []string{"--restore-from=2026-10-17T10:00:00Z", "--restore-force"} {
+		fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerFlags(fs)
+		if err := fs.Parse([]string{arg}); err == nil {
+			t.Errorf("%s is accepted, want the underscore spelling only", arg)
+		}
+	}
+}
+
+func TestFlags_RestoreForce(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want bool
+	}{
+		{name: "Default", want: false},
+		{name: "Flag", args: []string{"--restore_force"}, want: true},
+		{name: "Environment", env: "true", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("RESTORE_FORCE", tt.env)
+			}
+			v := parseFlags(t, tt.args)
+			if got := v.GetBool("restore_force"); got != tt.want {
+				t.Errorf("restore_force = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlags_CacheFlushInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want string
+	}{
+		{name: "Default enables the cache", want: "1s"},
+		{name: "Flag disables the cache", args: []string{"--cache_flush_interval", "0"}, want: "0"},
+		{name: "Environment", env: "500ms", want: "500ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("CACHE_FLUSH_INTERVAL", tt.env)
+			}
+			v := parseFlags(t, tt.args)
+			if got := v.GetString("cache_flush_interval"); got != tt.want {
+				t.Errorf("cache_flush_interval = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/spf13/pflag"
 	"github.com/tladugin/yaProject.git/internal/logger"
 	"github.com/tladugin/yaProject.git/internal/repository"
 	"github.com/tladugin/yaProject.git/internal/server"
@@ -40,8 +41,24 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Запуск pprof сервера (если включен)
+	// Параметры пула соединений с PostgreSQL
+	poolConfig, err := config.PoolConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Подкоманда управления миграциями: server migrate up|down [n]|status
+	if args := pflag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(ctx, config.DatabaseDSN, poolConfig, args[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Запуск pprof сервера (если включен); на нем же публикуется статистика пула /debug/db
+	var debugMux *http.ServeMux
 	if config.UsePprof {
+		debugMux = http.DefaultServeMux
 		go func() {
 			sugar.Info("Starting pprof server on :6060")
 			if err := http.ListenAndServe(":6060", nil); err != nil && err != http.ErrServerClosed {
@@ -58,20 +75,88 @@ func main() {
 		sugar.Info("Private key loaded successfully")
 	}
 
-	// Создание хранилища
-	storage := repository.NewMemStorage()
+	// Шифрование бэкапов: старые поколения и журнал перешифровываются текущим ключом
+	backupCipher, err := config.BackupCipher(server.PrivateKey())
+	if err != nil {
+		sugar.Fatalw("Failed to load backup key", "error", err)
+	}
+	if backupCipher != nil {
+		repository.SetBackupCipher(backupCipher)
+		rewritten, err := repository.ReencryptBackups(config.StoreFile)
+		if err != nil {
+			sugar.Fatalw("Failed to re-encrypt backups", "error", err)
+		}
+		sugar.Infow("Backup encryption enabled", "reencrypted_files", rewritten)
+	}
 
-	// Восстановление данных из бэкапа
-	if config.Restore {
-		if err := repository.RestoreFromBackup(storage, config.StoreFile); err != nil {
+	// Создание хранилища с историей значений метрик
+	historyRetention, err := time.ParseDuration(config.HistoryRetention)
+	if err != nil {
+		sugar.Fatalw("Invalid history retention", "error", err)
+	}
+	storage := repository.NewMemStorageWithHistory(config.HistorySize, historyRetention)
+
+	// Срок устаревания метрик без обновлений
+	metricTTL, err := time.ParseDuration(config.MetricTTL)
+	if err != nil {
+		sugar.Fatalw("Invalid metric TTL", "error", err)
+	}
+	if config.StaleAction != "mark" && config.StaleAction != "evict" {
+		sugar.Fatalw("Invalid stale action, expected mark or evict", "stale_action", config.StaleAction)
+	}
+	storage.SetStaleTTL(metricTTL)
+
+	// Параметры группового коммита
+	groupCommitWait, err := time.ParseDuration(config.GroupCommitWait)
+	if err != nil {
+		sugar.Fatalw("Invalid group commit wait", "error", err)
+	}
+
+	// Срок хранения истории в PostgreSQL
+	samplesRetention, err := time.ParseDuration(config.SamplesRetention)
+	if err != nil {
+		sugar.Fatalw("Invalid samples retention", "error", err)
+	}
+
+	// Период записи кэша в PostgreSQL
+	cacheFlushInterval, err := time.ParseDuration(config.CacheFlushInterval)
+	if err != nil {
+		sugar.Fatalw("Invalid cache flush interval", "error", err)
+	}
+
+	// Политика хранения поколений бэкапа
+	retention, err := config.BackupRetention()
+	if err != nil {
+		sugar.Fatalw("Invalid backup retention", "error", err)
+	}
+
+	// Источник восстановления: файл бэкапа или PostgreSQL
+	restoreSource, err := repository.ResolveRestoreSource(config.RestoreSource, config.DatabaseDSN != "")
+	if err != nil {
+		sugar.Fatalw("Invalid restore source", "error", err)
+	}
+	var restorePoint time.Time
+	if config.RestoreFrom != "" {
+		if restorePoint, err = repository.ParseRestorePoint(config.RestoreFrom); err != nil {
+			sugar.Fatalw("Invalid restore point", "error", err)
+		}
+	}
+	if !config.Restore && config.RestoreFrom == "" {
+		restoreSource = "" // восстановление не запрошено
+	}
+
+	// Восстановление данных из файла бэкапа: последнего либо на момент restore_from.
+	// В режиме PostgreSQL восстановление выполняется при подключении к базе
+	if restoreSource != "" && config.DatabaseDSN == "" {
+		if err := repository.RestoreFromBackupAt(storage, config.StoreFile, restorePoint); err != nil {
 			sugar.Errorw("Failed to restore from backup", "error", err)
 		} else {
 			sugar.Info("Data restored from backup successfully")
 		}
 	}
 
-	// Инициализация продюсера для бэкапов
-	producer, err := repository.NewProducer(config.StoreFile)
+	// Журнал обновлений синхронного режима
+	producer, err := repository.OpenWAL(config.StoreFile)
 	if err != nil {
 		sugar.Fatalw("Could not open backup file", "error", err)
 	}
@@ -81,36 +166,63 @@ func main() {
 	stopProgram := make(chan struct{})
 	var wg sync.WaitGroup
 
-	// Запуск периодического бэкапа если не синхронный режим
-	if config.StoreInterval != 0 {
+	// Период бэкапа; в синхронном режиме снимки сжимают журнал
+	backupInterval := time.Duration(config.StoreInterval) * time.Second
+	if config.StoreInterval == 0 {
+		if backupInterval, err = time.ParseDuration(config.WALSnapshotInterval); err != nil {
+			sugar.Fatalw("Invalid WAL snapshot interval", "error", err)
+		}
+	}
+
+	// Без кэша состояние хранится только в PostgreSQL, память не заполняется:
+	// снимки из нее были бы пустыми и вытеснили бы при очистке рабочие поколения
+	fileBackups := config.DatabaseDSN == "" || cacheFlushInterval > 0
+	if !fileBackups {
+		sugar.Info("File backups disabled: state is kept in PostgreSQL")
+	}
+
+	// Запуск периодического бэкапа
+	if fileBackups && backupInterval > 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			repository.RunPeriodicBackupWithContext(ctx, storage, producer, time.Duration(config.StoreInterval)*time.Second, config.StoreFile)
+			repository.RunPeriodicBackupWithContext(ctx, storage, producer, backupInterval, config.StoreFile, retention)
 		}()
 	}
 
 	// Запуск горутины для финального бэкапа
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		repository.RunFinalBackupWithContext(ctx, storage, producer, config.StoreFile)
-	}()
+	if fileBackups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repository.RunFinalBackupWithContext(ctx, storage, producer, config.StoreFile, retention)
+		}()
+	}
 
 	// Запуск HTTP сервера
 	wg.Add(1)
-	go server.RunHTTPServer(
-		storage,
-		producer,
-		ctx,
-		&wg,
-		config.StoreInterval,
-		&config.Address,
-		&config.DatabaseDSN,
-		&config.Key,
-		&config.AuditFile,
-		&config.AuditURL,
-	)
+	go server.RunHTTPServer(storage, producer, ctx, &wg, server.Config{
+		Address:            config.Address,
+		GRPCAddress:        config.GRPCAddress,
+		StoreInterval:      config.StoreInterval,
+		StoreFile:          config.StoreFile,
+		DatabaseDSN:        config.DatabaseDSN,
+		Key:                config.Key,
+		AuditFile:          config.AuditFile,
+		AuditURL:           config.AuditURL,
+		MetricTTL:          metricTTL,
+		EvictStale:         config.StaleAction == "evict",
+		GroupCommitSize:    config.GroupCommitSize,
+		GroupCommitWait:    groupCommitWait,
+		SamplesRetention:   samplesRetention,
+		CacheFlushInterval: cacheFlushInterval,
+		CacheFlushSize:     config.CacheFlushSize,
+		Pool:               poolConfig,
+		RestoreSource:      restoreSource,
+		RestorePoint:       restorePoint,
+		RestoreForce:       config.RestoreForce,
+		DebugMux:           debugMux,
+	})
 
 	sugar.Info("Server started. Press Ctrl+C to stop.")
 
@@ -122,11 +234,13 @@ func main() {
 	close(stopProgram)
 
 	// Сохраняем финальный бэкап
-	sugar.Info("Saving final backup...")
-	if err := repository.SaveBackup(storage, producer, config.StoreFile); err != nil {
-		sugar.Errorw("Failed to save final backup", "error", err)
-	} else {
-		sugar.Info("Final backup saved successfully")
+	if fileBackups {
+		sugar.Info("Saving final backup...")
+		if err := repository.SaveBackup(storage, producer, config.StoreFile, retention); err != nil {
+			sugar.Errorw("Failed to save final backup", "error", err)
+		} else {
+			sugar.Info("Final backup saved successfully")
+		}
 	}
 
 	// Ждем завершения всех горутин
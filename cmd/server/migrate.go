@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/tladugin/yaProject.git/internal/repository"
+	"github.com/tladugin/yaProject.git/migrations"
+)
+
+// runMigrate выполняет подкоманду server migrate up|down [n]|status
+func runMigrate(ctx context.Context, databaseDSN string, poolConfig repository.PoolConfig, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: server migrate up|down [n]|status")
+	}
+	if databaseDSN == "" {
+		return fmt.Errorf("database DSN is required (-d or DATABASE_DSN)")
+	}
+
+	pool, _, cancel, err := repository.GetConnection(databaseDSN, poolConfig)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer pool.Close()
+
+	migrator, err := repository.NewMigrator(pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up(ctx)
+		for _, m := range done {
+			fmt.Printf("applied %06d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		done, err := migrator.Down(ctx, steps)
+		for _, m := range done {
+			fmt.Printf("rolled back %06d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			if s.Applied {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state = "modified"
+			}
+			fmt.Fprintf(w, "%06d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		w.Flush()
+		return err
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
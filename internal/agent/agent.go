@@ -6,15 +6,23 @@ import (
 	"log"
 	"math/rand"
 	"runtime"
+	"sort"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 	"go.uber.org/zap"
 
+	"github.com/tladugin/yaProject.git/internal/models"
 	"github.com/tladugin/yaProject.git/internal/repository"
 )
 
+// gcPauseBounds - верхние границы корзин гистограммы пауз GC в наносекундах
+var gcPauseBounds = []float64{1e4, 5e4, 1e5, 5e5, 1e6, 5e6, 1e7, 5e7, 1e8}
+
+// gcPauseQuantiles - квантили, вычисляемые для summary пауз GC
+var gcPauseQuantiles = []float64{0.5, 0.9, 0.99}
+
 // Глобальные переменные для информации о сборке
 var (
 	buildVersion string
@@ -30,13 +38,14 @@ func CollectRuntimeMetricsWithContext(ctx context.Context, storage *repository.M
 	ticker := time.NewTicker(pollDuration)
 	defer ticker.Stop()
 
+	var gcPauses gcPauseHistogram
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
 			sugar.Debug("Updating runtime metrics...")
-			collectRuntimeMetrics(storage)
+			collectRuntimeMetrics(storage, &gcPauses)
 			(*pollCounter)++ // Увеличение счетчика опросов
 		}
 	}
@@ -61,7 +70,9 @@ func CollectSystemMetricsWithContext(ctx context.Context, storage *repository.Me
 	}
 }
 
-func ReportMetricsWithContext(ctx context.Context, storage *repository.MemStorage, serverURL, key string, reportDuration time.Duration, workerPool *WorkerPool, sugar *zap.SugaredLogger, pollCounter *int64, FlagCryptoKey string) error {
+// ReportMetricsWithContext периодически отправляет метрики на сервер, добавляя к ним метки агента.
+// При grpcSender метрики отправляются по gRPC, иначе - по HTTP
+func ReportMetricsWithContext(ctx context.Context, storage *repository.MemStorage, serverURL, key string, reportDuration time.Duration, workerPool *WorkerPool, sugar *zap.SugaredLogger, pollCounter *int64, FlagCryptoKey string, labels models.Labels, grpcSender *GRPCSender) error {
 	sugar.Info("Starting metrics reporting")
 	defer sugar.Info("Metrics reporting stopped")
 
@@ -82,7 +93,12 @@ func ReportMetricsWithContext(ctx context.Context, storage *repository.MemStorag
 				case <-ctx.Done():
 					return
 				default:
-					err := SendWithRetry(serverURL+"/updates", storage, key, *pollCounter, FlagCryptoKey)
+					var err error
+					if grpcSender != nil {
+						err = grpcSender.Send(ctx, storage, *pollCounter, labels)
+					} else {
+						err = SendWithRetry(serverURL+"/updates", storage, key, *pollCounter, FlagCryptoKey, labels)
+					}
 					if err != nil && err != context.Canceled {
 						sugar.Errorf("Error sending metrics: %v", err)
 					} else if err == nil {
@@ -96,7 +112,7 @@ func ReportMetricsWithContext(ctx context.Context, storage *repository.MemStorag
 }
 
 // collectRuntimeMetrics собирает метрики runtime Go и сохраняет их в хранилище
-func collectRuntimeMetrics(storage *repository.MemStorage) {
+func collectRuntimeMetrics(storage *repository.MemStorage, gcPauses *gcPauseHistogram) {
 	// Получаем статистику runtime Go
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
@@ -137,6 +153,64 @@ func collectRuntimeMetrics(storage *repository.MemStorage) {
 	for name, value := range metrics {
 		storage.AddGauge(name, value)
 	}
+
+	collectGCPauseMetrics(storage, &m, gcPauses)
+}
+
+// gcPauseHistogram - накопленная с запуска агента гистограмма пауз GC.
+// Каждый опрос добавляет только паузы сборок после предыдущего опроса, поэтому Count и корзины не убывают
+type gcPauseHistogram struct {
+	numGC uint32 // NumGC на момент предыдущего опроса
+	value models.HistogramValue
+}
+
+// add добавляет в гистограмму паузы сборок, завершившихся после предыдущего опроса
+func (h *gcPauseHistogram) add(m *runtime.MemStats) {
+	if h.value.Counts == nil {
+		h.value = models.HistogramValue{Bounds: gcPauseBounds, Counts: make([]uint64, len(gcPauseBounds)+1)}
+	}
+	for _, p := range gcPausesSince(m, h.numGC) {
+		h.value.Counts[sort.SearchFloat64s(gcPauseBounds, p)]++
+		h.value.Sum += p
+		h.value.Count++
+	}
+	h.numGC = m.NumGC
+}
+
+// gcPausesSince возвращает длительности пауз сборок с номерами после since в наносекундах.
+// PauseNs хранит только 256 последних пауз: более ранние, вытесненные до опроса, не возвращаются
+func gcPausesSince(m *runtime.MemStats, since uint32) []float64 {
+	n := int(m.NumGC - since)
+	if n > len(m.PauseNs) {
+		n = len(m.PauseNs)
+	}
+
+	// PauseNs - кольцевой буфер, последняя пауза лежит в PauseNs[(NumGC+255)%256]
+	pauses := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		idx := (int(m.NumGC) - 1 - i + len(m.PauseNs)) % len(m.PauseNs)
+		pauses = append(pauses, float64(m.PauseNs[idx]))
+	}
+	return pauses
+}
+
+// collectGCPauseMetrics сохраняет распределение пауз GC: накопленную histogram GCPauseNs
+// и summary GCPauseSummary, квантили которой считаются по последним паузам (не более 256),
+// а сумма и количество - накопленные, как у histogram
+func collectGCPauseMetrics(storage *repository.MemStorage, m *runtime.MemStats, h *gcPauseHistogram) {
+	h.add(m)
+	storage.SetHistogram("GCPauseNs", h.value)
+
+	pauses := gcPausesSince(m, 0)
+	sort.Float64s(pauses)
+	sm := models.SummaryValue{Sum: h.value.Sum, Count: h.value.Count}
+	if len(pauses) > 0 {
+		for _, q := range gcPauseQuantiles {
+			idx := int(q * float64(len(pauses)-1))
+			sm.Quantiles = append(sm.Quantiles, models.Quantile{Q: q, Value: pauses[idx]})
+		}
+	}
+	storage.SetSummary("GCPauseSummary", sm)
 }
 
 // collectSystemMetrics собирает системные метрики (память, CPU) и сохраняет их в хранилище
@@ -17,6 +17,9 @@ type Flags struct {
 	FlagUsePprof           bool
 	FlagCryptoKey          string
 	FlagConfigFile         string
+	FlagLabels             string
+	FlagTransport          string
+	FlagGRPCAddress        string
 }
 
 type AgentConfig struct {
@@ -27,6 +30,9 @@ type AgentConfig struct {
 	RateLimit      int    `json:"rate_limit"`
 	UsePprof       bool   `json:"use_pprof"`
 	CryptoKey      string `json:"crypto_key"`
+	Labels         string `json:"labels"`       // метки агента в виде host=a,env=prod
+	Transport      string `json:"transport"`    // протокол отправки метрик: http или grpc
+	GRPCAddress    string `json:"grpc_address"` // адрес gRPC сервера для транспорта grpc
 }
 
 // parseFlags обрабатывает аргументы командной строки
@@ -43,6 +49,9 @@ func ParseFlags() *Flags {
 	flag.StringVar(&f.FlagCryptoKey, "crypto-key", "", "path to public key for encryption")
 	flag.StringVar(&f.FlagConfigFile, "c", "", "path to config file")
 	flag.StringVar(&f.FlagConfigFile, "config", "", "path to config file")
+	flag.StringVar(&f.FlagLabels, "labels", "", "labels added to every metric (host=a,env=prod)")
+	flag.StringVar(&f.FlagTransport, "transport", "", "metrics transport: http or grpc (default http)")
+	flag.StringVar(&f.FlagGRPCAddress, "grpc-address", "", "address and port of the gRPC server for the grpc transport")
 
 	flag.Parse()
 
@@ -74,6 +83,18 @@ func ParseFlags() *Flags {
 		f.FlagCryptoKey = envCryptoKey
 	}
 
+	if envLabels, ok := os.LookupEnv("LABELS"); ok {
+		f.FlagLabels = envLabels
+	}
+
+	if envTransport, ok := os.LookupEnv("TRANSPORT"); ok {
+		f.FlagTransport = envTransport
+	}
+
+	if envGRPCAddress, ok := os.LookupEnv("GRPC_ADDRESS"); ok {
+		f.FlagGRPCAddress = envGRPCAddress
+	}
+
 	return &f
 }
 
@@ -144,6 +165,15 @@ func GetAgentConfig() (*AgentConfig, error) {
 	if flags.FlagCryptoKey != "" {
 		config.CryptoKey = flags.FlagCryptoKey
 	}
+	if flags.FlagLabels != "" {
+		config.Labels = flags.FlagLabels
+	}
+	if flags.FlagTransport != "" {
+		config.Transport = flags.FlagTransport
+	}
+	if flags.FlagGRPCAddress != "" {
+		config.GRPCAddress = flags.FlagGRPCAddress
+	}
 
 	// Проверяем переменные окружения (средний приоритет)
 	// Используем LookupEnv для точного контроля
@@ -167,6 +197,15 @@ func GetAgentConfig() (*AgentConfig, error) {
 	if envCryptoKey, ok := os.LookupEnv("CRYPTO_KEY"); ok && flags.FlagCryptoKey == "" {
 		config.CryptoKey = envCryptoKey
 	}
+	if envLabels, ok := os.LookupEnv("LABELS"); ok && flags.FlagLabels == "" {
+		config.Labels = envLabels
+	}
+	if envTransport, ok := os.LookupEnv("TRANSPORT"); ok && flags.FlagTransport == "" {
+		config.Transport = envTransport
+	}
+	if envGRPCAddress, ok := os.LookupEnv("GRPC_ADDRESS"); ok && flags.FlagGRPCAddress == "" {
+		config.GRPCAddress = envGRPCAddress
+	}
 
 	// Устанавливаем значения по умолчанию если не установлены
 	if config.ReportInterval == "" {
@@ -178,6 +217,9 @@ func GetAgentConfig() (*AgentConfig, error) {
 	if config.RateLimit == 0 {
 		config.RateLimit = 1
 	}
+	if config.Transport == "" {
+		config.Transport = TransportHTTP
+	}
 
 	return config, nil
 }
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Протоколы отправки метрик
+const (
+	TransportHTTP = "http" // JSON+gzip пачки на /updates
+	TransportGRPC = "grpc" // пачки protobuf в потоке StreamUpdates
+)
+
+// GRPCSender отправляет метрики на gRPC сервер
+type GRPCSender struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	key    string
+}
+
+// NewGRPCSender создает отправитель метрик на gRPC сервер addr; пачки подписываются ключом key
+func NewGRPCSender(addr, key string) (*GRPCSender, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+	return &GRPCSender{
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+		key:    key,
+	}, nil
+}
+
+// Close закрывает соединение с сервером
+func (s *GRPCSender) Close() error {
+	return s.conn.Close()
+}
+
+// Send отправляет gauge, counter, histogram и summary из хранилища пачками в одном потоке StreamUpdates
+func (s *GRPCSender) Send(ctx context.Context, storage *repository.MemStorage, pollCounter int64, labels models.Labels) error {
+	stream, err := s.client.StreamUpdates(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	batches := []struct {
+		metricType string
+		size       int
+	}{
+		{"gauge", len(storage.GaugeSlice())},
+		{"counter", len(storage.CounterSlice())},
+		{"histogram", len(storage.HistogramSlice())},
+		{"summary", len(storage.SummarySlice())},
+	}
+	for _, b := range batches {
+		metrics, err := batchMetrics(b.metricType, storage, b.size, pollCounter, labels)
+		if err != nil {
+			return err
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+
+		req, err := s.batchRequest(metrics)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(req); err != nil {
+			// Причину ошибки сервер возвращает при закрытии потока
+			if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
+				return fmt.Errorf("send %s batch: %w", b.metricType, closeErr)
+			}
+			return fmt.Errorf("send %s batch: %w", b.metricType, err)
+		}
+	}
+
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return fmt.Errorf("stream updates: %w", err)
+	}
+	return nil
+}
+
+// batchRequest возвращает запрос с пачкой метрик, подписанный ключом отправителя
+func (s *GRPCSender) batchRequest(metrics []models.Metrics) (*pb.UpdateBatchRequest, error) {
+	req := &pb.UpdateBatchRequest{}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, pb.FromModel(m))
+	}
+	if s.key == "" {
+		return req, nil
+	}
+
+	payload, err := pb.BatchPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(append([]byte(s.key), payload...))
+	req.HashSha256 = hex.EncodeToString(hash[:])
+	return req, nil
+}
@@ -25,21 +25,29 @@ import (
 
 // SendMetric отправляет одиночную метрику на сервер
 func SendMetric(URL string, metricType string, storage *repository.MemStorage, i int, key string) error {
-	// 1. Подготовка метрики
+	// 1. Подготовка метрики из снимка хранилища
 	var metric models.Metrics
 
 	switch metricType {
 	case "gauge":
+		gauges := storage.GaugeSlice()
+		if i < 0 || i >= len(gauges) {
+			return fmt.Errorf("gauge index %d out of range", i)
+		}
 		metric = models.Metrics{
 			MType: "gauge",
-			ID:    storage.GaugeSlice()[i].Name,
-			Value: &storage.GaugeSlice()[i].Value,
+			ID:    gauges[i].Name,
+			Value: &gauges[i].Value,
 		}
 	case "counter":
+		counters := storage.CounterSlice()
+		if i < 0 || i >= len(counters) {
+			return fmt.Errorf("counter index %d out of range", i)
+		}
 		metric = models.Metrics{
 			MType: "counter",
-			ID:    storage.CounterSlice()[i].Name,
-			Delta: &storage.CounterSlice()[i].Value,
+			ID:    counters[i].Name,
+			Delta: &counters[i].Value,
 		}
 	default:
 		return fmt.Errorf("unknown metric type: %s", metricType)
@@ -106,45 +114,20 @@ func SendMetric(URL string, metricType string, storage *repository.MemStorage, i
 }
 
 // SendMetricsBatch отправляет пачку метрик на сервер
-func SendMetricsBatch(URL string, metricType string, storage *repository.MemStorage, batchSize int, key string, pollCounter int64, FlagCryptoKey string) error {
+// Метки агента labels добавляются к каждой метрике пачки
+func SendMetricsBatch(URL string, metricType string, storage *repository.MemStorage, batchSize int, key string, pollCounter int64, FlagCryptoKey string, labels models.Labels) error {
 	// 1. Подготовка URL
 	if !strings.HasPrefix(URL, "http://") && !strings.HasPrefix(URL, "https://") {
 		URL = "http://" + URL
 	}
 
-	// 2. Подготовка метрик
-	var metrics []models.Metrics
-	switch metricType {
-	case "gauge":
-		if len(storage.GaugeSlice()) == 0 {
-			return nil // Нет метрик для отправки
-		}
-
-		for i := 0; i < batchSize; i++ {
-			value := storage.GaugeSlice()[i].Value // Создаем копию значения
-			metrics = append(metrics, models.Metrics{
-				MType: "gauge",
-				ID:    storage.GaugeSlice()[i].Name,
-				Value: &value,
-			})
-		}
-	case "counter":
-		if len(storage.CounterSlice()) == 0 {
-			return nil // Нет метрик для отправки
-		}
-
-		for i := 0; i < batchSize; i++ {
-			//delta := storage.CounterSlice()[i].Value
-			delta := pollCounter
-			metrics = append(metrics, models.Metrics{
-				MType: "counter",
-				ID:    storage.CounterSlice()[i].Name,
-				Delta: &delta,
-			})
-
-		}
-	default:
-		return fmt.Errorf("unknown metric type: %s", metricType)
+	// 2. Подготовка метрик из снимка хранилища
+	metrics, err := batchMetrics(metricType, storage, batchSize, pollCounter, labels)
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil // Нет метрик для отправки
 	}
 
 	// 3. Сериализация в JSON
@@ -210,6 +193,74 @@ func SendMetricsBatch(URL string, metricType string, storage *repository.MemStor
 	return nil
 }
 
+// batchMetrics возвращает пачку из не более batchSize метрик типа metricType из снимка хранилища
+// с метками агента labels; приращение counter - количество опросов pollCounter
+func batchMetrics(metricType string, storage *repository.MemStorage, batchSize int, pollCounter int64, labels models.Labels) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+	switch metricType {
+	case "gauge":
+		gauges := storage.GaugeSlice()
+		if len(gauges) == 0 {
+			return nil, nil // Нет метрик для отправки
+		}
+
+		for i := 0; i < batchSize && i < len(gauges); i++ {
+			metrics = append(metrics, models.Metrics{
+				MType: "gauge",
+				ID:    gauges[i].Name,
+				Value: &gauges[i].Value,
+			})
+		}
+	case "counter":
+		counters := storage.CounterSlice()
+		if len(counters) == 0 {
+			return nil, nil // Нет метрик для отправки
+		}
+
+		for i := 0; i < batchSize && i < len(counters); i++ {
+			delta := pollCounter
+			metrics = append(metrics, models.Metrics{
+				MType: "counter",
+				ID:    counters[i].Name,
+				Delta: &delta,
+			})
+
+		}
+	case "histogram":
+		histograms := storage.HistogramSlice()
+		if len(histograms) == 0 {
+			return nil, nil // Нет метрик для отправки
+		}
+
+		for i := 0; i < batchSize && i < len(histograms); i++ {
+			metrics = append(metrics, models.Metrics{
+				MType:     "histogram",
+				ID:        histograms[i].Name,
+				Histogram: &histograms[i].Value,
+			})
+		}
+	case "summary":
+		summaries := storage.SummarySlice()
+		if len(summaries) == 0 {
+			return nil, nil // Нет метрик для отправки
+		}
+
+		for i := 0; i < batchSize && i < len(summaries); i++ {
+			metrics = append(metrics, models.Metrics{
+				MType:   "summary",
+				ID:      summaries[i].Name,
+				Summary: &summaries[i].Value,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown metric type: %s", metricType)
+	}
+	for i := range metrics {
+		metrics[i].Labels = labels
+	}
+	return metrics, nil
+}
+
 // LoadPublicKey загружает публичный ключ из файла
 func LoadPublicKey(keyPath string) (*rsa.PublicKey, error) {
 	// Читаем файл с ключом
@@ -293,7 +344,7 @@ func isRetriableError(err error) bool {
 }
 
 // SendWithRetry отправляет метрики с повторными попытками при временных ошибках
-func SendWithRetry(url string, storage *repository.MemStorage, key string, pollCounter int64, FlagCryptoKey string) error {
+func SendWithRetry(url string, storage *repository.MemStorage, key string, pollCounter int64, FlagCryptoKey string, labels models.Labels) error {
 	maxRetries := 3
 	retryDelays := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
 	var lastErr error
@@ -306,7 +357,7 @@ func SendWithRetry(url string, storage *repository.MemStorage, key string, pollC
 		}
 
 		// Отправка gauge метрик
-		errG := SendMetricsBatch(url, "gauge", storage, len(storage.GaugeSlice()), key, pollCounter, FlagCryptoKey)
+		errG := SendMetricsBatch(url, "gauge", storage, len(storage.GaugeSlice()), key, pollCounter, FlagCryptoKey, labels)
 		if errG != nil {
 			lastErr = errG
 		}
@@ -314,8 +365,15 @@ func SendWithRetry(url string, storage *repository.MemStorage, key string, pollC
 		lastErr = errG
 
 		// Отправка counter метрик
-		errC := SendMetricsBatch(url, "counter", storage, len(storage.CounterSlice()), key, pollCounter, FlagCryptoKey)
+		errC := SendMetricsBatch(url, "counter", storage, len(storage.CounterSlice()), key, pollCounter, FlagCryptoKey, labels)
 		if errC == nil {
+			// Отправка распределений (histogram, summary) после основной пачки
+			if err := SendMetricsBatch(url, "histogram", storage, len(storage.HistogramSlice()), key, pollCounter, FlagCryptoKey, labels); err != nil {
+				return fmt.Errorf("send histograms: %w", err)
+			}
+			if err := SendMetricsBatch(url, "summary", storage, len(storage.SummarySlice()), key, pollCounter, FlagCryptoKey, labels); err != nil {
+				return fmt.Errorf("send summaries: %w", err)
+			}
 			return nil // Успешная отправка
 		}
 
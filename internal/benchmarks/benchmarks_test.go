@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/tladugin/yaProject.git/internal/handler"
@@ -34,6 +35,24 @@ func BenchmarkStorageAddCounter(b *testing.B) {
 	}
 }
 
+// Бенчмарк для конкурентного обновления множества метрик
+func BenchmarkStorageAddGaugeParallel(b *testing.B) {
+	storage := repository.NewMemStorage()
+	names := make([]string, 1000)
+	for i := range names {
+		names[i] = "metric_" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			storage.AddGauge(names[i%len(names)], float64(i))
+			i++
+		}
+	})
+}
+
 // Бенчмарк для обработки HTTP запросов
 func BenchmarkHandlerPostUpdate(b *testing.B) {
 	storage := repository.NewMemStorage()
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// deleteResult - ответ на запрос удаления метрик
+type deleteResult struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteHandler удаляет метрику через URL параметры
+// DELETE /value/{metric}/{name}?match=
+func (s *Server) DeleteHandler(res http.ResponseWriter, req *http.Request) {
+	metric := chi.URLParam(req, "metric")
+	name := chi.URLParam(req, "name")
+
+	matchers, err := parseMatchers(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	labels, err := s.resolveLabels(req.Context(), metric, name, matchers)
+	deleted := 0
+	if err == nil {
+		deleted, err = s.storage.Delete(req.Context(), []models.Metrics{{ID: name, MType: metric, Labels: labels}})
+	}
+	switch {
+	case errors.Is(err, errAmbiguousSeries):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrUnknownType):
+		http.Error(res, "Invalid metric type", http.StatusNotFound)
+	case errors.Is(err, repository.ErrMetricNotFound), err == nil && deleted == 0:
+		http.Error(res, "No metric found", http.StatusNotFound)
+	case err != nil:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	default:
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteBatch удаляет пачку метрик, переданных в JSON массивом {id, type, labels}
+// POST /delete
+func (s *Server) DeleteBatch(res http.ResponseWriter, req *http.Request) {
+	var metrics []models.Metrics
+	if err := json.NewDecoder(req.Body).Decode(&metrics); err != nil {
+		http.Error(res, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	deleted, err := s.storage.Delete(req.Context(), metrics)
+	if isBadMetric(err) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(deleteResult{Deleted: deleted})
+}
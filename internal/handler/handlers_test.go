@@ -1,13 +1,17 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/go-chi/chi/v5"
+	"github.com/tladugin/yaProject.git/internal/models"
 	"github.com/tladugin/yaProject.git/internal/repository"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type gauge struct {
@@ -17,13 +21,13 @@ type gauge struct {
 
 func TestServer_GetHandler(t *testing.T) {
 
-	gaugeTest := *repository.NewMemStorage()
+	gaugeTest := repository.NewMemStorage()
 	gaugeTest.AddGauge("test_gauge", 123.45)
 	//gaugeTest.AddGauge("heap", 678.90)
-	counterTest := *repository.NewMemStorage()
+	counterTest := repository.NewMemStorage()
 	counterTest.AddCounter("test_counter", 100)
 	//counterTest.AddCounter("misses", 5)
-	//bothTest := *repository.NewMemStorage()
+	//bothTest := repository.NewMemStorage()
 	//bothTest.AddGauge("alloc", 123.45)
 	//bothTest.AddCounter("hits", 100)
 	tests := []struct {
@@ -36,14 +40,14 @@ func TestServer_GetHandler(t *testing.T) {
 		{
 			name:       "Get existing gauge",
 			url:        "/value/gauge/test_gauge",
-			storage:    &gaugeTest,
+			storage:    gaugeTest,
 			wantStatus: http.StatusOK,
 			wantBody:   "123.45",
 		},
 		{
 			name:       "Get existing counter",
 			url:        "/value/counter/test_counter",
-			storage:    &counterTest,
+			storage:    counterTest,
 			wantStatus: http.StatusOK,
 			wantBody:   "100",
 		},
@@ -52,21 +56,21 @@ func TestServer_GetHandler(t *testing.T) {
 			{
 					name:       "Get non-existent gauge",
 					url:        "/value/gauge/unknown",
-					storage:    &gaugeTest,
+					storage:    gaugeTest,
 					wantStatus: http.StatusNotFound,
 					wantBody:   "No metric found",
 				},
 			{
 				name:       "Get non-existent counter",
 				url:        "/value/counter/unknown",
-				storage:    &counterTest,
+				storage:    counterTest,
 				wantStatus: http.StatusNotFound,
 				wantBody:   "No metric found",
 			},
 			{
 				name:       "Invalid metric type",
 				url:        "/value/invalid/test",
-				storage:    &gaugeTest,
+				storage:    gaugeTest,
 				wantStatus: http.StatusNotFound,
 				wantBody:   "Invalid metric value",
 			},
@@ -112,14 +116,14 @@ func TestServer_GetHandler(t *testing.T) {
 
 func TestServer_MainPage(t *testing.T) {
 
-	emptyTest := *repository.NewMemStorage()
-	gaugeTest := *repository.NewMemStorage()
+	emptyTest := repository.NewMemStorage()
+	gaugeTest := repository.NewMemStorage()
 	gaugeTest.AddGauge("alloc", 123.45)
 	gaugeTest.AddGauge("heap", 678.90)
-	counterTest := *repository.NewMemStorage()
+	counterTest := repository.NewMemStorage()
 	counterTest.AddCounter("hits", 100)
 	counterTest.AddCounter("misses", 5)
-	bothTest := *repository.NewMemStorage()
+	bothTest := repository.NewMemStorage()
 	bothTest.AddGauge("alloc", 123.45)
 	bothTest.AddCounter("hits", 100)
 
@@ -135,14 +139,14 @@ func TestServer_MainPage(t *testing.T) {
 	}{
 		{
 			name:            "Empty storage",
-			storage:         &emptyTest,
+			storage:         emptyTest,
 			wantContains:    []string{"<html>", "<body>", "<ul>", "</ul>"},
 			wantNotContains: []string{"<li>"},
 			wantStatus:      http.StatusOK,
 		},
 		{
 			name:    "With gauge metrics",
-			storage: &gaugeTest,
+			storage: gaugeTest,
 			wantContains: []string{
 				"<li>alloc: 123.45</li>",
 				"<li>heap: 678.9</li>",
@@ -151,7 +155,7 @@ func TestServer_MainPage(t *testing.T) {
 		},
 		{
 			name:    "With counter metrics",
-			storage: &counterTest,
+			storage: counterTest,
 			wantContains: []string{
 				"<li>hits: 100</li>",
 				"<li>misses: 5</li>",
@@ -160,7 +164,7 @@ func TestServer_MainPage(t *testing.T) {
 		},
 		{
 			name:    "With both types of metrics",
-			storage: &bothTest,
+			storage: bothTest,
 			wantContains: []string{
 				"<li>alloc: 123.45</li>",
 				"<li>hits: 100</li>",
@@ -341,3 +345,399 @@ func TestServer_PostHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_PostHandlerLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantLabels models.Labels
+	}{
+		{name: "Equality matchers", url: "/update/gauge/cpu/1?match=host=a&match=env=prod", wantStatus: http.StatusOK, wantLabels: models.Labels{"host": "a", "env": "prod"}},
+		{name: "Empty value is absent label", url: "/update/gauge/cpu/1?match=host=", wantStatus: http.StatusOK},
+		{name: "Regexp matcher", url: "/update/gauge/cpu/1?match=host=~a.*", wantStatus: http.StatusBadRequest},
+		{name: "Conflicting values", url: "/update/gauge/cpu/1?match=host=a&match=host=b", wantStatus: http.StatusBadRequest},
+		{name: "Invalid matcher", url: "/update/gauge/cpu/1?match=host", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemStorage()
+			r := chi.NewRouter()
+			r.Post("/update/{metric}/{name}/{value}", NewServer(storage).PostHandler)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.url, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if _, err := storage.Get(context.Background(), models.Gauge, "cpu", tt.wantLabels); err != nil {
+				t.Errorf("series with labels %v not stored: %v", tt.wantLabels, err)
+			}
+		})
+	}
+}
+
+func TestServer_UpdatesGaugesBatch(t *testing.T) {
+	key := "secret"
+
+	tests := []struct {
+		name       string
+		body       string
+		hash       string
+		wantStatus int
+	}{
+		{
+			name:       "Valid batch",
+			body:       `[{"id":"g","type":"gauge","value":1.5},{"id":"c","type":"counter","delta":2}]`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Unknown metric type",
+			body:       `[{"id":"x","type":"unknown","value":1}]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Missing gauge value",
+			body:       `[{"id":"g","type":"gauge"}]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid hash",
+			body:       `[{"id":"g","type":"gauge","value":1.5}]`,
+			hash:       "deadbeef",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemStorage()
+			s := NewServerWithKey(storage, &key)
+
+			req := httptest.NewRequest("POST", "/updates", strings.NewReader(tt.body))
+			if tt.hash != "" {
+				req.Header.Set("HashSHA256", tt.hash)
+			}
+			rr := httptest.NewRecorder()
+
+			s.UpdatesGaugesBatch(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+
+			// Некорректная пачка не должна частично применяться
+			if tt.wantStatus != http.StatusOK && len(storage.GaugeSlice())+len(storage.CounterSlice()) != 0 {
+				t.Error("storage should stay empty after rejected batch")
+			}
+		})
+	}
+}
+
+func TestServer_PostValue_NotFound(t *testing.T) {
+	s := NewServer(repository.NewMemStorage())
+
+	req := httptest.NewRequest("POST", "/value", strings.NewReader(`{"id":"missing","type":"gauge"}`))
+	rr := httptest.NewRecorder()
+
+	s.PostValue(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestServer_GetHistory(t *testing.T) {
+	storage := repository.NewMemStorageWithHistory(10, time.Hour)
+	storage.AddGauge("HeapAlloc", 1)
+	storage.AddGauge("HeapAlloc", 2)
+
+	tests := []struct {
+		name        string
+		url         string
+		wantStatus  int
+		wantSamples int
+	}{
+		{
+			name:        "Existing gauge",
+			url:         "/history/gauge/HeapAlloc",
+			wantStatus:  http.StatusOK,
+			wantSamples: 2,
+		},
+		{
+			name:       "Unknown metric",
+			url:        "/history/gauge/unknown",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Invalid step",
+			url:        "/history/gauge/HeapAlloc?step=abc",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Range in the past",
+			url:        "/history/gauge/HeapAlloc?from=0&to=10",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(storage)
+
+			r := chi.NewRouter()
+			r.Get("/history/{metric}/{name}", s.GetHistory)
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var history models.History
+			if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+				t.Fatalf("invalid JSON response: %v", err)
+			}
+			if len(history.Samples) != tt.wantSamples {
+				t.Errorf("got %d samples, want %d", len(history.Samples), tt.wantSamples)
+			}
+		})
+	}
+}
+
+func TestServer_PostUpdate_Histogram(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "Valid histogram",
+			body:       `{"id":"lat","type":"histogram","histogram":{"bounds":[1,5],"counts":[1,2,0],"sum":6,"count":3}}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Bucket counts do not match bounds",
+			body:       `{"id":"lat","type":"histogram","histogram":{"bounds":[1,5],"counts":[1,2],"sum":6,"count":3}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Valid summary",
+			body:       `{"id":"lat","type":"summary","summary":{"quantiles":[{"q":0.5,"value":2}],"sum":6,"count":3}}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemStorage()
+			s := NewServer(storage)
+
+			req := httptest.NewRequest("POST", "/update", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+
+			s.PostUpdate(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got models.Metrics
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Histogram == nil && got.Summary == nil {
+				t.Errorf("response has no distribution value: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_GetHandler_Labels(t *testing.T) {
+	ctx := context.Background()
+	storage := repository.NewMemStorage()
+	storage.Set(ctx, "cpu", models.Labels{"host": "web-1", "env": "prod"}, 10)
+	storage.Set(ctx, "cpu", models.Labels{"host": "web-2", "env": "prod"}, 20)
+	storage.Set(ctx, "cpu", nil, 30)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Without matchers selects unlabelled series",
+			url:        "/value/gauge/cpu",
+			wantStatus: http.StatusOK,
+			wantBody:   "30",
+		},
+		{
+			name:       "Exact matcher",
+			url:        "/value/gauge/cpu?match=host=web-2",
+			wantStatus: http.StatusOK,
+			wantBody:   "20",
+		},
+		{
+			name:       "Regexp matcher",
+			url:        "/value/gauge/cpu?match=host=~web-1&match=env=prod",
+			wantStatus: http.StatusOK,
+			wantBody:   "10",
+		},
+		{
+			name:       "Ambiguous matcher",
+			url:        "/value/gauge/cpu?match=env=prod",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "No matching series",
+			url:        "/value/gauge/cpu?match=host=db",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Invalid matcher",
+			url:        "/value/gauge/cpu?match=host",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(storage)
+
+			r := chi.NewRouter()
+			r.Get("/value/{metric}/{name}", s.GetHandler)
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_PostValue_Labels(t *testing.T) {
+	storage := repository.NewMemStorage()
+	storage.Add(context.Background(), "hits", models.Labels{"agent_id": "1"}, 7)
+	s := NewServer(storage)
+
+	req := httptest.NewRequest("POST", "/value", strings.NewReader(`{"id":"hits","type":"counter","labels":{"agent_id":"1"}}`))
+	rr := httptest.NewRecorder()
+	s.PostValue(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var got models.Metrics
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Delta == nil || *got.Delta != 7 || got.Labels["agent_id"] != "1" {
+		t.Errorf("unexpected metric: %+v", got)
+	}
+}
+
+func TestServer_DeleteHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{name: "Existing gauge", url: "/value/gauge/g", wantStatus: http.StatusOK},
+		{name: "Labelled counter", url: "/value/counter/c?match=host=a", wantStatus: http.StatusOK},
+		{name: "Missing metric", url: "/value/gauge/missing", wantStatus: http.StatusNotFound},
+		{name: "Unknown type", url: "/value/unknown/g", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemStorage()
+			storage.AddGauge("g", 1)
+			storage.Add(context.Background(), "c", models.Labels{"host": "a"}, 1)
+			s := NewServer(storage)
+
+			r := chi.NewRouter()
+			r.Delete("/value/{metric}/{name}", s.DeleteHandler)
+
+			req := httptest.NewRequest("DELETE", tt.url, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_DeleteBatch(t *testing.T) {
+	storage := repository.NewMemStorage()
+	storage.AddGauge("g1", 1)
+	storage.AddGauge("g2", 2)
+	storage.AddCounter("c", 3)
+	s := NewServer(storage)
+
+	body := `[{"id":"g1","type":"gauge"},{"id":"c","type":"counter"},{"id":"missing","type":"gauge"}]`
+	req := httptest.NewRequest("POST", "/delete", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	s.DeleteBatch(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"deleted":2}` {
+		t.Errorf("unexpected body: %s", got)
+	}
+	if gauges := storage.GaugeSlice(); len(gauges) != 1 || gauges[0].Name != "g2" {
+		t.Errorf("unexpected gauges after delete: %+v", gauges)
+	}
+
+	req = httptest.NewRequest("POST", "/delete", strings.NewReader(`[{"id":"g2","type":"bad"}]`))
+	rr = httptest.NewRecorder()
+	s.DeleteBatch(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for unknown type: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestServerPing_NoDatabase(t *testing.T) {
+	ping := NewServerPingDB(nil)
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		url        string
+		wantStatus int
+	}{
+		{name: "ping", handler: ping.GetPing, url: "/ping", wantStatus: http.StatusInternalServerError},
+		{name: "pool stats", handler: ping.GetDBStats, url: "/debug/db", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// defaultHistoryWindow - период истории, отдаваемый при отсутствии параметра from
+const defaultHistoryWindow = time.Hour
+
+// parseTimeParam разбирает момент времени в формате RFC3339 или unix-время в секундах
+func parseTimeParam(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+// parseStepParam разбирает шаг прореживания: длительность Go ("30s", "1m") или число секунд
+func parseStepParam(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, nil
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil || step < 0 {
+		return 0, fmt.Errorf("invalid step %q", value)
+	}
+	return step, nil
+}
+
+// GetHistory отдает историю значений метрики в JSON
+// GET /history/{metric}/{name}?from=&to=&step=&match=
+func (s *Server) GetHistory(res http.ResponseWriter, req *http.Request) {
+	metric := chi.URLParam(req, "metric")
+	name := chi.URLParam(req, "name")
+
+	historyStore, ok := s.storage.(repository.HistoryStore)
+	if !ok {
+		http.Error(res, "History is not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	query := req.URL.Query()
+	to, err := parseTimeParam(query.Get("to"), time.Now())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-defaultHistoryWindow))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := parseStepParam(query.Get("step"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(res, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	matchers, err := parseMatchers(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	labels, err := s.resolveLabels(req.Context(), metric, name, matchers)
+	var samples []models.Sample
+	if err == nil {
+		samples, err = historyStore.History(req.Context(), metric, name, labels, from, to, step)
+	}
+	switch {
+	case errors.Is(err, errAmbiguousSeries):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, repository.ErrHistoryUnsupported):
+		http.Error(res, "History is not supported by storage", http.StatusNotImplemented)
+		return
+	case errors.Is(err, repository.ErrUnknownType):
+		http.Error(res, "Invalid metric type", http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrMetricNotFound):
+		http.Error(res, "No metric found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(models.History{
+		ID:      name,
+		MType:   metric,
+		Labels:  labels,
+		Samples: samples,
+	})
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// errAmbiguousSeries - матчерам соответствует больше одной серии метрики
+var errAmbiguousSeries = errors.New("label matchers select more than one series")
+
+// parseMatchers разбирает матчеры меток из параметров запроса ?match=host=a&match=env=~prod.*
+func parseMatchers(query url.Values) ([]models.LabelMatcher, error) {
+	var matchers []models.LabelMatcher
+	for _, value := range query["match"] {
+		m, err := models.ParseLabelMatcher(value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// labelsFromMatchers возвращает метки обновления, заданные матчерами равенства ?match=host=a&match=env=prod
+// Пустое значение, как и при выборке, означает отсутствующую метку
+func labelsFromMatchers(matchers []models.LabelMatcher) (models.Labels, error) {
+	var labels models.Labels
+	for _, m := range matchers {
+		if m.Op != models.MatchEqual {
+			return nil, fmt.Errorf("label matcher %s%s%s: only = is allowed for updates", m.Name, m.Op, m.Value)
+		}
+		if prev, ok := labels[m.Name]; ok && prev != m.Value {
+			return nil, fmt.Errorf("label %q is set to %q and %q", m.Name, prev, m.Value)
+		}
+		if m.Value == "" {
+			continue
+		}
+		if labels == nil {
+			labels = make(models.Labels)
+		}
+		labels[m.Name] = m.Value
+	}
+	return labels, nil
+}
+
+// resolveLabels находит метки единственной серии метрики, удовлетворяющей матчерам
+// Без матчеров возвращает nil - метрику без меток
+func (s *Server) resolveLabels(ctx context.Context, mType, name string, matchers []models.LabelMatcher) (models.Labels, error) {
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+
+	metrics, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []models.Labels
+	for _, m := range metrics {
+		if m.MType == mType && m.ID == name && m.Labels.Matches(matchers) {
+			found = append(found, m.Labels)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%s %q: %w", mType, name, repository.ErrMetricNotFound)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%s %q: %w", mType, name, errAmbiguousSeries)
+	}
+}
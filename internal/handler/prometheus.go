@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"bufio"
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// prometheusContentType - тип содержимого текстового формата экспозиции Prometheus
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusFamily - семейство метрик Prometheus: серии одного имени и типа
+type prometheusFamily struct {
+	name    string
+	mType   string
+	metrics []models.Metrics
+}
+
+// PrometheusMetrics отдает все метрики в текстовом формате экспозиции Prometheus.
+// Устаревшие метрики (stale) не отдаются, чтобы Prometheus не хранил значения остановленных агентов
+// GET /metrics
+func (s *Server) PrometheusMetrics(res http.ResponseWriter, req *http.Request) {
+	metrics, err := s.storage.List(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", prometheusContentType)
+	res.WriteHeader(http.StatusOK)
+
+	w := bufio.NewWriter(res)
+	for _, f := range prometheusFamilies(metrics) {
+		writePrometheusFamily(w, f)
+	}
+	_ = w.Flush()
+}
+
+// prometheusFamilies группирует метрики в семейства по очищенному имени.
+// Prometheus отклоняет экспозицию целиком, если в ней повторяются имена семейств, серии
+// или метки серии, поэтому конфликты, возникшие после очистки имен, разрешаются здесь:
+//   - семейство занимает все выводимые имена (у histogram - с суффиксами _bucket, _sum и _count);
+//     если имя занято другим семейством, к нему добавляется суффикс типа, если занято и оно,
+//     метрика пропускается;
+//   - метки le у histogram и quantile у summary переименовываются в exported_le и exported_quantile;
+//   - серия, метки которой совпали после очистки между собой или с другой серией семейства, пропускается
+func prometheusFamilies(metrics []models.Metrics) []prometheusFamily {
+	families := make(map[string]*prometheusFamily) // имя семейства -> семейство
+	owners := make(map[string]*prometheusFamily)   // выводимое имя -> семейство
+	series := make(map[string]bool)                // выведенные серии: имя семейства и метки
+
+	slices.SortFunc(metrics, func(a, b models.Metrics) int {
+		return cmp.Or(
+			cmp.Compare(a.ID, b.ID),
+			cmp.Compare(a.MType, b.MType),
+			cmp.Compare(a.Labels.String(), b.Labels.String()),
+		)
+	})
+	for _, m := range metrics {
+		if m.Stale {
+			continue
+		}
+		f := prometheusFamilyFor(families, owners, sanitizeMetricName(m.ID), m.MType)
+		if f == nil {
+			logger.Sugar.Warnw("Skipping metric in Prometheus exposition: name is taken by another metric",
+				"metric", m.ID, "type", m.MType)
+			continue
+		}
+
+		labels, ok := prometheusLabels(m.Labels, m.MType)
+		if !ok {
+			logger.Sugar.Warnw("Skipping metric in Prometheus exposition: label names collide after sanitizing",
+				"metric", m.ID, "type", m.MType, "labels", m.Labels.String())
+			continue
+		}
+		key := models.SeriesKey(f.name, labels)
+		if series[key] {
+			logger.Sugar.Warnw("Skipping metric in Prometheus exposition: duplicate series after sanitizing",
+				"metric", m.ID, "type", m.MType, "labels", m.Labels.String(), "series", key)
+			continue
+		}
+		series[key] = true
+
+		m.Labels = labels
+		f.metrics = append(f.metrics, m)
+	}
+
+	result := make([]prometheusFamily, 0, len(families))
+	for _, f := range families {
+		if len(f.metrics) > 0 {
+			result = append(result, *f)
+		}
+	}
+	slices.SortFunc(result, func(a, b prometheusFamily) int {
+		return cmp.Compare(a.name, b.name)
+	})
+	return result
+}
+
+// prometheusFamilyFor возвращает семейство типа mType для очищенного имени name:
+// существующее семейство с этим именем или с суффиксом типа либо новое, если его имена свободны.
+// Возвращает nil, если все варианты заняты семействами другого типа
+func prometheusFamilyFor(families, owners map[string]*prometheusFamily, name, mType string) *prometheusFamily {
+	for _, candidate := range []string{name, name + "_" + mType} {
+		if f, ok := families[candidate]; ok && f.mType == mType {
+			return f
+		}
+		names := prometheusSeriesNames(candidate, mType)
+		free := true
+		for _, n := range names {
+			if _, taken := owners[n]; taken {
+				free = false
+				break
+			}
+		}
+		if !free {
+			continue
+		}
+
+		f := &prometheusFamily{name: candidate, mType: mType}
+		families[candidate] = f
+		for _, n := range names {
+			owners[n] = f
+		}
+		return f
+	}
+	return nil
+}
+
+// prometheusSeriesNames возвращает имена, которые выводит семейство name типа mType
+func prometheusSeriesNames(name, mType string) []string {
+	switch mType {
+	case models.Histogram:
+		return []string{name, name + "_bucket", name + "_sum", name + "_count"}
+	case models.Summary:
+		return []string{name, name + "_sum", name + "_count"}
+	default:
+		return []string{name}
+	}
+}
+
+// prometheusLabels возвращает метки с очищенными именами; метка, совпадающая с меткой le
+// или quantile, которую добавляет вывод histogram или summary, получает префикс exported_.
+// Возвращает false, если имена меток совпали после очистки
+func prometheusLabels(labels models.Labels, mType string) (models.Labels, bool) {
+	if len(labels) == 0 {
+		return nil, true
+	}
+	reserved := ""
+	switch mType {
+	case models.Histogram:
+		reserved = "le"
+	case models.Summary:
+		reserved = "quantile"
+	}
+
+	result := make(models.Labels, len(labels))
+	for name, value := range labels {
+		name = sanitizeLabelName(name)
+		if name == reserved {
+			name = "exported_" + name
+		}
+		if _, ok := result[name]; ok {
+			return nil, false
+		}
+		result[name] = value
+	}
+	return result, true
+}
+
+// writePrometheusFamily выводит строку TYPE и серии семейства
+func writePrometheusFamily(w *bufio.Writer, f prometheusFamily) {
+	w.WriteString("# TYPE " + f.name + " " + f.mType + "\n")
+	for _, m := range f.metrics {
+		switch m.MType {
+		case models.Gauge:
+			writeSample(w, f.name, m.Labels, "", "", formatFloat(*m.Value))
+		case models.Counter:
+			writeSample(w, f.name, m.Labels, "", "", strconv.FormatInt(*m.Delta, 10))
+		case models.Histogram:
+			// В Prometheus корзины накопительные: le - число наблюдений не больше границы
+			var cumulative uint64
+			for i, bound := range m.Histogram.Bounds {
+				cumulative += m.Histogram.Counts[i]
+				writeSample(w, f.name+"_bucket", m.Labels, "le", formatFloat(bound), strconv.FormatUint(cumulative, 10))
+			}
+			writeSample(w, f.name+"_bucket", m.Labels, "le", "+Inf", strconv.FormatUint(m.Histogram.Count, 10))
+			writeSample(w, f.name+"_sum", m.Labels, "", "", formatFloat(m.Histogram.Sum))
+			writeSample(w, f.name+"_count", m.Labels, "", "", strconv.FormatUint(m.Histogram.Count, 10))
+		case models.Summary:
+			for _, q := range m.Summary.Quantiles {
+				writeSample(w, f.name, m.Labels, "quantile", formatFloat(q.Q), formatFloat(q.Value))
+			}
+			writeSample(w, f.name+"_sum", m.Labels, "", "", formatFloat(m.Summary.Sum))
+			writeSample(w, f.name+"_count", m.Labels, "", "", strconv.FormatUint(m.Summary.Count, 10))
+		}
+	}
+}
+
+// writeSample выводит строку серии с метками, подготовленными prometheusLabels;
+// extraName/extraValue - дополнительная метка le или quantile
+func writeSample(w *bufio.Writer, name string, labels models.Labels, extraName, extraValue, value string) {
+	w.WriteString(name)
+
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+
+	if len(names) > 0 || extraName != "" {
+		w.WriteByte('{')
+		sep := ""
+		for _, n := range names {
+			w.WriteString(sep + n + `="` + escapeLabelValue(labels[n]) + `"`)
+			sep = ","
+		}
+		if extraName != "" {
+			w.WriteString(sep + extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + value + "\n")
+}
+
+// sanitizeMetricName заменяет недопустимые в имени метрики Prometheus символы
+// ([a-zA-Z_:][a-zA-Z0-9_:]*) на подчеркивание
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName заменяет недопустимые в имени метки Prometheus символы
+// ([a-zA-Z_][a-zA-Z0-9_]*) на подчеркивание
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_',
+			r == ':' && allowColon,
+			r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteString("_")
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// escapeLabelValue экранирует обратную косую черту, кавычку и перевод строки в значении метки
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat форматирует число так, как его разбирает Prometheus
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
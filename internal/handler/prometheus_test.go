@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// observeLogs подменяет глобальный логгер на время теста и возвращает записанные сообщения
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	prev := logger.Sugar
+	logger.Sugar = zap.New(core).Sugar()
+	t.Cleanup(func() { logger.Sugar = prev })
+	return logs
+}
+
+func TestServer_PrometheusMetrics(t *testing.T) {
+	storage := repository.NewMemStorage()
+	storage.AddGauge("Alloc", 1.5)
+	storage.AddCounter("PollCount", 5)
+	storage.AddGauge("PollCount", 2) // то же имя у другого типа
+	storage.AddGauge("http.requests-rate", 3)
+	if err := storage.Set(context.Background(), "cpu", models.Labels{"host": `a"b`, "core.id": "1"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	storage.SetHistogram("latency", models.HistogramValue{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 3}, Sum: 7.5, Count: 6})
+	storage.SetSummary("pause", models.SummaryValue{Quantiles: []models.Quantile{{Q: 0.5, Value: 2}}, Sum: 9, Count: 3})
+
+	want := `# TYPE Alloc gauge
+Alloc 1.5
+# TYPE PollCount counter
+PollCount 5
+# TYPE PollCount_gauge gauge
+PollCount_gauge 2
+# TYPE cpu gauge
+cpu{core_id="1",host="a\"b"} 10
+# TYPE http_requests_rate gauge
+http_requests_rate 3
+# TYPE latency histogram
+latency_bucket{le="0.1"} 1
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 6
+latency_sum 7.5
+latency_count 6
+# TYPE pause summary
+pause{quantile="0.5"} 2
+pause_sum 9
+pause_count 3
+`
+
+	res := httptest.NewRecorder()
+	NewServer(storage).PrometheusMetrics(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusOK)
+	}
+	if got := res.Header().Get("Content-Type"); got != prometheusContentType {
+		t.Errorf("Content-Type = %q, want %q", got, prometheusContentType)
+	}
+	if got := res.Body.String(); got != want {
+		t.Errorf("body:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Alloc", "Alloc"},
+		{"CPUutilization1", "CPUutilization1"},
+		{"http.requests", "http_requests"},
+		{"job:rate", "job:rate"},
+		{"1st", "_1st"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeMetricName(tt.name); got != tt.want {
+				t.Errorf("sanitizeMetricName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+	if got := sanitizeLabelName("job:rate"); got != "job_rate" {
+		t.Errorf("sanitizeLabelName(job:rate) = %q, want job_rate", got)
+	}
+}
+
+func TestServer_PrometheusMetricsCollisions(t *testing.T) {
+	gauge := func(id string, value float64, labels models.Labels) models.Metrics {
+		return models.Metrics{ID: id, MType: models.Gauge, Value: &value, Labels: labels}
+	}
+	counter := func(id string, delta int64) models.Metrics {
+		return models.Metrics{ID: id, MType: models.Counter, Delta: &delta}
+	}
+	histogram := func(id string, labels models.Labels) models.Metrics {
+		return models.Metrics{ID: id, MType: models.Histogram, Labels: labels,
+			Histogram: &models.HistogramValue{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}}
+	}
+	summary := func(id string, labels models.Labels) models.Metrics {
+		return models.Metrics{ID: id, MType: models.Summary, Labels: labels,
+			Summary: &models.SummaryValue{Quantiles: []models.Quantile{{Q: 0.5, Value: 2}}, Sum: 2, Count: 1}}
+	}
+
+	tests := []struct {
+		name     string
+		metrics  []models.Metrics
+		want     string
+		warnings int // пропущенные метрики
+	}{
+		{
+			name:    "Metric names collide, labels differ",
+			metrics: []models.Metrics{gauge("a.b", 1, models.Labels{"host": "x"}), gauge("a-b", 2, models.Labels{"host": "y"})},
+			want:    "# TYPE a_b gauge\na_b{host=\"y\"} 2\na_b{host=\"x\"} 1\n",
+		},
+		{
+			name:     "Metric names collide into one series",
+			metrics:  []models.Metrics{gauge("a.b", 1, nil), gauge("a-b", 2, nil)},
+			want:     "# TYPE a_b gauge\na_b 2\n",
+			warnings: 1,
+		},
+		{
+			name:     "Label names collide",
+			metrics:  []models.Metrics{gauge("cpu", 1, models.Labels{"core.id": "1", "core-id": "2"}), gauge("up", 1, nil)},
+			want:     "# TYPE up gauge\nup 1\n",
+			warnings: 1,
+		},
+		{
+			name:    "Histogram le label",
+			metrics: []models.Metrics{histogram("latency", models.Labels{"le": "user"})},
+			want: "# TYPE latency histogram\n" +
+				"latency_bucket{exported_le=\"user\",le=\"1\"} 1\n" +
+				"latency_bucket{exported_le=\"user\",le=\"+Inf\"} 1\n" +
+				"latency_sum{exported_le=\"user\"} 0.5\n" +
+				"latency_count{exported_le=\"user\"} 1\n",
+		},
+		{
+			name:    "Summary quantile label, gauge keeps le",
+			metrics: []models.Metrics{summary("pause", models.Labels{"quantile": "user"}), gauge("temp", 1, models.Labels{"le": "x"})},
+			want: "# TYPE pause summary\n" +
+				"pause{exported_quantile=\"user\",quantile=\"0.5\"} 2\n" +
+				"pause_sum{exported_quantile=\"user\"} 2\n" +
+				"pause_count{exported_quantile=\"user\"} 1\n" +
+				"# TYPE temp gauge\ntemp{le=\"x\"} 1\n",
+		},
+		{
+			name:    "Gauge named like histogram count",
+			metrics: []models.Metrics{histogram("req", nil), gauge("req_count", 3, nil)},
+			want: "# TYPE req histogram\n" +
+				"req_bucket{le=\"1\"} 1\n" +
+				"req_bucket{le=\"+Inf\"} 1\n" +
+				"req_sum 0.5\n" +
+				"req_count 1\n" +
+				"# TYPE req_count_gauge gauge\nreq_count_gauge 3\n",
+		},
+		{
+			name:    "Histogram named like gauge",
+			metrics: []models.Metrics{gauge("req", 3, nil), histogram("req", nil)},
+			want: "# TYPE req gauge\nreq 3\n" +
+				"# TYPE req_histogram histogram\n" +
+				"req_histogram_bucket{le=\"1\"} 1\n" +
+				"req_histogram_bucket{le=\"+Inf\"} 1\n" +
+				"req_histogram_sum 0.5\n" +
+				"req_histogram_count 1\n",
+		},
+		{
+			name:     "Name and typed name taken",
+			metrics:  []models.Metrics{counter("a.b", 1), counter("a.b.gauge", 2), gauge("a_b", 3, nil)},
+			want:     "# TYPE a_b counter\na_b 1\n# TYPE a_b_gauge counter\na_b_gauge 2\n",
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			storage := repository.NewMemStorage()
+			if err := storage.UpdateBatch(context.Background(), tt.metrics); err != nil {
+				t.Fatal(err)
+			}
+
+			res := httptest.NewRecorder()
+			NewServer(storage).PrometheusMetrics(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if got := res.Body.String(); got != tt.want {
+				t.Errorf("body:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if got := logs.FilterLevelExact(zapcore.WarnLevel).Len(); got != tt.warnings {
+				t.Errorf("warnings = %d, want %d", got, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// RemoteWrite принимает запрос Prometheus remote-write (WriteRequest в protobuf, сжатый snappy)
+// и записывает последние значения серий одной пачкой: серии с суффиксом _total и серии, описанные
+// в metadata как COUNTER, - в counter, остальные - в gauge. Серии histogram и summary пропускаются
+// с предупреждением в логе. Prometheus присылает накопленное значение
+// счетчика, поэтому counter устанавливается равным ему (дробная часть отбрасывается), а не
+// увеличивается: повтор запроса и запись одного счетчика через разные реплики его не удваивают.
+// Подпись HashSHA256 проверяется по телу запроса в том виде, в котором оно пришло (сжатым)
+// POST /api/v1/write
+func (s *Server) RemoteWrite(res http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(res, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if !s.checkHash(res, req, body) {
+		return
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("invalid snappy body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var write pb.WriteRequest
+	if err := proto.Unmarshal(data, &write); err != nil {
+		http.Error(res, fmt.Sprintf("invalid write request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	cumulative, ok := s.storage.(repository.CumulativeStore)
+	if !ok {
+		http.Error(res, "Cumulative counters are not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	metrics, skipped, err := remoteSamples(&write)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(skipped) > 0 {
+		logger.Sugar.Warnw("Skipping remote-write histogram and summary series: not supported", "series", skipped)
+	}
+	if err := cumulative.UpdateCumulative(req.Context(), metrics); err != nil {
+		remoteWriteError(res, err)
+		return
+	}
+
+	metricNames := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		metricNames = append(metricNames, m.ID)
+	}
+
+	// Данные для аудита, как в пакетном обновлении
+	*req = *WithAuditData(req, metricNames, getIPAddress(req))
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWriteError отвечает 400 на некорректную метрику и 500 на ошибку хранилища
+// (Prometheus повторяет запросы только после ответов 5xx)
+func remoteWriteError(res http.ResponseWriter, err error) {
+	if isBadMetric(err) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(res, err.Error(), http.StatusInternalServerError)
+}
+
+// remoteSamples разбирает серии запроса на gauge и counter (с накопленным значением в Delta);
+// для каждой серии берется самое позднее значение, маркеры устаревания (NaN) и ±Inf пропускаются:
+// хранилище и JSON API принимают только конечные значения.
+// Серии histogram и summary (_bucket, _sum, _count и квантили) не собираются в одну метрику:
+// Prometheus может разнести их по разным запросам. Они пропускаются, а их имена возвращаются в skipped,
+// чтобы не записать корзины и суммы как отдельные gauge
+func remoteSamples(write *pb.WriteRequest) (metrics []models.Metrics, skipped []string, err error) {
+	counterFamilies := make(map[string]bool)
+	distributions := make(map[string]bool) // имена серий семейств histogram и summary
+	seen := make(map[string]bool)          // имена уже пропущенных серий
+	for _, md := range write.GetMetadata() {
+		family := md.GetMetricFamilyName()
+		switch md.GetType() {
+		case pb.MetricMetadata_COUNTER:
+			counterFamilies[family] = true
+		case pb.MetricMetadata_HISTOGRAM, pb.MetricMetadata_GAUGEHISTOGRAM, pb.MetricMetadata_SUMMARY:
+			for _, suffix := range []string{"", "_bucket", "_sum", "_count", "_gcount", "_gsum"} {
+				distributions[family+suffix] = true
+			}
+		}
+	}
+
+	for _, ts := range write.GetTimeseries() {
+		var name string
+		var labels models.Labels
+		for _, l := range ts.GetLabels() {
+			switch {
+			case l.GetName() == "__name__":
+				name = l.GetValue()
+			case l.GetValue() != "": // пустая метка в Prometheus равносильна отсутствующей
+				if labels == nil {
+					labels = make(models.Labels)
+				}
+				labels[l.GetName()] = l.GetValue()
+			}
+		}
+		if name == "" {
+			return nil, nil, errors.New("time series without __name__ label")
+		}
+		// Корзина histogram узнается по метке le и без metadata
+		if _, bucket := labels["le"]; distributions[name] || bucket && strings.HasSuffix(name, "_bucket") {
+			if !seen[name] {
+				seen[name] = true
+				skipped = append(skipped, name)
+			}
+			continue
+		}
+
+		var latest *pb.Sample
+		for _, sample := range ts.GetSamples() {
+			if v := sample.GetValue(); math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			if latest == nil || sample.GetTimestamp() >= latest.GetTimestamp() {
+				latest = sample
+			}
+		}
+		if latest == nil {
+			continue
+		}
+
+		value := latest.GetValue()
+		if counterFamilies[name] || strings.HasSuffix(name, "_total") {
+			// float64(math.MaxInt64) округляется до 2^63, которое в int64 уже не помещается
+			if value < 0 || value >= math.MaxInt64 {
+				return nil, nil, fmt.Errorf("counter %q value %v is out of range", name, value)
+			}
+			delta := int64(math.Floor(value))
+			metrics = append(metrics, models.Metrics{ID: name, MType: models.Counter, Delta: &delta, Labels: labels})
+			continue
+		}
+		metrics = append(metrics, models.Metrics{ID: name, MType: models.Gauge, Value: &value, Labels: labels})
+	}
+	return metrics, skipped, nil
+}
@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// remoteRequest возвращает запрос remote-write с телом write, сжатым snappy
+func remoteRequest(t *testing.T, write *pb.WriteRequest) *http.Request {
+	t.Helper()
+	data, err := proto.Marshal(write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	return req
+}
+
+// series возвращает серию с одним значением
+func series(name string, value float64, labels ...string) *pb.TimeSeries {
+	ts := &pb.TimeSeries{
+		Labels:  []*pb.Label{{Name: "__name__", Value: name}},
+		Samples: []*pb.Sample{{Value: value, Timestamp: 1000}},
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		ts.Labels = append(ts.Labels, &pb.Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return ts
+}
+
+func TestServer_RemoteWrite(t *testing.T) {
+	storage := repository.NewMemStorage()
+	s := NewServer(storage)
+	ctx := context.Background()
+
+	send := func(write *pb.WriteRequest) *httptest.ResponseRecorder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		s.RemoteWrite(res, remoteRequest(t, write))
+		return res
+	}
+
+	res := send(&pb.WriteRequest{
+		Timeseries: []*pb.TimeSeries{
+			series("temperature", 21.5, "room", "kitchen", "empty", ""),
+			series("requests_total", 10.7, "job", "api"),
+			series("errors", 4),
+			{
+				Labels: []*pb.Label{{Name: "__name__", Value: "latest"}},
+				Samples: []*pb.Sample{
+					{Value: 2, Timestamp: 2000},
+					{Value: 1, Timestamp: 1000},
+					{Value: math.Float64frombits(0x7ff0000000000002), Timestamp: 3000}, // маркер устаревания
+				},
+			},
+		},
+		Metadata: []*pb.MetricMetadata{{Type: pb.MetricMetadata_COUNTER, MetricFamilyName: "errors"}},
+	})
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", res.Code, http.StatusNoContent, res.Body.String())
+	}
+
+	gauges := []struct {
+		name   string
+		labels models.Labels
+		want   float64
+	}{
+		{"temperature", models.Labels{"room": "kitchen"}, 21.5},
+		{"latest", nil, 2},
+	}
+	for _, g := range gauges {
+		m, err := storage.Get(ctx, models.Gauge, g.name, g.labels)
+		if err != nil || *m.Value != g.want {
+			t.Errorf("gauge %s = %+v, %v; want %v", g.name, m, err, g.want)
+		}
+	}
+
+	// Counter повторяет накопленное значение источника: 10.7 -> 10, затем 15, сброс до 3, 3 повторно
+	wantCounters := []struct {
+		value float64
+		want  int64
+	}{
+		{15, 15},
+		{3, 3},
+		{3, 3},
+	}
+	counter := func() int64 {
+		t.Helper()
+		m, err := storage.Get(ctx, models.Counter, "requests_total", models.Labels{"job": "api"})
+		if err != nil {
+			t.Fatalf("requests_total: %v", err)
+		}
+		return *m.Delta
+	}
+	if got := counter(); got != 10 {
+		t.Fatalf("requests_total = %d, want 10", got)
+	}
+	for _, c := range wantCounters {
+		if res := send(&pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("requests_total", c.value, "job", "api")}}); res.Code != http.StatusNoContent {
+			t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+		}
+		if got := counter(); got != c.want {
+			t.Errorf("after %v requests_total = %d, want %d", c.value, got, c.want)
+		}
+	}
+
+	m, err := storage.Get(ctx, models.Counter, "errors", nil)
+	if err != nil || *m.Delta != 4 {
+		t.Errorf("errors = %+v, %v; want counter 4", m, err)
+	}
+}
+
+func TestServer_RemoteWriteCounterFromStorage(t *testing.T) {
+	// Счетчик уже есть в хранилище (например, после перезапуска сервера):
+	// значение заменяется накопленным значением источника
+	storage := repository.NewMemStorage()
+	if err := storage.Add(context.Background(), "requests_total", nil, 100); err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	NewServer(storage).RemoteWrite(res, remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("requests_total", 120)}}))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+	}
+	m, err := storage.Get(context.Background(), models.Counter, "requests_total", nil)
+	if err != nil || *m.Delta != 120 {
+		t.Errorf("requests_total = %+v, %v; want 120", m, err)
+	}
+}
+
+func TestServer_RemoteWriteReplicas(t *testing.T) {
+	// Две реплики с общим хранилищем: повтор запроса на другой реплике не удваивает счетчик
+	storage := repository.NewMemStorage()
+	replicas := []*Server{NewServer(storage), NewServer(storage)}
+	writes := []struct {
+		replica int
+		value   float64
+	}{
+		{0, 100},
+		{1, 100}, // повтор после таймаута
+		{1, 120},
+		{0, 130},
+	}
+	for _, w := range writes {
+		res := httptest.NewRecorder()
+		replicas[w.replica].RemoteWrite(res, remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("requests_total", w.value)}}))
+		if res.Code != http.StatusNoContent {
+			t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+		}
+	}
+	m, err := storage.Get(context.Background(), models.Counter, "requests_total", nil)
+	if err != nil || *m.Delta != 130 {
+		t.Errorf("requests_total = %+v, %v; want 130", m, err)
+	}
+}
+
+// batchStore - хранилище в памяти, считающее записи пачек; при fail запись отклоняется целиком
+type batchStore struct {
+	*repository.MemStorage
+	batches int
+	fail    bool
+}
+
+func (s *batchStore) UpdateCumulative(ctx context.Context, metrics []models.Metrics) error {
+	s.batches++
+	if s.fail {
+		return errors.New("database unavailable")
+	}
+	return s.MemStorage.UpdateCumulative(ctx, metrics)
+}
+
+func TestServer_RemoteWriteSingleBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := &batchStore{MemStorage: repository.NewMemStorage()}
+	s := NewServer(storage)
+	write := func(value float64) int {
+		t.Helper()
+		res := httptest.NewRecorder()
+		s.RemoteWrite(res, remoteRequest(t, &pb.WriteRequest{
+			Timeseries: []*pb.TimeSeries{series("load", value), series("jobs_total", value)},
+		}))
+		return res.Code
+	}
+
+	if code := write(10); code != http.StatusNoContent || storage.batches != 1 {
+		t.Fatalf("status = %d, batches = %d; want %d, 1", code, storage.batches, http.StatusNoContent)
+	}
+
+	// Ошибка хранилища: не записан ни gauge, ни counter, Prometheus повторит запрос
+	storage.fail = true
+	if code := write(15); code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", code, http.StatusInternalServerError)
+	}
+	storage.fail = false
+	if m, err := storage.Get(ctx, models.Gauge, "load", nil); err != nil || *m.Value != 10 {
+		t.Errorf("load after failed write = %+v, %v; want 10", m, err)
+	}
+
+	// Повтор после ошибки записывает значение один раз
+	if code := write(15); code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+	}
+	if m, err := storage.Get(ctx, models.Counter, "jobs_total", nil); err != nil || *m.Delta != 15 {
+		t.Errorf("jobs_total = %+v, %v; want 15", m, err)
+	}
+	if m, err := storage.Get(ctx, models.Gauge, "load", nil); err != nil || *m.Value != 15 {
+		t.Errorf("load = %+v, %v; want 15", m, err)
+	}
+}
+
+func TestServer_RemoteWriteErrors(t *testing.T) {
+	key := "secret"
+	s := NewServerWithKey(repository.NewMemStorage(), &key)
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		status  int
+	}{
+		{
+			name: "Series without name",
+			request: func() *http.Request {
+				return remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{{Samples: []*pb.Sample{{Value: 1}}}}})
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "Not snappy",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte("plain")))
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "Counter out of range",
+			request: func() *http.Request {
+				return remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("jobs_total", -1)}})
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "Wrong signature",
+			request: func() *http.Request {
+				req := remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("up", 1)}})
+				req.Header.Set("HashSHA256", Sign("other", nil))
+				return req
+			},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			s.RemoteWrite(res, tt.request())
+			if res.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", res.Code, tt.status, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_RemoteWriteUnsupportedStorage(t *testing.T) {
+	// Хранилище без CumulativeStore: встроенный интерфейс скрывает остальные методы MemStorage
+	storage := struct{ repository.MetricStore }{repository.NewMemStorage()}
+	res := httptest.NewRecorder()
+	NewServer(storage).RemoteWrite(res, remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("up", 1)}}))
+	if res.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", res.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestServer_RemoteWriteSkipsDistributions(t *testing.T) {
+	logs := observeLogs(t)
+	storage := repository.NewMemStorage()
+	write := &pb.WriteRequest{
+		Timeseries: []*pb.TimeSeries{
+			// histogram, описанный в metadata
+			series("latency_seconds_bucket", 3, "le", "0.5"),
+			series("latency_seconds_bucket", 5, "le", "+Inf"),
+			series("latency_seconds_sum", 1.2),
+			series("latency_seconds_count", 5),
+			// summary, описанный в metadata
+			series("rpc_seconds", 0.1, "quantile", "0.5"),
+			series("rpc_seconds_sum", 4),
+			series("rpc_seconds_count", 9),
+			// корзина histogram без metadata узнается по метке le
+			series("size_bytes_bucket", 2, "le", "100"),
+			series("temperature", 21.5),
+		},
+		Metadata: []*pb.MetricMetadata{
+			{Type: pb.MetricMetadata_HISTOGRAM, MetricFamilyName: "latency_seconds"},
+			{Type: pb.MetricMetadata_SUMMARY, MetricFamilyName: "rpc_seconds"},
+		},
+	}
+
+	res := httptest.NewRecorder()
+	NewServer(storage).RemoteWrite(res, remoteRequest(t, write))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", res.Code, res.Body.String())
+	}
+
+	metrics, err := storage.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].ID != "temperature" {
+		t.Errorf("stored %+v, want temperature only", metrics)
+	}
+	warnings := logs.FilterMessageSnippet("histogram and summary").All()
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %d, want 1", len(warnings))
+	}
+	want := "[latency_seconds_bucket latency_seconds_sum latency_seconds_count rpc_seconds rpc_seconds_sum rpc_seconds_count size_bytes_bucket]"
+	if got := fmt.Sprint(warnings[0].ContextMap()["series"]); got != want {
+		t.Errorf("skipped series = %v, want %v", got, want)
+	}
+}
+
+func TestServer_RemoteWriteAuditData(t *testing.T) {
+	req := remoteRequest(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{series("up", 1), series("jobs_total", 2)}})
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+
+	NewServer(repository.NewMemStorage()).RemoteWrite(httptest.NewRecorder(), req)
+
+	metrics, ip := GetAuditData(req)
+	if len(metrics) != 2 || metrics[0] != "up" || metrics[1] != "jobs_total" || ip != "10.0.0.1" {
+		t.Errorf("audit data = %v, %q; want [up jobs_total], 10.0.0.1", metrics, ip)
+	}
+}
+
+func TestServer_RemoteWriteNonFinite(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		value float64
+	}{
+		{name: "+Inf", value: math.Inf(1)},
+		{name: "-Inf", value: math.Inf(-1)},
+		{name: "NaN", value: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemStorage()
+			res := httptest.NewRecorder()
+			NewServer(storage).RemoteWrite(res, remoteRequest(t, &pb.WriteRequest{
+				Timeseries: []*pb.TimeSeries{
+					series("load", tt.value),
+					series("jobs_total", tt.value),
+					{
+						Labels:  []*pb.Label{{Name: "__name__", Value: "latest"}},
+						Samples: []*pb.Sample{{Value: 1, Timestamp: 1000}, {Value: tt.value, Timestamp: 2000}},
+					},
+				},
+			}))
+			if res.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d: %s", res.Code, http.StatusNoContent, res.Body.String())
+			}
+			if _, err := storage.Get(ctx, models.Gauge, "load", nil); err == nil {
+				t.Error("gauge with non-finite value is stored")
+			}
+			if _, err := storage.Get(ctx, models.Counter, "jobs_total", nil); err == nil {
+				t.Error("counter with non-finite value is stored")
+			}
+			if m, err := storage.Get(ctx, models.Gauge, "latest", nil); err != nil || *m.Value != 1 {
+				t.Errorf("latest = %+v, %v; want last finite value 1", m, err)
+			}
+		})
+	}
+}
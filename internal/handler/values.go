@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Размер страницы списка метрик
+const (
+	defaultValuesLimit = 100
+	maxValuesLimit     = 1000
+)
+
+// Поля сортировки списка метрик
+const (
+	sortByName    = "name"
+	sortByValue   = "value"
+	sortByUpdated = "updated"
+)
+
+// errCursorMismatch - курсор получен для другого порядка сортировки
+var errCursorMismatch = errors.New("cursor does not match sort order")
+
+// valuesFilter - фильтры списка метрик; пустые поля не ограничивают выборку
+type valuesFilter struct {
+	mType    string
+	prefix   string
+	glob     string
+	regex    *regexp.Regexp
+	matchers []models.LabelMatcher
+}
+
+// parseValuesFilter разбирает фильтры из параметров запроса ?type=&prefix=&glob=&regex=&match=
+func parseValuesFilter(query url.Values) (valuesFilter, error) {
+	f := valuesFilter{
+		mType:  query.Get("type"),
+		prefix: query.Get("prefix"),
+		glob:   query.Get("glob"),
+	}
+
+	switch f.mType {
+	case "", models.Gauge, models.Counter, models.Histogram, models.Summary:
+	default:
+		return valuesFilter{}, fmt.Errorf("invalid metric type %q", f.mType)
+	}
+	if f.glob != "" {
+		if _, err := path.Match(f.glob, ""); err != nil {
+			return valuesFilter{}, fmt.Errorf("invalid glob %q: %w", f.glob, err)
+		}
+	}
+	if expr := query.Get("regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return valuesFilter{}, fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		f.regex = re
+	}
+
+	matchers, err := parseMatchers(query)
+	if err != nil {
+		return valuesFilter{}, err
+	}
+	f.matchers = matchers
+	return f, nil
+}
+
+// matches проверяет, подходит ли метрика под все фильтры
+func (f valuesFilter) matches(m *models.Metrics) bool {
+	if f.mType != "" && m.MType != f.mType {
+		return false
+	}
+	if !strings.HasPrefix(m.ID, f.prefix) {
+		return false
+	}
+	if f.glob != "" {
+		if ok, _ := path.Match(f.glob, m.ID); !ok {
+			return false
+		}
+	}
+	if f.regex != nil && !f.regex.MatchString(m.ID) {
+		return false
+	}
+	return m.Labels.Matches(f.matchers)
+}
+
+// valuesCursor - позиция последней метрики страницы в порядке сортировки.
+// Курсор указывает на позицию, а не на номер строки, поэтому страницы не смещаются
+// при добавлении и удалении метрик между запросами
+type valuesCursor struct {
+	Order   string    `json:"o"`
+	Series  string    `json:"s"`
+	Type    string    `json:"t"`
+	Value   float64   `json:"v,omitempty"`
+	Updated time.Time `json:"u,omitempty"`
+}
+
+// metricValue возвращает значение метрики для сортировки: значение gauge, counter
+// или сумму наблюдений histogram и summary
+func metricValue(m *models.Metrics) float64 {
+	switch {
+	case m.Value != nil:
+		return *m.Value
+	case m.Delta != nil:
+		return float64(*m.Delta)
+	case m.Histogram != nil:
+		return m.Histogram.Sum
+	case m.Summary != nil:
+		return m.Summary.Sum
+	}
+	return 0
+}
+
+// cursorOf возвращает позицию метрики в порядке сортировки order
+func cursorOf(m *models.Metrics, order string) valuesCursor {
+	c := valuesCursor{
+		Order:  order,
+		Series: models.SeriesKey(m.ID, m.Labels),
+		Type:   m.MType,
+		Value:  metricValue(m),
+	}
+	if m.UpdatedAt != nil {
+		c.Updated = m.UpdatedAt.UTC()
+	}
+	return c
+}
+
+// encode кодирует курсор для параметра ?cursor=
+func (c valuesCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает курсор и проверяет, что он получен для того же порядка сортировки
+func decodeCursor(s, order string) (valuesCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return valuesCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var c valuesCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return valuesCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if c.Order != order {
+		return valuesCursor{}, errCursorMismatch
+	}
+	return c, nil
+}
+
+// compareCursors сравнивает позиции по полю sortBy; при равенстве - по серии и типу,
+// чтобы порядок был полным и курсор однозначно указывал на метрику
+func compareCursors(sortBy string, a, b valuesCursor) int {
+	var c int
+	switch sortBy {
+	case sortByValue:
+		c = cmp.Compare(a.Value, b.Value)
+	case sortByUpdated:
+		c = a.Updated.Compare(b.Updated)
+	}
+	if c != 0 {
+		return c
+	}
+	if c = strings.Compare(a.Series, b.Series); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Type, b.Type)
+}
+
+// parsePaging разбирает сортировку и размер страницы из параметров ?sort=&order=&limit=
+func parsePaging(query url.Values) (sortBy string, desc bool, limit int, err error) {
+	sortBy = cmp.Or(query.Get("sort"), sortByName)
+	switch sortBy {
+	case sortByName, sortByValue, sortByUpdated:
+	default:
+		return "", false, 0, fmt.Errorf("invalid sort %q, expected name, value or updated", sortBy)
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return "", false, 0, fmt.Errorf("invalid order %q, expected asc or desc", query.Get("order"))
+	}
+
+	limit = defaultValuesLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxValuesLimit {
+			return "", false, 0, fmt.Errorf("invalid limit %q, expected 1..%d", value, maxValuesLimit)
+		}
+	}
+	return sortBy, desc, limit, nil
+}
+
+// ListValues отдает список метрик в JSON с фильтрами, сортировкой и постраничным выводом
+// GET /values?type=&prefix=&glob=&regex=&match=&sort=&order=&limit=&cursor=&count=
+func (s *Server) ListValues(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter, err := parseValuesFilter(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	countOnly := false
+	if value := query.Get("count"); value != "" {
+		if countOnly, err = strconv.ParseBool(value); err != nil {
+			http.Error(res, fmt.Sprintf("invalid count %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+	sortBy, desc, limit, err := parsePaging(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order := sortBy
+	if desc {
+		order += ":desc"
+	}
+	var after *valuesCursor
+	if value := query.Get("cursor"); value != "" {
+		c, err := decodeCursor(value, order)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = &c
+	}
+
+	metrics, err := s.storage.List(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matched := make([]models.Metrics, 0, len(metrics))
+	for i := range metrics {
+		if filter.matches(&metrics[i]) {
+			matched = append(matched, metrics[i])
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if countOnly {
+		json.NewEncoder(res).Encode(models.MetricsCount{Count: len(matched)})
+		return
+	}
+
+	compare := func(a, b valuesCursor) int {
+		if desc {
+			return compareCursors(sortBy, b, a)
+		}
+		return compareCursors(sortBy, a, b)
+	}
+	slices.SortFunc(matched, func(a, b models.Metrics) int {
+		return compare(cursorOf(&a, order), cursorOf(&b, order))
+	})
+
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(matched, *after, func(m models.Metrics, c valuesCursor) int {
+			if compare(cursorOf(&m, order), c) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+limit, len(matched))
+
+	page := models.MetricsPage{Metrics: matched[start:end], Total: len(matched)}
+	if end < len(matched) {
+		page.NextCursor = cursorOf(&matched[end-1], order).encode()
+	}
+	json.NewEncoder(res).Encode(page)
+}
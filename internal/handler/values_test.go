@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/internal/repository"
+)
+
+// valuesStorage возвращает хранилище с набором метрик для тестов списка
+func valuesStorage(t *testing.T) *repository.MemStorage {
+	t.Helper()
+	storage := repository.NewMemStorage()
+	storage.AddGauge("HeapAlloc", 300)
+	storage.AddGauge("HeapInuse", 100)
+	storage.AddGauge("Sys", 200)
+	storage.AddCounter("PollCount", 5)
+	if err := storage.Set(context.Background(), "cpu", models.Labels{"host": "a"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Set(context.Background(), "cpu", models.Labels{"host": "b"}, 20); err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// seriesNames возвращает ключи серий страницы
+func seriesNames(metrics []models.Metrics) []string {
+	names := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, models.SeriesKey(m.ID, m.Labels))
+	}
+	return names
+}
+
+func TestServer_ListValues(t *testing.T) {
+	s := NewServer(valuesStorage(t))
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantNames  []string
+		wantTotal  int
+	}{
+		{
+			name:       "All metrics sorted by name",
+			url:        "/values",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"HeapAlloc", "HeapInuse", "PollCount", "Sys", `cpu{host="a"}`, `cpu{host="b"}`},
+			wantTotal:  6,
+		},
+		{
+			name:       "Type filter",
+			url:        "/values?type=counter",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"PollCount"},
+			wantTotal:  1,
+		},
+		{
+			name:       "Prefix filter sorted by value desc",
+			url:        "/values?prefix=Heap&sort=value&order=desc",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"HeapAlloc", "HeapInuse"},
+			wantTotal:  2,
+		},
+		{
+			name:       "Glob filter",
+			url:        "/values?glob=*Alloc",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"HeapAlloc"},
+			wantTotal:  1,
+		},
+		{
+			name:       "Regex filter",
+			url:        "/values?regex=^(Sys|Poll)",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"PollCount", "Sys"},
+			wantTotal:  2,
+		},
+		{
+			name:       "Label filter",
+			url:        "/values?match=host=b",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{`cpu{host="b"}`},
+			wantTotal:  1,
+		},
+		{
+			name:       "Invalid type",
+			url:        "/values?type=unknown",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid regex",
+			url:        "/values?regex=(",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid sort",
+			url:        "/values?sort=size",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid limit",
+			url:        "/values?limit=0",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid cursor",
+			url:        "/values?cursor=!!!",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+			s.ListValues(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var page models.MetricsPage
+			if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+				t.Fatalf("invalid JSON response: %v", err)
+			}
+			got := seriesNames(page.Metrics)
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("got series %v, want %v", got, tt.wantNames)
+			}
+			for i := range got {
+				if got[i] != tt.wantNames[i] {
+					t.Fatalf("got series %v, want %v", got, tt.wantNames)
+				}
+			}
+			if page.Total != tt.wantTotal {
+				t.Errorf("got total %d, want %d", page.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestServer_ListValues_Pagination(t *testing.T) {
+	storage := valuesStorage(t)
+	s := NewServer(storage)
+
+	get := func(url string) models.MetricsPage {
+		t.Helper()
+		req := httptest.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		s.ListValues(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var page models.MetricsPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid JSON response: %v", err)
+		}
+		return page
+	}
+
+	first := get("/values?sort=value&limit=4")
+	if got := seriesNames(first.Metrics); len(got) != 4 || got[0] != "PollCount" || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %v, cursor %q", got, first.NextCursor)
+	}
+
+	// Метрика, добавленная перед курсором, не сдвигает следующую страницу
+	storage.AddGauge("Early", 1)
+
+	second := get("/values?sort=value&limit=4&cursor=" + first.NextCursor)
+	got := seriesNames(second.Metrics)
+	if len(got) != 2 || got[0] != "Sys" || got[1] != "HeapAlloc" {
+		t.Fatalf("unexpected second page %v", got)
+	}
+	if second.Total != 7 {
+		t.Errorf("got total %d, want 7", second.Total)
+	}
+	if second.NextCursor != "" {
+		t.Errorf("last page has cursor %q", second.NextCursor)
+	}
+
+	req := httptest.NewRequest("GET", "/values?sort=name&cursor="+first.NextCursor, nil)
+	rr := httptest.NewRecorder()
+	s.ListValues(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("cursor with other sort order: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest("GET", "/values?type=gauge&count=true", nil)
+	rr = httptest.NewRecorder()
+	s.ListValues(rr, req)
+	var count models.MetricsCount
+	if err := json.Unmarshal(rr.Body.Bytes(), &count); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if count.Count != 6 {
+		t.Errorf("got count %d, want 6", count.Count)
+	}
+}
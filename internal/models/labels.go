@@ -0,0 +1,190 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels - метки (измерения) метрики, например host, agent_id, env
+// Метки входят в идентичность метрики: одно имя с разными метками - разные серии
+type Labels map[string]string
+
+// names возвращает имена меток в порядке возрастания
+func (l Labels) names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String возвращает метки в виде {k1="v1",k2="v2"} с сортировкой по имени,
+// для пустого набора - пустую строку. Имена не из букв, цифр и символов _.:- выводятся в кавычках,
+// чтобы разные наборы меток не давали одну строку
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range l.names() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if plainLabelName(name) {
+			b.WriteString(name)
+		} else {
+			b.WriteString(strconv.Quote(name))
+		}
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// plainLabelName проверяет, что имя метки можно вывести без кавычек:
+// оно непустое и не содержит разделителей = , " { }
+func plainLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '.', r == ':', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Clone возвращает копию меток, для пустого набора - nil
+func (l Labels) Clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	clone := make(Labels, len(l))
+	for k, v := range l {
+		clone[k] = v
+	}
+	return clone
+}
+
+// Reset очищает метки, сохраняя выделенную память (используется в сгенерированном Metrics.Reset)
+func (l *Labels) Reset() {
+	clear(*l)
+}
+
+// Matches проверяет, что метки удовлетворяют всем матчерам
+func (l Labels) Matches(matchers []LabelMatcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(l[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SeriesKey возвращает ключ серии: имя метрики и отсортированные метки,
+// например cpu{env="prod",host="a"}; для метрики без меток ключ совпадает с именем.
+// Имя с символами { или " выводится в кавычках, поэтому ключи разных серий не совпадают:
+// метрика cpu{host="a"} без меток получает ключ "cpu{host=\"a\"}"
+func SeriesKey(name string, labels Labels) string {
+	if strings.ContainsAny(name, `{"`) {
+		name = strconv.Quote(name)
+	}
+	return name + labels.String()
+}
+
+// ParseLabels разбирает метки из строки вида "host=a,env=prod"
+func ParseLabels(s string) (Labels, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	labels := make(Labels)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q: expected name=value", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// MatchOp - операция сравнения значения метки
+type MatchOp string
+
+const (
+	MatchEqual     MatchOp = "="  // значение равно
+	MatchNotEqual  MatchOp = "!=" // значение не равно
+	MatchRegexp    MatchOp = "=~" // значение полностью совпадает с регулярным выражением
+	MatchNotRegexp MatchOp = "!~" // значение не совпадает с регулярным выражением
+)
+
+// LabelMatcher - условие на значение метки
+// Отсутствующая метка сравнивается как пустая строка
+type LabelMatcher struct {
+	Name  string
+	Op    MatchOp
+	Value string
+
+	re *regexp.Regexp
+}
+
+// ParseLabelMatcher разбирает матчер вида host=a, env!=dev, host=~web-.* или host!~db.*
+func ParseLabelMatcher(s string) (LabelMatcher, error) {
+	idx := strings.IndexAny(s, "=!")
+	if idx <= 0 {
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher %q", s)
+	}
+
+	m := LabelMatcher{Name: strings.TrimSpace(s[:idx])}
+	rest := s[idx:]
+	switch {
+	case strings.HasPrefix(rest, string(MatchNotEqual)):
+		m.Op = MatchNotEqual
+	case strings.HasPrefix(rest, string(MatchRegexp)):
+		m.Op = MatchRegexp
+	case strings.HasPrefix(rest, string(MatchNotRegexp)):
+		m.Op = MatchNotRegexp
+	case strings.HasPrefix(rest, string(MatchEqual)):
+		m.Op = MatchEqual
+	default:
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher %q", s)
+	}
+	m.Value = rest[len(m.Op):]
+
+	if m.Op == MatchRegexp || m.Op == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return LabelMatcher{}, fmt.Errorf("invalid label matcher %q: %w", s, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches проверяет значение метки
+func (m LabelMatcher) Matches(value string) bool {
+	switch m.Op {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package models
+
+import "testing"
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{name: "cpu", want: "cpu"},
+		{name: "cpu", labels: Labels{}, want: "cpu"},
+		{name: "cpu", labels: Labels{"host": "a", "env": "prod"}, want: `cpu{env="prod",host="a"}`},
+		{name: "http.requests-rate", labels: Labels{"core.id": "1"}, want: `http.requests-rate{core.id="1"}`},
+		// Имена с разделителями выводятся в кавычках
+		{name: `cpu{host="a"}`, want: `"cpu{host=\"a\"}"`},
+		{name: "cpu", labels: Labels{`a="x",b`: "y"}, want: `cpu{"a=\"x\",b"="y"}`},
+		{name: "cpu", labels: Labels{"": "y"}, want: `cpu{""="y"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := SeriesKey(tt.name, tt.labels); got != tt.want {
+				t.Errorf("SeriesKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesKey_Unique(t *testing.T) {
+	// Серии, ключи которых совпадали при выводе имен без кавычек
+	series := []struct {
+		name   string
+		labels Labels
+	}{
+		{name: "cpu", labels: Labels{"host": "a"}},
+		{name: `cpu{host="a"}`},
+		{name: "cpu", labels: Labels{"a": "x", "b": "y"}},
+		{name: "cpu", labels: Labels{`a="x",b`: "y"}},
+	}
+
+	keys := make(map[string]int)
+	for i, s := range series {
+		key := SeriesKey(s.name, s.labels)
+		if j, ok := keys[key]; ok {
+			t.Errorf("series %d and %d share key %s", j, i, key)
+		}
+		keys[key] = i
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("host=a, env=prod")
+	if err != nil {
+		t.Fatalf("ParseLabels failed: %v", err)
+	}
+	if labels["host"] != "a" || labels["env"] != "prod" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	if _, err := ParseLabels("host"); err == nil {
+		t.Error("expected error for label without value")
+	}
+}
+
+func TestLabelMatcher(t *testing.T) {
+	labels := Labels{"host": "web-1", "env": "prod"}
+
+	tests := []struct {
+		matcher string
+		want    bool
+		wantErr bool
+	}{
+		{matcher: "host=web-1", want: true},
+		{matcher: "host!=web-1", want: false},
+		{matcher: "host=~web-.*", want: true},
+		{matcher: "host=~web", want: false},
+		{matcher: "env!~dev|test", want: true},
+		{matcher: "region=", want: true},
+		{matcher: "host=~(", wantErr: true},
+		{matcher: "=a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.matcher, func(t *testing.T) {
+			m, err := ParseLabelMatcher(tt.matcher)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLabelMatcher failed: %v", err)
+			}
+			if got := labels.Matches([]LabelMatcher{m}); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
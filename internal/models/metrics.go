@@ -1,8 +1,12 @@
 package models
 
+import "time"
+
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+	Summary   = "summary"
 )
 
 // NOTE: Не усложняем пример, вводя иерархическую вложенность структур.
@@ -13,8 +17,76 @@ const (
 
 // generate:reset
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string          `json:"id"`                   // имя метрики
+	MType     string          `json:"type"`                 // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64          `json:"delta,omitempty"`      // значение метрики в случае передачи counter
+	Value     *float64        `json:"value,omitempty"`      // значение метрики в случае передачи gauge
+	Histogram *HistogramValue `json:"histogram,omitempty"`  // значение метрики в случае передачи histogram
+	Summary   *SummaryValue   `json:"summary,omitempty"`    // значение метрики в случае передачи summary
+	Labels    Labels          `json:"labels,omitempty"`     // метки метрики, входят в ее идентичность
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"` // время последнего обновления, заполняется сервером
+	Stale     bool            `json:"stale,omitempty"`      // метрика не обновлялась дольше TTL
+}
+
+// HistogramValue - накопленное распределение наблюдений по корзинам
+// Bounds - возрастающие верхние границы корзин, Counts - количество наблюдений
+// в каждой корзине (не накопительно), последний элемент Counts - корзина +Inf,
+// поэтому len(Counts) == len(Bounds)+1
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`   // сумма всех наблюдений
+	Count  uint64    `json:"count"` // общее количество наблюдений
+}
+
+// Quantile - значение квантиля Q (0 <= Q <= 1)
+type Quantile struct {
+	Q     float64 `json:"q"`
+	Value float64 `json:"value"`
+}
+
+// SummaryValue - квантили распределения, посчитанные на стороне клиента
+type SummaryValue struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Sum       float64    `json:"sum"`   // сумма всех наблюдений
+	Count     uint64     `json:"count"` // общее количество наблюдений
+}
+
+// Clone возвращает глубокую копию гистограммы
+func (h HistogramValue) Clone() HistogramValue {
+	h.Bounds = append([]float64(nil), h.Bounds...)
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// Clone возвращает глубокую копию summary
+func (s SummaryValue) Clone() SummaryValue {
+	s.Quantiles = append([]Quantile(nil), s.Quantiles...)
+	return s
+}
+
+// Sample - отсчет истории метрики: значение gauge или накопленное значение counter на момент времени
+type Sample struct {
+	TS    time.Time `json:"ts"`
+	Value float64   `json:"value"`
+}
+
+// History - ответ на запрос истории метрики
+type History struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Labels  Labels   `json:"labels,omitempty"`
+	Samples []Sample `json:"samples"`
+}
+
+// MetricsPage - страница ответа на запрос списка метрик
+type MetricsPage struct {
+	Metrics    []Metrics `json:"metrics"`
+	Total      int       `json:"total"`                 // количество метрик, подходящих под фильтры
+	NextCursor string    `json:"next_cursor,omitempty"` // курсор следующей страницы, пусто на последней
+}
+
+// MetricsCount - ответ на запрос количества метрик
+type MetricsCount struct {
+	Count int `json:"count"`
 }
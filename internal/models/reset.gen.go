@@ -14,4 +14,23 @@ func (rs *Metrics) Reset() {
 	if rs.Value != nil {
 		*rs.Value = 0
 	}
+	if rs.Histogram != nil {
+		if resetter, ok := interface{}(rs.Histogram).(interface{ Reset() }); ok {
+			resetter.Reset()
+		}
+	}
+	if rs.Summary != nil {
+		if resetter, ok := interface{}(rs.Summary).(interface{ Reset() }); ok {
+			resetter.Reset()
+		}
+	}
+	if resetter, ok := interface{}(&rs.Labels).(interface{ Reset() }); ok {
+		resetter.Reset()
+	}
+	if rs.UpdatedAt != nil {
+		if resetter, ok := interface{}(rs.UpdatedAt).(interface{ Reset() }); ok {
+			resetter.Reset()
+		}
+	}
+	rs.Stale = false
 }
@@ -0,0 +1,127 @@
+package proto
+
+import (
+	"fmt"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Преобразования между сообщениями gRPC и моделью метрик сервера
+
+// TypeFromModel возвращает тип метрики gRPC; неизвестный тип - METRIC_TYPE_UNSPECIFIED
+func TypeFromModel(mType string) MetricType {
+	switch mType {
+	case models.Gauge:
+		return MetricType_METRIC_TYPE_GAUGE
+	case models.Counter:
+		return MetricType_METRIC_TYPE_COUNTER
+	case models.Histogram:
+		return MetricType_METRIC_TYPE_HISTOGRAM
+	case models.Summary:
+		return MetricType_METRIC_TYPE_SUMMARY
+	default:
+		return MetricType_METRIC_TYPE_UNSPECIFIED
+	}
+}
+
+// ModelType возвращает тип метрики модели; для METRIC_TYPE_UNSPECIFIED - пустую строку,
+// которую хранилище отклоняет как неизвестный тип
+func (t MetricType) ModelType() string {
+	switch t {
+	case MetricType_METRIC_TYPE_GAUGE:
+		return models.Gauge
+	case MetricType_METRIC_TYPE_COUNTER:
+		return models.Counter
+	case MetricType_METRIC_TYPE_HISTOGRAM:
+		return models.Histogram
+	case MetricType_METRIC_TYPE_SUMMARY:
+		return models.Summary
+	default:
+		return ""
+	}
+}
+
+// FromModel преобразует метрику модели в сообщение gRPC
+func FromModel(m models.Metrics) *Metric {
+	metric := &Metric{
+		Id:     m.ID,
+		Type:   TypeFromModel(m.MType),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+		Stale:  m.Stale,
+	}
+	if m.Histogram != nil {
+		metric.Histogram = &Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+	}
+	if m.Summary != nil {
+		metric.Summary = &Summary{Sum: m.Summary.Sum, Count: m.Summary.Count}
+		for _, q := range m.Summary.Quantiles {
+			metric.Summary.Quantiles = append(metric.Summary.Quantiles, &Quantile{Q: q.Q, Value: q.Value})
+		}
+	}
+	if m.UpdatedAt != nil {
+		metric.UpdatedAt = timestamppb.New(*m.UpdatedAt)
+	}
+	return metric
+}
+
+// ToModel преобразует сообщение gRPC в метрику модели
+func ToModel(m *Metric) models.Metrics {
+	metric := models.Metrics{
+		ID:    m.GetId(),
+		MType: m.GetType().ModelType(),
+		Delta: m.Delta,
+		Value: m.Value,
+		Stale: m.GetStale(),
+	}
+	if len(m.GetLabels()) > 0 {
+		metric.Labels = models.Labels(m.GetLabels())
+	}
+	if h := m.GetHistogram(); h != nil {
+		metric.Histogram = &models.HistogramValue{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Sum:    h.GetSum(),
+			Count:  h.GetCount(),
+		}
+	}
+	if s := m.GetSummary(); s != nil {
+		metric.Summary = &models.SummaryValue{Sum: s.GetSum(), Count: s.GetCount()}
+		for _, q := range s.GetQuantiles() {
+			metric.Summary.Quantiles = append(metric.Summary.Quantiles, models.Quantile{Q: q.GetQ(), Value: q.GetValue()})
+		}
+	}
+	if m.GetUpdatedAt() != nil {
+		ts := m.GetUpdatedAt().AsTime()
+		metric.UpdatedAt = &ts
+	}
+	return metric
+}
+
+// ToModels преобразует пачку сообщений gRPC в метрики модели
+func ToModels(metrics []*Metric) []models.Metrics {
+	result := make([]models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		result = append(result, ToModel(m))
+	}
+	return result
+}
+
+// BatchPayload возвращает подписываемое содержимое пачки: детерминированную
+// сериализацию запроса без подписи
+func BatchPayload(req *UpdateBatchRequest) ([]byte, error) {
+	unsigned := &UpdateBatchRequest{Metrics: req.GetMetrics()}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	return data, nil
+}
@@ -4,18 +4,19 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tladugin/yaProject.git/internal/logger"
 	models "github.com/tladugin/yaProject.git/internal/models"
 )
 
 // Consumer отвечает за чтение данных из файла бэкапа
 type Consumer struct {
+	mu     sync.Mutex
 	file   *os.File
 	reader *bufio.Reader
 }
@@ -40,13 +41,16 @@ func (c *Consumer) Close() error {
 
 // ReadEvent читает одну запись метрики из файла
 func (c *Consumer) ReadEvent() (*models.Metrics, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	data, err := c.reader.ReadBytes('\n')
 	if err != nil {
 		return nil, err
 	}
+	if data, err = openLine(data); err != nil {
+		return nil, err
+	}
 
 	event := models.Metrics{}
 	err = json.Unmarshal(data, &event)
@@ -57,10 +61,14 @@ func (c *Consumer) ReadEvent() (*models.Metrics, error) {
 	return &event, nil
 }
 
-// Producer отвечает за запись данных в файл бэкапа
+// Producer отвечает за запись данных в файл бэкапа.
+// Каждой записи присваивается очередной порядковый номер журнала (seq)
 type Producer struct {
+	mu     sync.Mutex
+	name   string
 	file   *os.File
 	writer *bufio.Writer
+	seq    uint64 // номер последней записи
 }
 
 // NewProducer создает новый экземпляр Producer для записи бэкапов
@@ -71,6 +79,7 @@ func NewProducer(filename string) (*Producer, error) {
 	}
 
 	return &Producer{
+		name:   filename,
 		file:   file,
 		writer: bufio.NewWriter(file),
 	}, nil
@@ -78,63 +87,177 @@ func NewProducer(filename string) (*Producer, error) {
 
 // Close закрывает файл продюсера
 func (p *Producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.file.Close()
 }
 
+// reopen закрывает текущий файл и открывает файл продюсера заново; вызывается под p.mu
+func (p *Producer) reopen() error {
+	file, err := os.OpenFile(p.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	p.writer.Flush()
+	p.file.Close()
+	p.file = file
+	p.writer = bufio.NewWriter(file)
+	return nil
+}
+
 // WriteEvent записывает одну запись метрики в файл
 func (p *Producer) WriteEvent(event *models.Metrics) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.write([]models.Metrics{*event})
+}
 
-	data, err := json.Marshal(&event)
+// WriteEvents записывает несколько записей метрик и сбрасывает буфер в файл один раз
+func (p *Producer) WriteEvents(events []models.Metrics) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.write(events)
+}
+
+// Log применяет обновление к хранилищу и записывает его события в журнал под одной блокировкой,
+// чтобы снимок (performBackup) содержал либо обновление вместе с его записями, либо ни то, ни другое
+func (p *Producer) Log(apply func() ([]models.Metrics, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events, err := apply()
 	if err != nil {
 		return err
 	}
+	return p.write(events)
+}
 
-	if _, err := p.writer.Write(data); err != nil {
-		return err
+// syncFile сбрасывает файл на диск; подменяется в тестах
+var syncFile = (*os.File).Sync
+
+// write нумерует записи, дописывает их в файл, сбрасывает буфер и файл на диск; вызывается под p.mu
+func (p *Producer) write(events []models.Metrics) error {
+	for i := range events {
+		data, err := json.Marshal(walRecord{Seq: p.seq + 1, Metrics: events[i]})
+		if err != nil {
+			return err
+		}
+		if data, err = sealLine(data); err != nil {
+			return err
+		}
+		if _, err := p.writer.Write(data); err != nil {
+			return err
+		}
+		if err := p.writer.WriteByte('\n'); err != nil {
+			return err
+		}
+		p.seq++
 	}
 
-	if err := p.writer.WriteByte('\n'); err != nil {
+	if err := p.writer.Flush(); err != nil {
 		return err
 	}
+	// Запись подтверждается только после сброса на диск: при групповом коммите
+	// fsync выполняется один раз на группу, его ошибку получают все участники группы
+	if err := syncFile(p.file); err != nil {
+		return fmt.Errorf("failed to sync backup log: %w", err)
+	}
+	return nil
+}
 
-	return p.writer.Flush()
+// isTombstone проверяет, является ли событие бэкапа записью об удалении метрики (событие без значения)
+func isTombstone(event *models.Metrics) bool {
+	return event.Value == nil && event.Delta == nil && event.Histogram == nil && event.Summary == nil
 }
 
-// RestoreFromBackup восстанавливает данные хранилища из файла бэкапа
+// RestoreFromBackup восстанавливает данные хранилища из последнего пригодного поколения бэкапа
 func RestoreFromBackup(storage *MemStorage, flagFileStoragePath string) error {
-	consumer, err := NewConsumer(flagFileStoragePath)
-	if err != nil {
-		return fmt.Errorf("failed to create consumer: %w", err)
-	}
-	defer consumer.Close()
+	return RestoreFromBackupAt(storage, flagFileStoragePath, time.Time{})
+}
 
-	event, err := consumer.ReadEvent()
+// RestoreFromBackupAt восстанавливает состояние хранилища на момент at (нулевое время - последнее):
+// берется последнее поколение, созданное не позже at, и к снимку применяются записи журнала
+// с большим номером, обновленные не позже at. Поврежденные поколения пропускаются в пользу более старых
+func RestoreFromBackupAt(storage *MemStorage, flagFileStoragePath string, at time.Time) error {
+	generations, err := listGenerations(flagFileStoragePath)
 	if err != nil {
-		return fmt.Errorf("failed to read event: %w", err)
+		return fmt.Errorf("failed to restore backup: %w", err)
 	}
 
-	for event != nil {
-		switch event.MType {
-		case "gauge":
-			storage.AddGauge(event.ID, *event.Value)
-		case "counter":
-			storage.AddCounter(event.ID, *event.Delta)
+	var errs []error
+	for _, g := range generations {
+		if !at.IsZero() && g.createdAt.After(at) {
+			continue
 		}
-
-		event, err = consumer.ReadEvent()
+		events, walSeq, err := readBackupFile(g.path)
 		if err != nil {
-			logger.Sugar.Info("Backup restore completed")
-			break
+			logger.Sugar.Warnw("Backup generation is unusable, trying previous one", "file", g.path, "error", err)
+			errs = append(errs, err)
+			continue
 		}
+		return restoreSnapshot(storage, flagFileStoragePath, g.path, events, walSeq, at)
 	}
 
+	switch {
+	case len(errs) > 0:
+		return fmt.Errorf("failed to restore backup: %w", errors.Join(errs...))
+	case len(generations) > 0:
+		return fmt.Errorf("failed to restore backup at %s: %w", at.Format(time.RFC3339), ErrNoBackupGeneration)
+	default:
+		// Снимков еще нет: состояние целиком в журнале
+		return restoreSnapshot(storage, flagFileStoragePath, "", nil, 0, at)
+	}
+}
+
+// restoreSnapshot применяет записи снимка и затем записи журнала после walSeq,
+// пропуская обновленные позже at
+func restoreSnapshot(storage *MemStorage, path, source string, events []models.Metrics, walSeq uint64, at time.Time) error {
+	records, err := readWAL(path, walSeq)
+	if err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	for i := range records {
+		events = append(events, records[i].Metrics)
+	}
+
+	restored := 0
+	for i := range events {
+		if !at.IsZero() && events[i].UpdatedAt != nil && events[i].UpdatedAt.After(at) {
+			continue
+		}
+		restoreEvent(storage, &events[i])
+		restored++
+	}
+	logger.Sugar.Infow("Backup restore completed", "file", source, "wal_records", len(records), "events", restored)
 	return nil
 }
 
+// restoreEvent применяет к хранилищу одну проверенную запись бэкапа
+func restoreEvent(storage *MemStorage, event *models.Metrics) {
+	// Время обновления берем из бэкапа, чтобы не продлевать жизнь устаревшим метрикам
+	ts := time.Now()
+	if event.UpdatedAt != nil {
+		ts = *event.UpdatedAt
+	}
+
+	switch {
+	case isTombstone(event):
+		storage.Delete(context.Background(), []models.Metrics{*event})
+	case event.MType == models.Gauge:
+		storage.setGauge(event.ID, event.Labels, *event.Value, ts)
+	case event.MType == models.Counter:
+		storage.addCounter(event.ID, event.Labels, *event.Delta, ts)
+	case event.MType == models.Histogram:
+		storage.setHistogram(event.ID, event.Labels, *event.Histogram, ts)
+	case event.MType == models.Summary:
+		storage.setSummary(event.ID, event.Labels, *event.Summary, ts)
+	}
+}
+
 // RunPeriodicBackupWithContext запускает периодическое создание бэкапов с контекстом
-func RunPeriodicBackupWithContext(ctx context.Context, storage *MemStorage, producer *Producer, interval time.Duration, filename string) {
+func RunPeriodicBackupWithContext(ctx context.Context, storage *MemStorage, producer *Producer, interval time.Duration, filename string, retention RetentionPolicy) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -144,7 +267,7 @@ func RunPeriodicBackupWithContext(ctx context.Context, storage *MemStorage, prod
 			logger.Sugar.Info("Periodic backup stopped")
 			return
 		case <-ticker.C:
-			if err := performBackup(storage, producer, filename); err != nil {
+			if err := performBackup(storage, producer, filename, retention); err != nil {
 				logger.Sugar.Errorw("Periodic backup failed", "error", err)
 			} else {
 				logger.Sugar.Debug("Periodic backup complete")
@@ -154,11 +277,11 @@ func RunPeriodicBackupWithContext(ctx context.Context, storage *MemStorage, prod
 }
 
 // RunFinalBackupWithContext выполняет финальный бэкап при завершении работы приложения
-func RunFinalBackupWithContext(ctx context.Context, storage *MemStorage, producer *Producer, filename string) {
+func RunFinalBackupWithContext(ctx context.Context, storage *MemStorage, producer *Producer, filename string, retention RetentionPolicy) {
 	<-ctx.Done()
 	logger.Sugar.Info("Starting final backup...")
 
-	if err := performBackup(storage, producer, filename); err != nil {
+	if err := performBackup(storage, producer, filename, retention); err != nil {
 		logger.Sugar.Errorw("Final backup failed", "error", err)
 	} else {
 		logger.Sugar.Info("Final backup completed")
@@ -166,111 +289,62 @@ func RunFinalBackupWithContext(ctx context.Context, storage *MemStorage, produce
 }
 
 // SaveBackup сохраняет бэкап (упрощенная версия performBackup)
-func SaveBackup(storage *MemStorage, producer *Producer, filename string) error {
-	return performBackup(storage, producer, filename)
+func SaveBackup(storage *MemStorage, producer *Producer, filename string, retention RetentionPolicy) error {
+	return performBackup(storage, producer, filename, retention)
 }
 
-// performBackup выполняет фактическое создание бэкапа
-func performBackup(storage *MemStorage, producer *Producer, flagFileStoragePath string) error {
-	var producerM sync.Mutex
-
-	producerM.Lock()
-	defer producerM.Unlock()
+// performBackup атомарно записывает снимок хранилища в файл бэкапа с номером последней
+// учтенной записи журнала и начинает новый сегмент журнала. Предыдущие поколения,
+// не попадающие под retention, и покрытые снимками сегменты журнала удаляются
+func performBackup(storage *MemStorage, producer *Producer, flagFileStoragePath string, retention RetentionPolicy) error {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
 
-	// Закрытие текущего продюсера
-	if err := producer.Close(); err != nil {
-		logger.Sugar.Errorw("Failed to close producer", "error", err)
+	if err := writeSnapshotFile(flagFileStoragePath, backupEvents(storage), producer.seq); err != nil {
+		return err
 	}
-
-	// Удаление старого бэкапа если существует
-	oldBackup := flagFileStoragePath + "_old"
-	if err := os.Remove(oldBackup); err != nil && !os.IsNotExist(err) {
-		logger.Sugar.Debugw("No old backup file to remove", "error", err)
+	if err := producer.archive(); err != nil {
+		return fmt.Errorf("failed to rotate WAL: %w", err)
 	}
-
-	// Переименование текущего бэкапа в старый
-	if err := os.Rename(flagFileStoragePath, oldBackup); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to rename backup: %w", err)
+	if err := pruneGenerations(flagFileStoragePath, retention, time.Now()); err != nil {
+		return err
 	}
+	return pruneWAL(flagFileStoragePath, producer.name)
+}
 
-	// Создание нового продюсера
-	newProducer, err := NewProducer(flagFileStoragePath)
-	if err != nil {
-		return fmt.Errorf("failed to create new producer: %w", err)
-	}
+// backupEvents возвращает записи снимка хранилища с исходным временем обновления метрик
+func backupEvents(storage *MemStorage) []models.Metrics {
+	gauges := storage.GaugeSlice()
+	counters := storage.CounterSlice()
+	histograms := storage.HistogramSlice()
+	summaries := storage.SummarySlice()
 
-	// Бэкап gauge метрик
-	for _, gauge := range storage.GaugeSlice() {
-		backup := models.Metrics{
-			ID:    gauge.Name,
-			MType: "gauge",
-			Value: &gauge.Value,
-		}
-		if err := newProducer.WriteEvent(&backup); err != nil {
-			return fmt.Errorf("failed to write gauge metric: %w", err)
-		}
+	events := make([]models.Metrics, 0, len(gauges)+len(counters)+len(histograms)+len(summaries))
+	for i := range gauges {
+		events = append(events, models.Metrics{ID: gauges[i].Name, MType: models.Gauge, Labels: gauges[i].Labels, UpdatedAt: &gauges[i].UpdatedAt, Value: &gauges[i].Value})
 	}
-
-	// Бэкап counter метрик
-	for _, counter := range storage.CounterSlice() {
-		backup := models.Metrics{
-			ID:    counter.Name,
-			MType: "counter",
-			Delta: &counter.Value,
-		}
-		if err := newProducer.WriteEvent(&backup); err != nil {
-			return fmt.Errorf("failed to write counter metric: %w", err)
-		}
+	for i := range counters {
+		events = append(events, models.Metrics{ID: counters[i].Name, MType: models.Counter, Labels: counters[i].Labels, UpdatedAt: &counters[i].UpdatedAt, Delta: &counters[i].Value})
 	}
-
-	return nil
+	for i := range histograms {
+		events = append(events, models.Metrics{ID: histograms[i].Name, MType: models.Histogram, Labels: histograms[i].Labels, UpdatedAt: &histograms[i].UpdatedAt, Histogram: &histograms[i].Value})
+	}
+	for i := range summaries {
+		events = append(events, models.Metrics{ID: summaries[i].Name, MType: models.Summary, Labels: summaries[i].Labels, UpdatedAt: &summaries[i].UpdatedAt, Summary: &summaries[i].Value})
+	}
+	return events
 }
 
 // performBackupToPostgres выполняет бэкап метрик в PostgreSQL
-func performBackupToPostgres(storage *MemStorage, dbPool *pgxpool.Pool) error {
-	var mu sync.Mutex
+func performBackupToPostgres(storage *MemStorage, repo *PostgresRepository) error {
 	ctx := context.Background()
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	tx, err := dbPool.Begin(ctx)
+	metrics, err := storage.List(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback(ctx)
-
-	// Очищаем существующие данные
-	if _, err := tx.Exec(ctx, "TRUNCATE TABLE gauges, counters"); err != nil {
-		return fmt.Errorf("failed to truncate tables: %w", err)
-	}
-
-	// Бэкап gauge метрик
-	for _, gauge := range storage.GaugeSlice() {
-		_, err := tx.Exec(ctx,
-			`INSERT INTO gauges (name, value) VALUES ($1, $2)
-			 ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`,
-			gauge.Name, gauge.Value,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to backup gauge %s: %w", gauge.Name, err)
-		}
+		return fmt.Errorf("failed to list metrics: %w", err)
 	}
-
-	// Бэкап counter метрик
-	for _, counter := range storage.CounterSlice() {
-		_, err := tx.Exec(ctx,
-			`INSERT INTO counters (name, value) VALUES ($1, $2)
-			 ON CONFLICT (name) DO UPDATE SET value = counters.value + EXCLUDED.value, updated_at = NOW()`,
-			counter.Name, counter.Value,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to backup counter %s: %w", counter.Name, err)
-		}
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	if err := repo.Backup(ctx, metrics); err != nil {
+		return err
 	}
 
 	logger.Sugar.Info("Successfully backed up metrics to PostgreSQL")
@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Шифрование файлов бэкапа и журнала: каждая строка шифруется AES-256-GCM отдельно
+// и записывается как enc1:<идентификатор ключа>:<base64(nonce|шифротекст)>, поэтому
+// журнал остается построчным, а недописанная строка не проходит проверку подлинности.
+// Контрольная сумма снимка считается по открытому тексту и не меняется при перешифровании
+const (
+	sealedPrefix  = "enc1:"
+	backupKeySize = 32
+)
+
+// Ошибки шифрования бэкапа
+var (
+	ErrBackupKeyRequired = errors.New("backup is encrypted, key is required")
+	ErrBackupKeyUnknown  = errors.New("backup is encrypted with unknown key")
+)
+
+// backupCipher - ключи шифрования бэкапов; nil - файлы пишутся открытым текстом
+var backupCipher *BackupCipher
+
+// SetBackupCipher включает шифрование файлов бэкапа и журнала (nil - без шифрования).
+// Вызывается при запуске до открытия журнала
+func SetBackupCipher(c *BackupCipher) {
+	backupCipher = c
+}
+
+// BackupCipher шифрует строки текущим ключом и расшифровывает текущим и предыдущими
+type BackupCipher struct {
+	currentID string
+	keys      map[string]cipher.AEAD // по идентификатору ключа
+}
+
+// NewBackupCipher создает шифр с текущим ключом key и предыдущими ключами для чтения старых файлов
+func NewBackupCipher(key []byte, oldKeys ...[]byte) (*BackupCipher, error) {
+	c := &BackupCipher{keys: make(map[string]cipher.AEAD)}
+	for i, k := range append([][]byte{key}, oldKeys...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup key: %w", err)
+		}
+		id := backupKeyID(k)
+		if i == 0 {
+			c.currentID = id
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// backupKeyID возвращает идентификатор ключа: начало его хеша SHA-256
+func backupKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// seal шифрует строку текущим ключом
+func (c *BackupCipher) seal(line []byte) ([]byte, error) {
+	prefix := sealedPrefix + c.currentID + ":"
+	aead := c.keys[c.currentID]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(line)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, line, []byte(prefix))
+
+	out := make([]byte, len(prefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(out, prefix)
+	base64.StdEncoding.Encode(out[len(prefix):], sealed)
+	return out, nil
+}
+
+// open расшифровывает строку любым известным ключом
+func (c *BackupCipher) open(line []byte) ([]byte, error) {
+	rest := line[len(sealedPrefix):]
+	sep := bytes.IndexByte(rest, ':')
+	if sep < 0 {
+		return nil, errors.New("malformed encrypted line")
+	}
+	id := string(rest[:sep])
+	aead, ok := c.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrBackupKeyUnknown, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(rest[sep+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted line: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted line")
+	}
+	prefix := line[:len(sealedPrefix)+sep+1]
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], prefix)
+}
+
+// sealedWithCurrent проверяет, зашифрована ли строка текущим ключом
+func (c *BackupCipher) sealedWithCurrent(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(sealedPrefix+c.currentID+":"))
+}
+
+// sealLine шифрует строку файла бэкапа, если шифрование включено
+func sealLine(line []byte) ([]byte, error) {
+	if backupCipher == nil {
+		return line, nil
+	}
+	return backupCipher.seal(line)
+}
+
+// openLine возвращает открытый текст строки файла бэкапа без перевода строки;
+// строки открытым текстом (файлы, записанные до включения шифрования) возвращаются как есть
+func openLine(line []byte) ([]byte, error) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte(sealedPrefix)) {
+		return line, nil
+	}
+	if backupCipher == nil {
+		return nil, ErrBackupKeyRequired
+	}
+	return backupCipher.open(line)
+}
+
+// LoadBackupKey читает ключ шифрования бэкапов из файла: 32 байта в hex,
+// либо при wrapKey - ключ, зашифрованный RSA-OAEP открытым ключом сервера, в base64
+func LoadBackupKey(path string, wrapKey *rsa.PrivateKey) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup key: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+
+	var key []byte
+	if wrapKey != nil {
+		wrapped, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wrapped backup key %s: %w", path, err)
+		}
+		if key, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, wrapKey, wrapped, nil); err != nil {
+			return nil, fmt.Errorf("failed to unwrap backup key %s: %w", path, err)
+		}
+	} else if key, err = hex.DecodeString(text); err != nil {
+		return nil, fmt.Errorf("invalid backup key %s: %w", path, err)
+	}
+
+	if len(key) != backupKeySize {
+		return nil, fmt.Errorf("invalid backup key %s: expected %d bytes, got %d", path, backupKeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadOrCreateBackupKey читает ключ шифрования бэкапов, а если файла нет - создает новый ключ
+// и сохраняет его в формате LoadBackupKey
+func LoadOrCreateBackupKey(path string, wrapKey *rsa.PrivateKey) ([]byte, error) {
+	key, err := LoadBackupKey(path, wrapKey)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+
+	key = make([]byte, backupKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate backup key: %w", err)
+	}
+	text := hex.EncodeToString(key)
+	if wrapKey != nil {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &wrapKey.PublicKey, key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap backup key: %w", err)
+		}
+		text = base64.StdEncoding.EncodeToString(wrapped)
+	}
+	if err := os.WriteFile(path, []byte(text+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save backup key: %w", err)
+	}
+	return key, nil
+}
+
+// ReencryptBackups перешифровывает текущим ключом поколения бэкапа path и сегменты его журнала,
+// записанные предыдущими ключами или открытым текстом. Вызывается при запуске до открытия журнала.
+// Возвращает количество перезаписанных файлов
+func ReencryptBackups(path string) (int, error) {
+	if backupCipher == nil {
+		return 0, nil
+	}
+
+	generations, err := listGenerations(path)
+	if err != nil {
+		return 0, err
+	}
+	segments, err := listWALSegments(walPath(path))
+	if err != nil {
+		return 0, err
+	}
+	files := []string{walPath(path)}
+	for _, g := range generations {
+		files = append(files, g.path)
+	}
+	for _, s := range segments {
+		files = append(files, s.path)
+	}
+
+	rewritten := 0
+	for _, file := range files {
+		changed, err := reencryptFile(file)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to re-encrypt %s: %w", file, err)
+		}
+		if changed {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// reencryptFile атомарно перезаписывает файл, если в нем есть строки не текущего ключа.
+// Нерасшифровываемые строки (например, недописанные) переносятся без изменений
+func reencryptFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var out bytes.Buffer
+	changed := false
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if backupCipher.sealedWithCurrent(line) {
+				out.Write(line)
+			} else if plain, openErr := openLine(line); openErr != nil {
+				out.Write(line)
+			} else {
+				sealed, err := backupCipher.seal(plain)
+				if err != nil {
+					return false, err
+				}
+				out.Write(sealed)
+				out.WriteByte('\n')
+				changed = true
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	return true, syncDir(dir)
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// useBackupKeys включает шифрование бэкапов на время теста
+func useBackupKeys(t *testing.T, key []byte, oldKeys ...[]byte) {
+	t.Helper()
+	c, err := NewBackupCipher(key, oldKeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBackupCipher(c)
+	t.Cleanup(func() { SetBackupCipher(nil) })
+}
+
+// backupFiles возвращает содержимое всех файлов каталога бэкапа
+func backupFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = data
+	}
+	return files
+}
+
+// writeEncryptedBackup записывает снимок с counter и событие журнала после него
+func writeEncryptedBackup(t *testing.T, path string) {
+	t.Helper()
+	ctx := context.Background()
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	store := NewFileStorage(NewMemStorage(), producer)
+	store.Add(ctx, "secret_hits", nil, 5)
+	if err := performBackup(store.MemStorage, producer, path, RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatal(err)
+	}
+	store.Add(ctx, "secret_hits", nil, 2)
+}
+
+func TestBackupEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, backupKeySize)
+	useBackupKeys(t, key)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup")
+	writeEncryptedBackup(t, path)
+
+	for name, data := range backupFiles(t, dir) {
+		if bytes.Contains(data, []byte("secret_hits")) {
+			t.Errorf("%s contains plaintext metric name", name)
+		}
+	}
+	if got := restoredCounter(t, path, "secret_hits"); got != 7 {
+		t.Errorf("restored = %d, want 7", got)
+	}
+
+	// Без ключа восстановить нельзя
+	SetBackupCipher(nil)
+	if err := RestoreFromBackup(NewMemStorage(), path); !errors.Is(err, ErrBackupKeyRequired) {
+		t.Errorf("error = %v, want %v", err, ErrBackupKeyRequired)
+	}
+
+	// С другим ключом тоже
+	useBackupKeys(t, bytes.Repeat([]byte{2}, backupKeySize))
+	if err := RestoreFromBackup(NewMemStorage(), path); !errors.Is(err, ErrBackupKeyUnknown) {
+		t.Errorf("error = %v, want %v", err, ErrBackupKeyUnknown)
+	}
+}
+
+func TestReencryptBackups(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, backupKeySize)
+	newKey := bytes.Repeat([]byte{2}, backupKeySize)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup")
+
+	// Первое поколение открытым текстом, второе - старым ключом
+	writeEncryptedBackup(t, path)
+	useBackupKeys(t, oldKey)
+	writeEncryptedBackup(t, path)
+
+	useBackupKeys(t, newKey, oldKey)
+	rewritten, err := ReencryptBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten == 0 {
+		t.Fatal("no files re-encrypted")
+	}
+	if again, err := ReencryptBackups(path); err != nil || again != 0 {
+		t.Errorf("second pass rewrote %d files (err %v), want 0", again, err)
+	}
+
+	// Все строки зашифрованы новым ключом, и старый ключ больше не нужен
+	prefix := sealedPrefix + backupKeyID(newKey) + ":"
+	for name, data := range backupFiles(t, dir) {
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line != "" && !strings.HasPrefix(line, prefix) {
+				t.Errorf("%s has a line not sealed with the new key: %.40s", name, line)
+			}
+		}
+	}
+	useBackupKeys(t, newKey)
+	if got := restoredCounter(t, path, "secret_hits"); got != 7 {
+		t.Errorf("restored = %d, want 7", got)
+	}
+}
+
+func TestLoadOrCreateBackupKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		wrapKey *rsa.PrivateKey
+	}{
+		{name: "raw key"},
+		{name: "wrapped key", wrapKey: rsaKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "backup.key")
+			created, err := LoadOrCreateBackupKey(path, tt.wrapKey)
+			if err != nil || len(created) != backupKeySize {
+				t.Fatalf("LoadOrCreateBackupKey = %d bytes, %v", len(created), err)
+			}
+			loaded, err := LoadBackupKey(path, tt.wrapKey)
+			if err != nil || !bytes.Equal(created, loaded) {
+				t.Errorf("LoadBackupKey returned a different key (err %v)", err)
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("key file mode = %v (err %v), want 0600", info.Mode().Perm(), err)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "short.key")
+	os.WriteFile(path, []byte("abcd\n"), 0600)
+	if _, err := LoadBackupKey(path, nil); err == nil {
+		t.Error("expected error for short key")
+	}
+}
+
+func TestBackupEncryption_TamperedWALLine(t *testing.T) {
+	useBackupKeys(t, bytes.Repeat([]byte{1}, backupKeySize))
+	path := filepath.Join(t.TempDir(), "backup")
+	writeEncryptedBackup(t, path)
+
+	data, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 1
+	os.WriteFile(walPath(path), data, 0666)
+
+	storage := NewMemStorage()
+	if err := RestoreFromBackup(storage, path); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := storage.Get(context.Background(), models.Counter, "secret_hits", nil); err != nil || *m.Delta != 5 {
+		t.Errorf("restored %v (err %v), want 5 without the tampered record", m.Delta, err)
+	}
+}
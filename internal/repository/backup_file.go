@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Формат файла бэкапа:
+//
+//	{"header":{"format":"yaproject-metrics-backup","version":2,"created_at":"...","wal_seq":S}}
+//	{"id":"...","type":"gauge","value":1}     - записи снимка, по одной метрике в строке
+//	{"trailer":{"records":N,"sha256":"..."}}  - контрольная сумма заголовка и записей
+//
+// wal_seq - номер последней записи журнала (см. wal.go), учтенной в снимке.
+// Файлы версии 1 могли содержать события синхронного режима после завершающей записи,
+// файлы без заголовка читаются как бэкапы старого формата (только записи)
+const (
+	backupFormat  = "yaproject-metrics-backup"
+	backupVersion = 2
+)
+
+// Ошибки чтения бэкапа
+var (
+	ErrBackupCorrupt     = errors.New("backup file is corrupt")
+	ErrBackupUnsupported = errors.New("unsupported backup format")
+)
+
+// backupHeader - заголовок файла бэкапа
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	WALSeq    uint64    `json:"wal_seq,omitempty"`
+}
+
+// backupTrailer - завершающая запись снимка с количеством записей и их контрольной суммой
+type backupTrailer struct {
+	Records  int    `json:"records"`
+	Checksum string `json:"sha256"`
+}
+
+// backupFrame - служебная строка файла бэкапа: заголовок или завершающая запись
+type backupFrame struct {
+	Header  *backupHeader  `json:"header,omitempty"`
+	Trailer *backupTrailer `json:"trailer,omitempty"`
+}
+
+// writeSnapshotFile атомарно заменяет файл бэкапа снимком events:
+// снимок пишется во временный файл в том же каталоге, сбрасывается на диск,
+// текущий файл становится предыдущим поколением, а временный переименовывается в path
+func writeSnapshotFile(path string, events []models.Metrics, walSeq uint64) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp backup file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // после успешного переименования файла уже нет
+
+	header := backupHeader{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC(), WALSeq: walSeq}
+	if err := writeSnapshot(tmp, header, events); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync backup file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close backup file: %w", err)
+	}
+
+	if err := rotateBackup(path); err != nil {
+		return fmt.Errorf("failed to rotate backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename backup: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// writeSnapshot записывает заголовок, записи и завершающую запись с контрольной суммой.
+// Контрольная сумма считается по открытому тексту строк
+func writeSnapshot(w io.Writer, header backupHeader, events []models.Metrics) error {
+	sum := sha256.New()
+	bw := bufio.NewWriter(w)
+
+	if err := writeLine(bw, sum, backupFrame{Header: &header}); err != nil {
+		return fmt.Errorf("failed to write backup header: %w", err)
+	}
+	for i := range events {
+		if err := writeLine(bw, sum, &events[i]); err != nil {
+			return fmt.Errorf("failed to write backup record: %w", err)
+		}
+	}
+
+	trailer := backupFrame{Trailer: &backupTrailer{Records: len(events), Checksum: hex.EncodeToString(sum.Sum(nil))}}
+	if err := writeLine(bw, nil, trailer); err != nil {
+		return fmt.Errorf("failed to write backup trailer: %w", err)
+	}
+	return bw.Flush()
+}
+
+// writeLine записывает значение одной строкой JSON (зашифрованной, если шифрование включено)
+// и добавляет открытый текст строки в sum
+func writeLine(w io.Writer, sum hash.Hash, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if sum != nil {
+		sum.Write(data)
+		sum.Write([]byte{'\n'})
+	}
+	line, err := sealLine(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование файла пережило сбой
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open backup directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup directory: %w", err)
+	}
+	return nil
+}
+
+// readBackupFile читает и проверяет файл бэкапа
+func readBackupFile(path string) ([]models.Metrics, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	events, walSeq, err := readBackup(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return events, walSeq, nil
+}
+
+// readBackup читает записи бэкапа. Снимок с заголовком должен быть цел: поврежденная строка,
+// несовпадение контрольной суммы или отсутствие завершающей записи делают файл непригодным.
+// Поврежденные строки бэкапа старого формата и событий после снимка (например,
+// недописанная при сбое последняя строка) пропускаются с предупреждением.
+// Возвращает записи и номер последней учтенной в снимке записи журнала
+func readBackup(r io.Reader) ([]models.Metrics, uint64, error) {
+	reader := bufio.NewReader(r)
+	sum := sha256.New()
+
+	var (
+		events   []models.Metrics
+		framed   bool // файл начинается с заголовка
+		snapshot bool // читаются записи снимка (до завершающей записи)
+		records  int
+		walSeq   uint64
+	)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, fmt.Errorf("failed to read backup: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		line, openErr := openLine(line)
+		if openErr != nil {
+			if snapshot || lineNo == 1 {
+				return nil, 0, fmt.Errorf("%w: line %d: %w", ErrBackupCorrupt, lineNo, openErr)
+			}
+			logger.Sugar.Warnw("Skipping corrupt backup line", "line", lineNo, "error", openErr)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		line = append(line, '\n')
+
+		var frame backupFrame
+		isFrame := json.Unmarshal(line, &frame) == nil && (frame.Header != nil || frame.Trailer != nil)
+		switch {
+		case isFrame && frame.Header != nil:
+			if lineNo != 1 {
+				return nil, 0, fmt.Errorf("%w: unexpected header at line %d", ErrBackupCorrupt, lineNo)
+			}
+			if frame.Header.Format != backupFormat || frame.Header.Version > backupVersion {
+				return nil, 0, fmt.Errorf("%w: %s version %d", ErrBackupUnsupported, frame.Header.Format, frame.Header.Version)
+			}
+			framed, snapshot = true, true
+			walSeq = frame.Header.WALSeq
+			sum.Write(line)
+		case isFrame && frame.Trailer != nil:
+			if !snapshot {
+				return nil, 0, fmt.Errorf("%w: unexpected trailer at line %d", ErrBackupCorrupt, lineNo)
+			}
+			if frame.Trailer.Records != records {
+				return nil, 0, fmt.Errorf("%w: trailer expects %d records, found %d", ErrBackupCorrupt, frame.Trailer.Records, records)
+			}
+			if got := hex.EncodeToString(sum.Sum(nil)); got != frame.Trailer.Checksum {
+				return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrBackupCorrupt)
+			}
+			snapshot = false
+		default:
+			event, parseErr := parseBackupRecord(line)
+			if snapshot {
+				if parseErr != nil {
+					return nil, 0, fmt.Errorf("%w: line %d: %v", ErrBackupCorrupt, lineNo, parseErr)
+				}
+				sum.Write(line)
+				records++
+			} else if parseErr != nil {
+				logger.Sugar.Warnw("Skipping corrupt backup line", "line", lineNo, "error", parseErr)
+				break
+			}
+			events = append(events, event)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if framed && snapshot {
+		return nil, 0, fmt.Errorf("%w: snapshot is truncated, trailer is missing", ErrBackupCorrupt)
+	}
+	return events, walSeq, nil
+}
+
+// parseBackupRecord разбирает запись метрики: запись должна быть событием удаления
+// либо метрикой известного типа с заполненным значением
+func parseBackupRecord(line []byte) (models.Metrics, error) {
+	var event models.Metrics
+	if err := json.Unmarshal(line, &event); err != nil {
+		return models.Metrics{}, err
+	}
+	if isTombstone(&event) {
+		return event, validateDelete([]models.Metrics{event})
+	}
+	return event, validateMetric(event)
+}
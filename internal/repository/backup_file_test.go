@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// backupWith записывает снимок хранилища с одним gauge в файл бэкапа
+func backupWith(t *testing.T, path string, producer *Producer, value float64) {
+	t.Helper()
+	storage := NewMemStorage()
+	storage.Set(context.Background(), "cpu", nil, value)
+	if err := performBackup(storage, producer, path, RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("performBackup failed: %v", err)
+	}
+}
+
+func TestPerformBackup_Format(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	backupWith(t, path, producer, 1)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `{"header":`) || !strings.HasPrefix(lines[2], `{"trailer":`) {
+		t.Fatalf("unexpected backup layout:\n%s", data)
+	}
+
+	// События синхронного режима дописываются в журнал, снимок не меняется
+	delta := int64(3)
+	if err := producer.WriteEvent(&models.Metrics{ID: "hits", MType: models.Counter, Delta: &delta}); err != nil {
+		t.Fatal(err)
+	}
+	events, walSeq, err := readBackupFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := readWAL(path, walSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || len(records) != 1 || records[0].ID != "hits" || records[0].Seq != walSeq+1 {
+		t.Errorf("events = %+v, records = %+v, want snapshot gauge and logged counter", events, records)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestReadBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	backupWith(t, path, producer, 1)
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(snapshot), "\n")
+
+	tests := []struct {
+		name    string
+		data    string
+		events  int
+		wantErr error
+	}{
+		{name: "valid snapshot", data: string(snapshot), events: 1},
+		{name: "tampered record", data: lines[0] + strings.Replace(lines[1], `"value":1`, `"value":2`, 1) + lines[2], wantErr: ErrBackupCorrupt},
+		{name: "corrupt record", data: lines[0] + "{garbage\n" + lines[2], wantErr: ErrBackupCorrupt},
+		{name: "missing trailer", data: lines[0] + lines[1], wantErr: ErrBackupCorrupt},
+		{name: "future version", data: `{"header":{"format":"yaproject-metrics-backup","version":99}}` + "\n", wantErr: ErrBackupUnsupported},
+		{name: "torn appended event is skipped", data: string(snapshot) + `{"id":"hits","type":"coun`, events: 1},
+		{name: "legacy file", data: `{"id":"a","type":"gauge","value":1}` + "\n" + `{"id":"b","type":"counter","delta":2}` + "\n", events: 2},
+		{name: "legacy gauge without value is skipped", data: `{"id":"a","type":"gauge","delta":1}` + "\n" + `{"id":"b","type":"counter","delta":2}` + "\n", events: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, _, err := readBackup(strings.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(events) != tt.events {
+				t.Errorf("got %d events, want %d", len(events), tt.events)
+			}
+		})
+	}
+}
+
+func TestRestoreFromBackup_FallsBackToPreviousGeneration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	backupWith(t, path, producer, 1)
+	backupWith(t, path, producer, 2)
+
+	// Обрезаем текущий снимок, как при повреждении файла на диске
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewMemStorage()
+	if err := RestoreFromBackup(storage, path); err != nil {
+		t.Fatalf("RestoreFromBackup failed: %v", err)
+	}
+	if m, err := storage.Get(context.Background(), models.Gauge, "cpu", nil); err != nil || *m.Value != 1 {
+		t.Errorf("restored %v (err %v), want value 1 from previous generation", m.Value, err)
+	}
+
+	// Без пригодного поколения восстановление завершается ошибкой
+	generations, err := listGenerations(path)
+	if err != nil || len(generations) != 2 {
+		t.Fatalf("generations = %v (err %v), want current and previous", generations, err)
+	}
+	if err := os.WriteFile(generations[1].path, data[:len(data)/2], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreFromBackup(NewMemStorage(), path); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("error = %v, want %v", err, ErrBackupCorrupt)
+	}
+
+	// Отсутствие бэкапа не считается ошибкой
+	if err := RestoreFromBackup(NewMemStorage(), path+"_missing"); err != nil {
+		t.Errorf("missing backup should not be an error: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+)
+
+// generationLayout - формат времени снимка в имени поколения бэкапа: <файл>.<время>
+const generationLayout = "20060102T150405.000Z"
+
+// ErrNoBackupGeneration - нет поколения бэкапа, созданного не позже запрошенного времени
+var ErrNoBackupGeneration = errors.New("no backup generation at or before requested time")
+
+// RetentionPolicy определяет, какие предыдущие поколения бэкапа сохраняются при ротации.
+// Поколение сохраняется, если подходит хотя бы под одно правило; текущий файл не удаляется никогда
+type RetentionPolicy struct {
+	KeepLast int           // количество последних поколений
+	Hourly   time.Duration // за этот период хранится последнее поколение каждого часа
+	Daily    time.Duration // за этот период хранится последнее поколение каждых суток (UTC)
+}
+
+// backupGeneration - файл бэкапа, время создания его снимка и номер последней учтенной записи журнала
+type backupGeneration struct {
+	path      string
+	createdAt time.Time
+	walSeq    uint64
+}
+
+// generationPath возвращает имя файла поколения, созданного в момент createdAt
+func generationPath(path string, createdAt time.Time) string {
+	return path + "." + createdAt.UTC().Format(generationLayout)
+}
+
+// ParseRestorePoint разбирает время точки восстановления: метку поколения из имени файла
+// (20060102T150405.000Z) либо время в формате RFC 3339
+func ParseRestorePoint(s string) (time.Time, error) {
+	if t, err := time.Parse(generationLayout, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid restore point %q: expected %s or RFC 3339", s, generationLayout)
+	}
+	return t, nil
+}
+
+// snapshotHeader возвращает заголовок снимка из файла бэкапа,
+// для файлов старого формата - заголовок со временем последнего изменения файла
+func snapshotHeader(path string) (backupHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return backupHeader{}, err
+	}
+	defer f.Close()
+
+	line, _ := bufio.NewReader(f).ReadBytes('\n')
+	line, err = openLine(line)
+	var frame backupFrame
+	if err == nil && json.Unmarshal(line, &frame) == nil && frame.Header != nil && !frame.Header.CreatedAt.IsZero() {
+		return *frame.Header, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return backupHeader{}, err
+	}
+	return backupHeader{CreatedAt: info.ModTime()}, nil
+}
+
+// listGenerations возвращает текущий файл бэкапа и его предыдущие поколения, от новых к старым
+func listGenerations(path string) ([]backupGeneration, error) {
+	var generations []backupGeneration
+
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup generations: %w", err)
+	}
+	for _, m := range matches {
+		createdAt, err := time.Parse(generationLayout, strings.TrimPrefix(m, path+"."))
+		if err != nil {
+			continue // временные файлы, журнал и посторонние файлы
+		}
+		header, err := snapshotHeader(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup header: %w", err)
+		}
+		generations = append(generations, backupGeneration{path: m, createdAt: createdAt, walSeq: header.WALSeq})
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].createdAt.After(generations[j].createdAt)
+	})
+
+	header, err := snapshotHeader(path)
+	switch {
+	case err == nil:
+		generations = append([]backupGeneration{{path: path, createdAt: header.CreatedAt, walSeq: header.WALSeq}}, generations...)
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	return generations, nil
+}
+
+// rotateBackup переименовывает текущий файл бэкапа в поколение с временем его снимка
+func rotateBackup(path string) error {
+	header, err := snapshotHeader(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read backup header: %w", err)
+	}
+	return os.Rename(path, generationPath(path, header.CreatedAt))
+}
+
+// expired возвращает предыдущие поколения (от новых к старым), не подходящие ни под одно правило
+func (p RetentionPolicy) expired(generations []backupGeneration, now time.Time) []backupGeneration {
+	hours := make(map[time.Time]bool)
+	days := make(map[time.Time]bool)
+
+	var result []backupGeneration
+	for i, g := range generations {
+		keep := i < p.KeepLast
+
+		age := now.Sub(g.createdAt)
+		if hour := g.createdAt.UTC().Truncate(time.Hour); age <= p.Hourly && !hours[hour] {
+			hours[hour] = true
+			keep = true
+		}
+		if day := g.createdAt.UTC().Truncate(24 * time.Hour); age <= p.Daily && !days[day] {
+			days[day] = true
+			keep = true
+		}
+
+		if !keep {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// pruneGenerations удаляет предыдущие поколения бэкапа, не попадающие под политику хранения
+func pruneGenerations(path string, policy RetentionPolicy, now time.Time) error {
+	generations, err := listGenerations(path)
+	if err != nil {
+		return err
+	}
+	if len(generations) > 0 && generations[0].path == path {
+		generations = generations[1:]
+	}
+
+	for _, g := range policy.expired(generations, now) {
+		if err := os.Remove(g.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove backup generation: %w", err)
+		}
+		logger.Sugar.Debugw("Backup generation removed", "file", g.path)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+func TestRetentionPolicy_Expired(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) backupGeneration {
+		return backupGeneration{path: now.Add(-d).Format(generationLayout), createdAt: now.Add(-d)}
+	}
+	// Поколения от новых к старым: два в текущем часе, по одному в предыдущие часы и дни
+	generations := []backupGeneration{
+		at(10 * time.Minute), at(40 * time.Minute), at(90 * time.Minute),
+		at(30 * time.Hour), at(31 * time.Hour), at(5 * 24 * time.Hour), at(10 * 24 * time.Hour),
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []time.Duration // возраст удаляемых поколений
+	}{
+		{name: "keep nothing", policy: RetentionPolicy{}, want: []time.Duration{10 * time.Minute, 40 * time.Minute, 90 * time.Minute, 30 * time.Hour, 31 * time.Hour, 5 * 24 * time.Hour, 10 * 24 * time.Hour}},
+		{name: "keep last", policy: RetentionPolicy{KeepLast: 5}, want: []time.Duration{5 * 24 * time.Hour, 10 * 24 * time.Hour}},
+		{name: "hourly for a day", policy: RetentionPolicy{Hourly: 24 * time.Hour}, want: []time.Duration{40 * time.Minute, 30 * time.Hour, 31 * time.Hour, 5 * 24 * time.Hour, 10 * 24 * time.Hour}},
+		{name: "hourly for a day, daily for a week", policy: RetentionPolicy{Hourly: 24 * time.Hour, Daily: 7 * 24 * time.Hour}, want: []time.Duration{40 * time.Minute, 31 * time.Hour, 10 * 24 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := tt.policy.expired(generations, now)
+			if len(expired) != len(tt.want) {
+				t.Fatalf("expired %v, want ages %v", expired, tt.want)
+			}
+			for i, g := range expired {
+				if age := now.Sub(g.createdAt); age != tt.want[i] {
+					t.Errorf("expired[%d] age = %v, want %v", i, age, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRestorePoint(t *testing.T) {
+	want := time.Date(2026, 10, 17, 15, 30, 0, 0, time.UTC)
+	for _, s := range []string{"20261017T153000.000Z", "2026-10-17T15:30:00Z", "2026-10-17T18:30:00+03:00"} {
+		if got, err := ParseRestorePoint(s); err != nil || !got.Equal(want) {
+			t.Errorf("ParseRestorePoint(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseRestorePoint("yesterday"); err == nil {
+		t.Error("expected error for invalid restore point")
+	}
+}
+
+func TestRestoreFromBackupAt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	retention := RetentionPolicy{KeepLast: 10}
+
+	storage := NewMemStorage()
+	storage.Set(ctx, "cpu", nil, 1)
+	if err := performBackup(storage, producer, path, retention); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	first := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	// Событие синхронного режима после первого снимка
+	files := NewFileStorage(storage, producer)
+	files.Set(ctx, "cpu", nil, 2)
+	time.Sleep(5 * time.Millisecond)
+	afterEvent := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	files.Set(ctx, "cpu", nil, 3)
+	if err := performBackup(storage, producer, path, retention); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		{name: "latest", want: 3},
+		{name: "first snapshot", at: first, want: 1},
+		{name: "first snapshot with journal", at: afterEvent, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := NewMemStorage()
+			if err := RestoreFromBackupAt(restored, path, tt.at); err != nil {
+				t.Fatal(err)
+			}
+			if m, err := restored.Get(ctx, models.Gauge, "cpu", nil); err != nil || *m.Value != tt.want {
+				t.Errorf("restored %v (err %v), want %v", m.Value, err, tt.want)
+			}
+		})
+	}
+
+	if err := RestoreFromBackupAt(NewMemStorage(), path, first.Add(-time.Hour)); !errors.Is(err, ErrNoBackupGeneration) {
+		t.Errorf("error = %v, want %v", err, ErrNoBackupGeneration)
+	}
+}
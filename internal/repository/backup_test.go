@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/tladugin/yaProject.git/internal/logger"
 	"github.com/tladugin/yaProject.git/internal/models"
@@ -317,6 +318,70 @@ func TestRestoreFromBackup(t *testing.T) {
 	}
 }
 
+// TestRestoreFromBackup_Distributions тестирует восстановление histogram и summary метрик
+func TestRestoreFromBackup_Distributions(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_restore_distributions")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	backupData := `{"id":"lat","type":"histogram","histogram":{"bounds":[1],"counts":[1,2],"sum":4,"count":3}}` + "\n" +
+		`{"id":"lat","type":"summary","summary":{"quantiles":[{"q":0.5,"value":1}],"sum":4,"count":3}}` + "\n"
+	if _, err := tmpfile.WriteString(backupData); err != nil {
+		t.Fatalf("Failed to write backup data: %v", err)
+	}
+	tmpfile.Close()
+
+	storage := NewMemStorage()
+	RestoreFromBackup(storage, tmpfile.Name())
+
+	histograms := storage.HistogramSlice()
+	if len(histograms) != 1 || histograms[0].Value.Count != 3 {
+		t.Errorf("Histogram metric not restored correctly: %+v", histograms)
+	}
+	summaries := storage.SummarySlice()
+	if len(summaries) != 1 || len(summaries[0].Value.Quantiles) != 1 {
+		t.Errorf("Summary metric not restored correctly: %+v", summaries)
+	}
+}
+
+// TestPerformBackup_Labels тестирует сохранение меток метрик в бэкапе
+func TestPerformBackup_Labels(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_backup_labels")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	defer os.Remove(tmpfile.Name() + ".wal")
+
+	producer, err := OpenWAL(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("OpenWAL failed: %v", err)
+	}
+
+	ctx := context.Background()
+	storage := NewMemStorage()
+	storage.Set(ctx, "cpu", models.Labels{"host": "a"}, 1)
+	storage.Set(ctx, "cpu", models.Labels{"host": "b"}, 2)
+	storage.Add(ctx, "hits", models.Labels{"env": "prod"}, 5)
+
+	if err := performBackup(storage, producer, tmpfile.Name(), RetentionPolicy{}); err != nil {
+		t.Fatalf("performBackup failed: %v", err)
+	}
+
+	restored := NewMemStorage()
+	RestoreFromBackup(restored, tmpfile.Name())
+
+	if m, err := restored.Get(ctx, models.Gauge, "cpu", models.Labels{"host": "b"}); err != nil || *m.Value != 2 {
+		t.Errorf("labelled gauge not restored: %v, %v", m, err)
+	}
+	if m, err := restored.Get(ctx, models.Counter, "hits", models.Labels{"env": "prod"}); err != nil || *m.Delta != 5 {
+		t.Errorf("labelled counter not restored: %v, %v", m, err)
+	}
+}
+
 // TestRestoreFromBackup_EmptyFile тестирует восстановление из пустого файла
 func TestRestoreFromBackup_EmptyFile(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "test_restore_empty")
@@ -344,14 +409,14 @@ func TestPerformBackup(t *testing.T) {
 	tmpfile.Close()
 	defer os.Remove(tmpfile.Name())
 	defer func() {
-		// Очищаем старый бэкап если он создался
-		os.Remove(tmpfile.Name() + "_old")
+		// Очищаем журнал
+		os.Remove(tmpfile.Name() + ".wal")
 	}()
 
 	storage := NewMemStorage()
-	producer, err := NewProducer(tmpfile.Name())
+	producer, err := OpenWAL(tmpfile.Name())
 	if err != nil {
-		t.Fatalf("NewProducer failed: %v", err)
+		t.Fatalf("OpenWAL failed: %v", err)
 	}
 
 	// Добавляем тестовые данные
@@ -359,7 +424,7 @@ func TestPerformBackup(t *testing.T) {
 	storage.AddCounter("backup_counter", 777)
 
 	// Выполняем бэкап
-	err = performBackup(storage, producer, tmpfile.Name())
+	err = performBackup(storage, producer, tmpfile.Name(), RetentionPolicy{})
 	if err != nil {
 		t.Fatalf("performBackup failed: %v", err)
 	}
@@ -384,13 +449,13 @@ func TestMemStorage_Integration(t *testing.T) {
 	tmpfile.Close()
 	defer os.Remove(tmpfile.Name())
 	defer func() {
-		os.Remove(tmpfile.Name() + "_old")
+		os.Remove(tmpfile.Name() + ".wal")
 	}()
 
 	storage := NewMemStorage()
-	producer, err := NewProducer(tmpfile.Name())
+	producer, err := OpenWAL(tmpfile.Name())
 	if err != nil {
-		t.Fatalf("NewProducer failed: %v", err)
+		t.Fatalf("OpenWAL failed: %v", err)
 	}
 
 	// Добавляем метрики
@@ -398,7 +463,7 @@ func TestMemStorage_Integration(t *testing.T) {
 	storage.AddCounter("integration_counter", 555)
 
 	// Выполняем бэкап
-	err = performBackup(storage, producer, tmpfile.Name())
+	err = performBackup(storage, producer, tmpfile.Name(), RetentionPolicy{})
 	if err != nil {
 		t.Fatalf("performBackup failed: %v", err)
 	}
@@ -441,17 +506,17 @@ func TestBackupWithEmptyStorage(t *testing.T) {
 	tmpfile.Close()
 	defer os.Remove(tmpfile.Name())
 	defer func() {
-		os.Remove(tmpfile.Name() + "_old")
+		os.Remove(tmpfile.Name() + ".wal")
 	}()
 
 	storage := NewMemStorage()
-	producer, err := NewProducer(tmpfile.Name())
+	producer, err := OpenWAL(tmpfile.Name())
 	if err != nil {
-		t.Fatalf("NewProducer failed: %v", err)
+		t.Fatalf("OpenWAL failed: %v", err)
 	}
 
 	// Выполняем бэкап пустого хранилища
-	err = performBackup(storage, producer, tmpfile.Name())
+	err = performBackup(storage, producer, tmpfile.Name(), RetentionPolicy{})
 	if err != nil {
 		t.Fatalf("performBackup should not fail with empty storage: %v", err)
 	}
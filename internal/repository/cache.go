@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// maxCachePending - предельный размер очереди записи в базу, включая записываемую пачку
+const maxCachePending = 100000
+
+// ErrCacheFull - очередь записи в базу заполнена, обновление отклонено
+var ErrCacheFull = errors.New("write-behind queue is full")
+
+// BackingStore - хранилище за кэшем: источник истины для состояния и истории
+// Реализация: PostgresRepository
+type BackingStore interface {
+	MetricStore
+	HistoryStore
+	CumulativeStore
+}
+
+// CachedStore - MemStorage как кэш перед PostgreSQL
+// Чтение выполняется из памяти, обновления применяются в памяти сразу и накапливаются
+// в очереди, которая записывается в базу одним UpdateBatch раз в flushInterval
+// или при накоплении flushSize обновлений (write-behind)
+// Накопленные значения counter (UpdateCumulative) и удаление записываются в базу сразу
+// История значений читается из базы
+type CachedStore struct {
+	*MemStorage
+	backend       BackingStore
+	flushInterval time.Duration
+	flushSize     int
+
+	gate       sync.RWMutex // обновления берут на чтение, удаление - на запись
+	mu         sync.Mutex   // защищает pending и inflight
+	pending    []models.Metrics
+	inflight   int // размер пачки, которую сейчас записывает Flush
+	maxPending int
+	flushMu    sync.Mutex // одна запись в базу в каждый момент времени
+	kick       chan struct{}
+
+	notifier   Notifier // уведомления других реплик, nil - одна реплика
+	instanceID string
+}
+
+// NewCachedStore создает кэш cache перед backend
+func NewCachedStore(cache *MemStorage, backend BackingStore, flushInterval time.Duration, flushSize int) *CachedStore {
+	return &CachedStore{
+		MemStorage:    cache,
+		backend:       backend,
+		flushInterval: flushInterval,
+		flushSize:     flushSize,
+		maxPending:    maxCachePending,
+		kick:          make(chan struct{}, 1),
+	}
+}
+
+// Warm заменяет содержимое кэша состоянием базы
+// Серии, которых нет в базе (например, восстановленные из файла), удаляются из кэша
+func (c *CachedStore) Warm(ctx context.Context) error {
+	if err := c.refreshAll(ctx); err != nil {
+		return fmt.Errorf("failed to warm cache: %w", err)
+	}
+	logger.Sugar.Info("Cache warmed")
+	return nil
+}
+
+// Set обновляет gauge в памяти и ставит запись в очередь
+func (c *CachedStore) Set(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return c.UpdateBatch(ctx, []models.Metrics{{ID: name, MType: models.Gauge, Labels: labels, Value: &value}})
+}
+
+// Add обновляет counter в памяти и ставит запись в очередь
+func (c *CachedStore) Add(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return c.UpdateBatch(ctx, []models.Metrics{{ID: name, MType: models.Counter, Labels: labels, Delta: &delta}})
+}
+
+// UpdateBatch применяет пачку в памяти и ставит ее в очередь записи в базу
+// Если очередь заполнена (база долго недоступна), пачка отклоняется с ErrCacheFull
+// и в памяти не применяется
+func (c *CachedStore) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	if len(c.pending)+c.inflight+len(metrics) > c.maxPending {
+		c.mu.Unlock()
+		return fmt.Errorf("%w: %d updates pending", ErrCacheFull, len(c.pending)+c.inflight)
+	}
+	if err := c.MemStorage.UpdateBatch(ctx, metrics); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.pending = append(c.pending, metrics...)
+	full := len(c.pending) >= c.flushSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// UpdateCumulative записывает пачку с накопленными значениями counter в память и сразу в базу
+// (реализация CumulativeStore). Накопленное значение заменяет ожидающие записи в очереди обновления
+// этих серий; в базу оно записывается до записи очереди с более поздними обновлениями, поэтому
+// реплики, получившие одно значение, приходят к одному состоянию.
+// При ошибке базы значение остается в памяти, повтор записи идемпотентен
+func (c *CachedStore) UpdateCumulative(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.gate.Lock()
+	err := c.MemStorage.UpdateCumulative(ctx, metrics)
+	if err == nil {
+		c.dropPending(metrics)
+	}
+	c.gate.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := c.backend.UpdateCumulative(ctx, metrics); err != nil {
+		return err
+	}
+	c.notify(ctx, metrics)
+	return nil
+}
+
+// Delete удаляет метрики из памяти, из очереди и из базы
+func (c *CachedStore) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.gate.Lock()
+	deleted, err := c.MemStorage.Delete(ctx, metrics)
+	if err == nil {
+		c.dropPending(metrics)
+	}
+	c.gate.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.backend.Delete(ctx, metrics); err != nil {
+		return deleted, err
+	}
+	c.notify(ctx, metrics)
+	return deleted, nil
+}
+
+// EvictStale удаляет устаревшие метрики из памяти, из очереди и из базы (реализация ExpiringStore)
+func (c *CachedStore) EvictStale(ctx context.Context, before time.Time) (int, error) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.gate.Lock()
+	evicted := c.MemStorage.evictStale(before)
+	c.dropPending(evicted)
+	c.gate.Unlock()
+
+	if len(evicted) == 0 {
+		return 0, nil
+	}
+	if _, err := c.backend.Delete(ctx, evicted); err != nil {
+		return len(evicted), err
+	}
+	c.notify(ctx, evicted)
+	return len(evicted), nil
+}
+
+// dropPending убирает из очереди обновления удаленных или перезаписанных серий; вызывается под gate
+func (c *CachedStore) dropPending(metrics []models.Metrics) {
+	removed := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		removed[historyKey(m.MType, models.SeriesKey(m.ID, m.Labels))] = struct{}{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.pending[:0]
+	for _, m := range c.pending {
+		if _, ok := removed[historyKey(m.MType, models.SeriesKey(m.ID, m.Labels))]; !ok {
+			kept = append(kept, m)
+		}
+	}
+	c.pending = kept
+}
+
+// History возвращает историю значений из базы (реализация HistoryStore)
+// Отсчеты появляются в истории после записи очереди
+func (c *CachedStore) History(ctx context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	return c.backend.History(ctx, mType, name, labels, from, to, step)
+}
+
+// Pending возвращает количество обновлений, еще не записанных в базу
+func (c *CachedStore) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Flush записывает очередь в базу
+// При временной ошибке (isRetriablePgError) обновления возвращаются в начало очереди
+// и будут записаны при следующей попытке; приращения counter - только если запись точно
+// не применилась (requeueable). При постоянной ошибке пачка записывается
+// по одному обновлению: отклоненные базой обновления логируются и отбрасываются,
+// чтобы одна некорректная метрика не блокировала запись остальных
+func (c *CachedStore) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.inflight = len(batch)
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := c.backend.UpdateBatch(ctx, batch)
+	switch {
+	case err == nil:
+		c.release(nil)
+		c.notify(ctx, batch)
+		return nil
+	case isRetriablePgError(err):
+		c.release(requeueable(batch, err))
+		return fmt.Errorf("failed to flush %d cached updates: %w", len(batch), err)
+	}
+
+	written, dropped, rest, err := c.flushEach(ctx, batch)
+	c.release(rest)
+	if len(written) > 0 {
+		c.notify(ctx, written)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to flush %d cached updates: %w", len(rest), err)
+	}
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d cached updates rejected by the database", dropped)
+	}
+	return nil
+}
+
+// flushEach записывает пачку по одному обновлению после постоянной ошибки пачки
+// Возвращает записанные обновления, количество отброшенных и остаток пачки,
+// не записанный из-за временной ошибки
+func (c *CachedStore) flushEach(ctx context.Context, batch []models.Metrics) (written []models.Metrics, dropped int, rest []models.Metrics, err error) {
+	for i, m := range batch {
+		err := c.backend.UpdateBatch(ctx, []models.Metrics{m})
+		switch {
+		case err == nil:
+			written = append(written, m)
+		case isRetriablePgError(err):
+			return written, dropped, append(requeueable(batch[i:i+1], err), batch[i+1:]...), err
+		default:
+			dropped++
+			logger.Sugar.Errorw("Dropping cached update rejected by the database",
+				"metric", models.SeriesKey(m.ID, m.Labels), "type", m.MType, "code", pgErrorCode(err), "error", err)
+		}
+	}
+	return written, dropped, nil, nil
+}
+
+// requeueable возвращает обновления пачки, которые можно записать повторно после временной ошибки err
+// Если запись могла примениться (исключение соединения после отправки), приращения counter
+// отбрасываются с записью в лог: повтор мог бы их удвоить. Остальные обновления перезаписывают
+// значение, поэтому возвращаются всегда
+func requeueable(batch []models.Metrics, err error) []models.Metrics {
+	if isUncommittedPgError(err) {
+		return batch
+	}
+
+	kept := make([]models.Metrics, 0, len(batch))
+	for _, m := range batch {
+		if m.MType != models.Counter {
+			kept = append(kept, m)
+		}
+	}
+	if lost := len(batch) - len(kept); lost > 0 {
+		logger.Sugar.Errorw("Dropping cached counter updates: the failed write may have been committed",
+			"counters", lost, "code", pgErrorCode(err), "error", err)
+	}
+	return kept
+}
+
+// release возвращает незаписанные обновления rest в начало очереди и завершает запись пачки
+func (c *CachedStore) release(rest []models.Metrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(rest) > 0 {
+		c.pending = append(rest, c.pending...)
+	}
+	c.inflight = 0
+}
+
+// Run записывает очередь в базу по таймеру и при заполнении до отмены ctx
+// Финальную запись после остановки обработчиков выполняет вызывающий через Flush
+func (c *CachedStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Sugar.Info("Cache write-behind stopped")
+			return
+		case <-ticker.C:
+		case <-c.kick:
+		}
+		if err := c.Flush(ctx); err != nil {
+			logger.Sugar.Errorw("Cache flush failed", "error", err, "pending", c.Pending())
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+const (
+	// maxNoticePayload - предел размера уведомления (у PostgreSQL - 8000 байт),
+	// при превышении реплики перечитывают все метрики
+	maxNoticePayload = 7900
+	// relistenDelay - пауза перед повторной подпиской после обрыва соединения
+	relistenDelay = time.Second
+)
+
+// Notifier доставляет уведомления об изменениях между репликами сервера
+// Реализация: PostgresRepository (NOTIFY/LISTEN)
+type Notifier interface {
+	// Notify рассылает уведомление всем подписанным репликам
+	Notify(ctx context.Context, payload string) error
+	// Listen подписывается на уведомления и вызывает handle для каждого до отмены ctx
+	// или обрыва соединения; ready вызывается, когда подписка установлена
+	Listen(ctx context.Context, ready func(), handle func(payload string)) error
+}
+
+// seriesRef - ссылка на серию в уведомлении
+type seriesRef struct {
+	MType  string        `json:"type"`
+	ID     string        `json:"id"`
+	Labels models.Labels `json:"labels,omitempty"`
+}
+
+// key возвращает ключ серии с учетом типа
+func (r seriesRef) key() string {
+	return historyKey(r.MType, models.SeriesKey(r.ID, r.Labels))
+}
+
+// changeNotice - уведомление об измененных в базе сериях
+type changeNotice struct {
+	Origin string      `json:"origin"`        // реплика-отправитель, свои уведомления не обрабатываются
+	All    bool        `json:"all,omitempty"` // изменений слишком много, нужно перечитать все
+	Series []seriesRef `json:"series,omitempty"`
+}
+
+// pendingState - незаписанные в базу локальные изменения серии
+type pendingState struct {
+	delta int64 // сумма дельт counter
+}
+
+// EnableNotifications включает обмен уведомлениями об изменениях с другими репликами
+// Вызывается до Run и Listen
+func (c *CachedStore) EnableNotifications(n Notifier) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate instance id: %w", err)
+	}
+	c.notifier = n
+	c.instanceID = hex.EncodeToString(id)
+	return nil
+}
+
+// notify сообщает другим репликам об изменении серий metrics
+func (c *CachedStore) notify(ctx context.Context, metrics []models.Metrics) {
+	if c.notifier == nil || len(metrics) == 0 {
+		return
+	}
+
+	notice := changeNotice{Origin: c.instanceID}
+	seen := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		ref := seriesRef{MType: m.MType, ID: m.ID, Labels: m.Labels}
+		if _, ok := seen[ref.key()]; ok {
+			continue
+		}
+		seen[ref.key()] = struct{}{}
+		notice.Series = append(notice.Series, ref)
+	}
+
+	payload, err := json.Marshal(notice)
+	if err == nil && len(payload) > maxNoticePayload {
+		payload, err = json.Marshal(changeNotice{Origin: c.instanceID, All: true})
+	}
+	if err == nil {
+		err = c.notifier.Notify(ctx, string(payload))
+	}
+	if err != nil {
+		logger.Sugar.Warnw("Failed to notify replicas", "error", err)
+	}
+}
+
+// Listen получает уведомления других реплик и обновляет кэш до отмены ctx
+// После каждой (пере)подписки кэш перечитывается целиком, чтобы учесть пропущенные уведомления
+func (c *CachedStore) Listen(ctx context.Context) {
+	if c.notifier == nil {
+		return
+	}
+
+	ready := func() {
+		if err := c.refreshAll(ctx); err != nil {
+			logger.Sugar.Errorw("Failed to refresh cache", "error", err)
+		}
+	}
+	for {
+		err := c.notifier.Listen(ctx, ready, func(payload string) { c.handleNotice(ctx, payload) })
+		if ctx.Err() != nil {
+			logger.Sugar.Info("Cache invalidation stopped")
+			return
+		}
+		logger.Sugar.Warnw("Cache invalidation listener failed, resubscribing", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relistenDelay):
+		}
+	}
+}
+
+// handleNotice обновляет кэш по уведомлению другой реплики
+func (c *CachedStore) handleNotice(ctx context.Context, payload string) {
+	var notice changeNotice
+	if err := json.Unmarshal([]byte(payload), &notice); err != nil {
+		logger.Sugar.Warnw("Invalid cache notice", "error", err)
+		return
+	}
+	if notice.Origin == c.instanceID {
+		return
+	}
+
+	var err error
+	if notice.All {
+		err = c.refreshAll(ctx)
+	} else {
+		err = c.refresh(ctx, notice.Series)
+	}
+	if err != nil {
+		logger.Sugar.Errorw("Failed to refresh cache", "error", err)
+	}
+}
+
+// pendingByKey возвращает незаписанные изменения по ключам серий
+func (c *CachedStore) pendingByKey() map[string]pendingState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]pendingState)
+	for _, m := range c.pending {
+		key := historyKey(m.MType, models.SeriesKey(m.ID, m.Labels))
+		state := result[key]
+		if m.MType == models.Counter {
+			state.delta += *m.Delta
+		}
+		result[key] = state
+	}
+	return result
+}
+
+// applyRemote записывает в кэш значение серии из базы поверх незаписанных локальных изменений:
+// к counter прибавляются локальные дельты, остальные типы с локальными изменениями не трогаются
+// Вызывается под gate
+func (c *CachedStore) applyRemote(m models.Metrics, pending map[string]pendingState) {
+	ts := time.Now()
+	if m.UpdatedAt != nil {
+		ts = *m.UpdatedAt
+	}
+	state, local := pending[historyKey(m.MType, models.SeriesKey(m.ID, m.Labels))]
+
+	switch {
+	case m.MType == models.Counter:
+		c.MemStorage.setCounter(m.ID, m.Labels, *m.Delta+state.delta, ts)
+	case local:
+		// Локальное значение новее значения в базе
+	case m.MType == models.Gauge:
+		c.MemStorage.setGauge(m.ID, m.Labels, *m.Value, ts)
+	case m.MType == models.Histogram:
+		c.MemStorage.setHistogram(m.ID, m.Labels, *m.Histogram, ts)
+	case m.MType == models.Summary:
+		c.MemStorage.setSummary(m.ID, m.Labels, *m.Summary, ts)
+	}
+}
+
+// removeRemote удаляет из кэша серию, отсутствующую в базе, если у нее нет незаписанных изменений
+// Вызывается под gate
+func (c *CachedStore) removeRemote(ref seriesRef, pending map[string]pendingState) {
+	if _, local := pending[ref.key()]; local {
+		return
+	}
+	c.MemStorage.Delete(context.Background(), []models.Metrics{{ID: ref.ID, MType: ref.MType, Labels: ref.Labels}})
+}
+
+// refresh перечитывает из базы перечисленные серии
+func (c *CachedStore) refresh(ctx context.Context, refs []seriesRef) error {
+	// Запись очереди не должна выполняться одновременно: иначе изменения, уже изъятые
+	// из очереди, но еще не записанные в базу, потеряются в кэше
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	found := make([]models.Metrics, 0, len(refs))
+	var missing []seriesRef
+	for _, ref := range refs {
+		m, err := c.backend.Get(ctx, ref.MType, ref.ID, ref.Labels)
+		switch {
+		case errors.Is(err, ErrMetricNotFound):
+			missing = append(missing, ref)
+		case err != nil:
+			return fmt.Errorf("failed to refresh %s %q: %w", ref.MType, ref.ID, err)
+		default:
+			found = append(found, m)
+		}
+	}
+
+	c.gate.Lock()
+	defer c.gate.Unlock()
+
+	pending := c.pendingByKey()
+	for _, ref := range missing {
+		c.removeRemote(ref, pending)
+	}
+	for _, m := range found {
+		c.applyRemote(m, pending)
+	}
+	return nil
+}
+
+// refreshAll заменяет содержимое кэша состоянием базы, сохраняя незаписанные локальные изменения
+func (c *CachedStore) refreshAll(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	metrics, err := c.backend.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list metrics: %w", err)
+	}
+
+	c.gate.Lock()
+	defer c.gate.Unlock()
+
+	remote := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		remote[historyKey(m.MType, models.SeriesKey(m.ID, m.Labels))] = struct{}{}
+	}
+
+	pending := c.pendingByKey()
+	cached, _ := c.MemStorage.List(ctx)
+	for _, m := range cached {
+		ref := seriesRef{MType: m.MType, ID: m.ID, Labels: m.Labels}
+		if _, ok := remote[ref.key()]; !ok {
+			c.removeRemote(ref, pending)
+		}
+	}
+	for _, m := range metrics {
+		c.applyRemote(m, pending)
+	}
+	return nil
+}
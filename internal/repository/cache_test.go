@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// unsentError - ошибка соединения, при которой запрос не был отправлен в базу
+type unsentError struct{}
+
+func (unsentError) Error() string     { return "connection refused" }
+func (unsentError) SafeToRetry() bool { return true }
+
+// failingStore - хранилище, отклоняющее запись пачек временной ошибкой соединения
+// Без err запрос не доходит до базы; err задает ошибку, после которой исход записи неизвестен
+type failingStore struct {
+	*MemStorage
+	fail bool
+	err  error
+}
+
+func (f *failingStore) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	if !f.fail {
+		return f.MemStorage.UpdateBatch(ctx, metrics)
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return fmt.Errorf("database is unavailable: %w", unsentError{})
+}
+
+// rejectingStore - хранилище, постоянно отклоняющее пачки с метрикой bad
+type rejectingStore struct {
+	*MemStorage
+	bad string
+}
+
+func (r *rejectingStore) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	for _, m := range metrics {
+		if m.ID == r.bad {
+			return &pgconn.PgError{Code: pgerrcode.NumericValueOutOfRange}
+		}
+	}
+	return r.MemStorage.UpdateBatch(ctx, metrics)
+}
+
+func TestCachedStore_WriteBehind(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemStorage()
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+
+	if err := cache.Add(ctx, "requests", nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Add(ctx, "requests", nil, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Чтение из памяти видит обновление сразу, база - только после записи очереди
+	m, err := cache.Get(ctx, models.Counter, "requests", nil)
+	if err != nil || *m.Delta != 5 {
+		t.Fatalf("cached counter = %v (err %v), want 5", m.Delta, err)
+	}
+	if _, err := backend.Get(ctx, models.Counter, "requests", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Fatalf("backend has the counter before flush: %v", err)
+	}
+
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	m, err = backend.Get(ctx, models.Counter, "requests", nil)
+	if err != nil || *m.Delta != 5 {
+		t.Errorf("backend counter = %v (err %v), want 5", m.Delta, err)
+	}
+	if cache.Pending() != 0 {
+		t.Errorf("Pending() = %d after flush, want 0", cache.Pending())
+	}
+}
+
+func TestCachedStore_FlushFailureKeepsUpdates(t *testing.T) {
+	ctx := context.Background()
+	backend := &failingStore{MemStorage: NewMemStorage(), fail: true}
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+
+	cache.Set(ctx, "cpu", nil, 1.5)
+	if err := cache.Flush(ctx); err == nil {
+		t.Fatal("Flush() succeeded with unavailable backend")
+	}
+	if cache.Pending() != 1 {
+		t.Fatalf("Pending() = %d after failed flush, want 1", cache.Pending())
+	}
+
+	backend.fail = false
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, models.Gauge, "cpu", nil); err != nil {
+		t.Errorf("backend gauge after retry: %v", err)
+	}
+}
+
+func TestCachedStore_FlushUnknownOutcomeDropsCounters(t *testing.T) {
+	ctx := context.Background()
+	logs := observeLogs(t)
+	// Соединение разорвано после отправки пачки: COMMIT мог выполниться
+	backend := &failingStore{MemStorage: NewMemStorage(), fail: true, err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}}
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+
+	cache.Set(ctx, "cpu", nil, 1.5)
+	cache.Add(ctx, "requests", nil, 3)
+	if err := cache.Flush(ctx); err == nil {
+		t.Fatal("Flush() succeeded with unavailable backend")
+	}
+
+	// gauge повторяется, приращение counter - нет, чтобы не удвоить его
+	if cache.Pending() != 1 {
+		t.Fatalf("Pending() = %d after failed flush, want 1", cache.Pending())
+	}
+	if n := logs.FilterMessageSnippet("Dropping cached counter updates").Len(); n != 1 {
+		t.Errorf("got %d log entries about dropped counters, want 1", n)
+	}
+	backend.fail = false
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, models.Gauge, "cpu", nil); err != nil {
+		t.Errorf("backend gauge after retry: %v", err)
+	}
+	if _, err := backend.Get(ctx, models.Counter, "requests", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("counter with unknown outcome was written again: %v", err)
+	}
+}
+
+func TestCachedStore_FlushDropsPermanentFailures(t *testing.T) {
+	ctx := context.Background()
+	backend := &rejectingStore{MemStorage: NewMemStorage(), bad: "overflow"}
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+
+	cache.Set(ctx, "cpu", nil, 1.5)
+	cache.Set(ctx, "overflow", nil, 1e300)
+	cache.Add(ctx, "requests", nil, 2)
+
+	// Постоянная ошибка не возвращает пачку в очередь: некорректная метрика отбрасывается,
+	// остальные записываются
+	if err := cache.Flush(ctx); err == nil {
+		t.Fatal("Flush() succeeded although an update was dropped")
+	}
+	if cache.Pending() != 0 {
+		t.Fatalf("Pending() = %d after permanent failure, want 0", cache.Pending())
+	}
+	if _, err := backend.Get(ctx, models.Gauge, "cpu", nil); err != nil {
+		t.Errorf("backend gauge cpu: %v", err)
+	}
+	if m, err := backend.Get(ctx, models.Counter, "requests", nil); err != nil || *m.Delta != 2 {
+		t.Errorf("backend counter requests = %v (err %v), want 2", m.Delta, err)
+	}
+	if _, err := backend.Get(ctx, models.Gauge, "overflow", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("backend has the rejected metric: %v", err)
+	}
+
+	// Следующие записи не блокируются отброшенной метрикой
+	cache.Set(ctx, "cpu", nil, 2.5)
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+}
+
+func TestCachedStore_PendingLimit(t *testing.T) {
+	ctx := context.Background()
+	backend := &failingStore{MemStorage: NewMemStorage(), fail: true}
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+	cache.maxPending = 2
+
+	if err := cache.Add(ctx, "requests", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Add(ctx, "requests", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(ctx); err == nil {
+		t.Fatal("Flush() succeeded with unavailable backend")
+	}
+
+	// Очередь заполнена: обновление отклоняется и не применяется в памяти
+	if err := cache.Add(ctx, "requests", nil, 1); !errors.Is(err, ErrCacheFull) {
+		t.Fatalf("Add() error = %v, want ErrCacheFull", err)
+	}
+	if m, _ := cache.Get(ctx, models.Counter, "requests", nil); *m.Delta != 2 {
+		t.Errorf("cached counter = %d, want 2", *m.Delta)
+	}
+	if cache.Pending() != 2 {
+		t.Errorf("Pending() = %d, want 2", cache.Pending())
+	}
+
+	backend.fail = false
+	if err := cache.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if err := cache.Add(ctx, "requests", nil, 1); err != nil {
+		t.Errorf("Add() after flush error = %v", err)
+	}
+}
+
+func TestCachedStore_FlushesAtSizeThreshold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := NewMemStorage()
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 2)
+	go cache.Run(ctx)
+
+	cache.Set(ctx, "a", nil, 1)
+	cache.Set(ctx, "b", nil, 2)
+
+	deadline := time.Now().Add(time.Second)
+	for cache.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := backend.Get(ctx, models.Gauge, "b", nil); err != nil {
+		t.Errorf("backend gauge after size threshold: %v", err)
+	}
+}
+
+func TestCachedStore_DeleteDropsPending(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemStorage()
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+
+	cache.Set(ctx, "cpu", nil, 1)
+	cache.Flush(ctx)
+	cache.Set(ctx, "cpu", nil, 2)
+
+	deleted, err := cache.Delete(ctx, []models.Metrics{{ID: "cpu", MType: models.Gauge}})
+	if err != nil || deleted != 1 {
+		t.Fatalf("Delete() = %d, %v", deleted, err)
+	}
+	cache.Flush(ctx)
+	if _, err := backend.Get(ctx, models.Gauge, "cpu", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("deleted gauge is back in backend: %v", err)
+	}
+}
+
+func TestCachedStore_UpdateCumulative(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemStorage()
+	cache := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+	d := func(v int64) *int64 { return &v }
+
+	cache.Add(ctx, "requests", nil, 5)
+	cache.Flush(ctx)
+	cache.Add(ctx, "requests", nil, 2)
+
+	// Накопленное значение записывается в базу сразу и заменяет ожидающее приращение
+	if err := cache.UpdateCumulative(ctx, []models.Metrics{{ID: "requests", MType: models.Counter, Delta: d(40)}}); err != nil {
+		t.Fatalf("UpdateCumulative() error = %v", err)
+	}
+	if cache.Pending() != 0 {
+		t.Errorf("pending = %d, want 0", cache.Pending())
+	}
+	cache.Flush(ctx)
+	for name, store := range map[string]MetricStore{"cache": cache, "backend": backend} {
+		m, err := store.Get(ctx, models.Counter, "requests", nil)
+		if err != nil || *m.Delta != 40 {
+			t.Errorf("%s requests = %+v, %v; want 40", name, m, err)
+		}
+	}
+}
+
+func TestCachedStore_Warm(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemStorage()
+	backend.Add(ctx, "requests", models.Labels{"host": "a"}, 7)
+
+	mem := NewMemStorage()
+	mem.Add(ctx, "requests", models.Labels{"host": "a"}, 100)
+	mem.Set(ctx, "orphan", nil, 1)
+
+	cache := NewCachedStore(mem, backend, time.Hour, 100)
+	if err := cache.Warm(ctx); err != nil {
+		t.Fatalf("Warm() error = %v", err)
+	}
+
+	m, err := cache.Get(ctx, models.Counter, "requests", models.Labels{"host": "a"})
+	if err != nil || *m.Delta != 7 {
+		t.Errorf("warmed counter = %v (err %v), want value from backend 7", m.Delta, err)
+	}
+	if _, err := cache.Get(ctx, models.Gauge, "orphan", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("series absent from backend survived warm: %v", err)
+	}
+}
+
+// memoryBus - Notifier, доставляющий уведомления подписчикам в том же процессе
+type memoryBus struct {
+	mu       sync.Mutex
+	handlers []func(string)
+}
+
+func (b *memoryBus) Notify(_ context.Context, payload string) error {
+	b.mu.Lock()
+	handlers := append([]func(string){}, b.handlers...)
+	b.mu.Unlock()
+	for _, handle := range handlers {
+		handle(payload)
+	}
+	return nil
+}
+
+func (b *memoryBus) Listen(ctx context.Context, ready func(), handle func(string)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handle)
+	b.mu.Unlock()
+	ready()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *memoryBus) listeners() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.handlers)
+}
+
+func TestCachedStore_ReplicasStayConsistent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewMemStorage()
+	bus := &memoryBus{}
+	replicaA := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+	replicaB := NewCachedStore(NewMemStorage(), backend, time.Hour, 100)
+	for _, r := range []*CachedStore{replicaA, replicaB} {
+		if err := r.EnableNotifications(bus); err != nil {
+			t.Fatal(err)
+		}
+		go r.Listen(ctx)
+	}
+	for deadline := time.Now().Add(time.Second); bus.listeners() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Незаписанная дельта реплики B сохраняется при обновлении counter от реплики A
+	replicaB.Add(ctx, "requests", nil, 2)
+	replicaA.Add(ctx, "requests", nil, 5)
+	replicaA.Set(ctx, "cpu", nil, 0.5)
+	if err := replicaA.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := replicaB.Get(ctx, models.Counter, "requests", nil)
+	if err != nil || *m.Delta != 7 {
+		t.Errorf("replica B counter = %v (err %v), want 5 from A plus 2 pending", m.Delta, err)
+	}
+	if m, err := replicaB.Get(ctx, models.Gauge, "cpu", nil); err != nil || *m.Value != 0.5 {
+		t.Errorf("replica B gauge = %v (err %v), want 0.5", m.Value, err)
+	}
+
+	if err := replicaB.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := replicaA.Get(ctx, models.Counter, "requests", nil); err != nil || *m.Delta != 7 {
+		t.Errorf("replica A counter = %v (err %v), want 7", m.Delta, err)
+	}
+
+	// Удаление на одной реплике видно на другой
+	if _, err := replicaA.Delete(ctx, []models.Metrics{{ID: "cpu", MType: models.Gauge}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replicaB.Get(ctx, models.Gauge, "cpu", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("deleted gauge is still cached on replica B: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// ExpiringStore описывает хранилище, умеющее удалять метрики, которые давно не обновлялись
+type ExpiringStore interface {
+	// EvictStale удаляет метрики, последнее обновление которых было раньше before,
+	// и возвращает количество удаленных
+	EvictStale(ctx context.Context, before time.Time) (int, error)
+}
+
+// setUpdatedAt заполняет время обновления метрики и признак устаревания (ttl 0 - не устаревает)
+func setUpdatedAt(m *models.Metrics, updated time.Time, ttl time.Duration, now time.Time) {
+	if updated.IsZero() {
+		return
+	}
+	m.UpdatedAt = &updated
+	m.Stale = ttl > 0 && now.Sub(updated) > ttl
+}
+
+// evictionInterval возвращает период проверки устаревших метрик: половина TTL, но не чаще раза в секунду
+func evictionInterval(ttl time.Duration) time.Duration {
+	if interval := ttl / 2; interval > time.Second {
+		return interval
+	}
+	return time.Second
+}
+
+// RunEvictionWithContext периодически удаляет метрики, не обновлявшиеся дольше ttl
+func RunEvictionWithContext(ctx context.Context, store ExpiringStore, ttl time.Duration) {
+	ticker := time.NewTicker(evictionInterval(ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Sugar.Info("Stale metrics eviction stopped")
+			return
+		case <-ticker.C:
+			evicted, err := store.EvictStale(ctx, time.Now().Add(-ttl))
+			if err != nil {
+				logger.Sugar.Errorw("Stale metrics eviction failed", "error", err)
+			} else if evicted > 0 {
+				logger.Sugar.Infow("Stale metrics evicted", "count", evicted)
+			}
+		}
+	}
+}
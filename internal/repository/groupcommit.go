@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Ошибки группового коммита
+var (
+	ErrStoreClosed           = errors.New("store is closed")                                  // очередь записи остановлена
+	ErrHistoryUnsupported    = errors.New("history is not supported by storage")              // хранилище не реализует HistoryStore
+	ErrCumulativeUnsupported = errors.New("cumulative counters are not supported by storage") // хранилище не реализует CumulativeStore
+)
+
+// commitRequest - обновления одного вызывающего, ожидающие записи в составе группы
+type commitRequest struct {
+	metrics []models.Metrics
+	result  chan error
+}
+
+// GroupCommitStore - обертка над MetricStore, собирающая обновления параллельных запросов в группы
+// Группа записывается одним UpdateBatch после накопления maxItems метрик или через maxWait
+// после первого обновления; каждый вызывающий получает ответ только после записи своей группы
+// Чтение, удаление и запись накопленных значений (UpdateCumulative) выполняются напрямую
+type GroupCommitStore struct {
+	MetricStore
+	maxItems int
+	maxWait  time.Duration
+	requests chan commitRequest
+	done     chan struct{}
+}
+
+// NewGroupCommitStore создает обертку с группами до maxItems метрик и ожиданием не дольше maxWait
+// Очередь начинает работу после вызова Run
+func NewGroupCommitStore(store MetricStore, maxItems int, maxWait time.Duration) *GroupCommitStore {
+	return &GroupCommitStore{
+		MetricStore: store,
+		maxItems:    maxItems,
+		maxWait:     maxWait,
+		requests:    make(chan commitRequest),
+		done:        make(chan struct{}),
+	}
+}
+
+// Set ставит обновление gauge в очередь и ждет записи группы
+func (g *GroupCommitStore) Set(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return g.UpdateBatch(ctx, []models.Metrics{{ID: name, MType: models.Gauge, Labels: labels, Value: &value}})
+}
+
+// Add ставит обновление counter в очередь и ждет записи группы
+func (g *GroupCommitStore) Add(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return g.UpdateBatch(ctx, []models.Metrics{{ID: name, MType: models.Counter, Labels: labels, Delta: &delta}})
+}
+
+// UpdateBatch ставит пачку в очередь и ждет записи группы
+// Пачка проверяется до постановки в очередь, чтобы ошибка одного запроса не отклонила всю группу
+// При отмене ctx вызов завершается, но уже поставленные обновления будут записаны
+func (g *GroupCommitStore) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	req := commitRequest{metrics: metrics, result: make(chan error, 1)}
+	select {
+	case g.requests <- req:
+	case <-g.done:
+		return ErrStoreClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// History возвращает историю значений, если ее поддерживает исходное хранилище
+func (g *GroupCommitStore) History(ctx context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	history, ok := g.MetricStore.(HistoryStore)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return history.History(ctx, mType, name, labels, from, to, step)
+}
+
+// UpdateCumulative записывает пачку накопленных значений напрямую, без группы,
+// если это поддерживает исходное хранилище
+func (g *GroupCommitStore) UpdateCumulative(ctx context.Context, metrics []models.Metrics) error {
+	cumulative, ok := g.MetricStore.(CumulativeStore)
+	if !ok {
+		return ErrCumulativeUnsupported
+	}
+	return cumulative.UpdateCumulative(ctx, metrics)
+}
+
+// Run обрабатывает очередь до отмены ctx, после чего записывает накопленную группу
+// и отклоняет новые обновления с ErrStoreClosed
+func (g *GroupCommitStore) Run(ctx context.Context) {
+	defer close(g.done)
+
+	var (
+		group []commitRequest
+		items int
+		timer *time.Timer
+		flush <-chan time.Time
+	)
+
+	commit := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, flush = nil, nil
+		if len(group) == 0 {
+			return
+		}
+		g.commit(group, items)
+		group, items = nil, 0
+	}
+
+	for {
+		select {
+		case req := <-g.requests:
+			group = append(group, req)
+			items += len(req.metrics)
+			if items >= g.maxItems {
+				commit()
+			} else if timer == nil {
+				timer = time.NewTimer(g.maxWait)
+				flush = timer.C
+			}
+		case <-flush:
+			commit()
+		case <-ctx.Done():
+			commit()
+			logger.Sugar.Info("Group commit stopped")
+			return
+		}
+	}
+}
+
+// commit записывает группу одним UpdateBatch и сообщает результат всем ее участникам
+// Для файла (FileStorage) UpdateBatch завершается после fsync, поэтому ответ получают
+// только обновления, сохраненные на диске
+func (g *GroupCommitStore) commit(group []commitRequest, items int) {
+	metrics := make([]models.Metrics, 0, items)
+	for _, req := range group {
+		metrics = append(metrics, req.metrics...)
+	}
+
+	// Запись не привязана к контексту очереди, чтобы группа дописалась при остановке сервера
+	err := g.MetricStore.UpdateBatch(context.Background(), metrics)
+	if err != nil {
+		err = fmt.Errorf("group commit of %d metrics failed: %w", len(metrics), err)
+	}
+	for _, req := range group {
+		req.result <- err
+	}
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// countingStore считает вызовы UpdateBatch исходного хранилища
+type countingStore struct {
+	*MemStorage
+	batches atomic.Int32
+}
+
+func (c *countingStore) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	c.batches.Add(1)
+	return c.MemStorage.UpdateBatch(ctx, metrics)
+}
+
+func TestGroupCommitStore_CoalescesConcurrentUpdates(t *testing.T) {
+	store := &countingStore{MemStorage: NewMemStorage()}
+	grouped := NewGroupCommitStore(store, 10, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go grouped.Run(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := grouped.Add(context.Background(), "requests", nil, 1); err != nil {
+				t.Errorf("Add() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Группа из 10 метрик записана одним вызовом и до ответа вызывающим
+	if got := store.batches.Load(); got != 1 {
+		t.Errorf("UpdateBatch called %d times, want 1", got)
+	}
+	m, err := store.Get(context.Background(), models.Counter, "requests", nil)
+	if err != nil || *m.Delta != 10 {
+		t.Errorf("counter = %v (err %v), want 10", m.Delta, err)
+	}
+}
+
+func TestGroupCommitStore_FlushesAfterWait(t *testing.T) {
+	store := &countingStore{MemStorage: NewMemStorage()}
+	grouped := NewGroupCommitStore(store, 100, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go grouped.Run(ctx)
+
+	if err := grouped.Set(context.Background(), "cpu", nil, 1.5); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := store.Get(context.Background(), models.Gauge, "cpu", nil); err != nil {
+		t.Errorf("gauge is not written after Set returned: %v", err)
+	}
+}
+
+func TestGroupCommitStore_RejectsInvalidBeforeQueueing(t *testing.T) {
+	store := &countingStore{MemStorage: NewMemStorage()}
+	grouped := NewGroupCommitStore(store, 100, time.Millisecond)
+
+	err := grouped.UpdateBatch(context.Background(), []models.Metrics{{ID: "cpu", MType: models.Gauge}})
+	if !errors.Is(err, ErrMissingValue) {
+		t.Errorf("UpdateBatch() error = %v, want ErrMissingValue", err)
+	}
+}
+
+func TestGroupCommitStore_Closed(t *testing.T) {
+	grouped := NewGroupCommitStore(NewMemStorage(), 100, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	grouped.Run(ctx)
+
+	if err := grouped.Set(context.Background(), "cpu", nil, 1); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("Set() error = %v, want ErrStoreClosed", err)
+	}
+}
+
+func TestGroupCommitStore_SyncsFileOncePerGroup(t *testing.T) {
+	producer, err := NewProducer(filepath.Join(t.TempDir(), "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	var syncs atomic.Int32
+	syncErr := errors.New("disk is gone")
+	failSync := atomic.Bool{}
+	prev := syncFile
+	syncFile = func(f *os.File) error {
+		syncs.Add(1)
+		if failSync.Load() {
+			return syncErr
+		}
+		return prev(f)
+	}
+	defer func() { syncFile = prev }()
+
+	grouped := NewGroupCommitStore(NewFileStorage(NewMemStorage(), producer), 3, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go grouped.Run(ctx)
+
+	// addGroup отправляет группу из трех обновлений и возвращает ошибки участников
+	addGroup := func() []error {
+		errs := make([]error, 3)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = grouped.Add(context.Background(), "requests", nil, 1)
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+
+	for _, err := range addGroup() {
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if got := syncs.Load(); got != 1 {
+		t.Errorf("file synced %d times for one group, want 1", got)
+	}
+
+	// Ошибку fsync получают все участники группы
+	failSync.Store(true)
+	for i, err := range addGroup() {
+		if !errors.Is(err, syncErr) {
+			t.Errorf("waiter %d error = %v, want sync error", i, err)
+		}
+	}
+}
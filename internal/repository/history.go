@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// HistoryStore описывает хранилище, умеющее отдавать историю значений метрики за период
+type HistoryStore interface {
+	// History возвращает отсчеты серии (имя и метки) в интервале [from, to]
+	// Если step больше нуля, из каждого интервала длиной step берется последний отсчет
+	History(ctx context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error)
+}
+
+// ringBuffer - кольцевой буфер отсчетов фиксированной емкости
+// При переполнении самые старые отсчеты перезаписываются
+type ringBuffer struct {
+	samples []models.Sample
+	start   int // индекс самого старого отсчета
+	size    int // количество заполненных элементов
+}
+
+// newRingBuffer создает буфер на capacity отсчетов
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{samples: make([]models.Sample, capacity)}
+}
+
+// push добавляет отсчет, вытесняя самый старый при заполненном буфере
+func (r *ringBuffer) push(sample models.Sample) {
+	end := (r.start + r.size) % len(r.samples)
+	r.samples[end] = sample
+	if r.size < len(r.samples) {
+		r.size++
+		return
+	}
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// trimBefore удаляет отсчеты старше указанного момента
+func (r *ringBuffer) trimBefore(t time.Time) {
+	for r.size > 0 && r.samples[r.start].TS.Before(t) {
+		r.samples[r.start] = models.Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.size--
+	}
+}
+
+// at возвращает i-й по возрасту отсчет
+func (r *ringBuffer) at(i int) models.Sample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// rangeQuery возвращает копии отсчетов из интервала [from, to] с прореживанием по step
+func (r *ringBuffer) rangeQuery(from, to time.Time, step time.Duration) []models.Sample {
+	result := make([]models.Sample, 0)
+	for i := 0; i < r.size; i++ {
+		sample := r.at(i)
+		if sample.TS.Before(from) || sample.TS.After(to) {
+			continue
+		}
+
+		result = appendSample(result, sample, from, step)
+	}
+	return result
+}
+
+// appendSample добавляет отсчет к результату с прореживанием по step
+// Отсчеты идут по возрастанию времени, поэтому последний отсчет
+// того же интервала step заменяет предыдущий
+func appendSample(result []models.Sample, sample models.Sample, from time.Time, step time.Duration) []models.Sample {
+	if step > 0 && len(result) > 0 {
+		last := result[len(result)-1]
+		if sample.TS.Sub(from)/step == last.TS.Sub(from)/step {
+			result[len(result)-1] = sample
+			return result
+		}
+	}
+	return append(result, sample)
+}
+
+// historyKey формирует ключ истории серии внутри сегмента
+func historyKey(mType, seriesKey string) string {
+	return mType + ":" + seriesKey
+}
+
+// NewMemStorageWithHistory создает хранилище, сохраняющее для каждой метрики
+// до capacity последних значений не старше retention (0 - без ограничения по времени)
+func NewMemStorageWithHistory(capacity int, retention time.Duration) *MemStorage {
+	return &MemStorage{
+		historyCapacity:  capacity,
+		historyRetention: retention,
+	}
+}
+
+// recordSample сохраняет значение метрики в историю; вызывается под блокировкой сегмента
+func (s *MemStorage) recordSample(sh *shard, mType, seriesKey string, value float64, ts time.Time) {
+	if s.historyCapacity <= 0 {
+		return
+	}
+
+	if sh.history == nil {
+		sh.history = make(map[string]*ringBuffer)
+	}
+	key := historyKey(mType, seriesKey)
+	buf, ok := sh.history[key]
+	if !ok {
+		buf = newRingBuffer(s.historyCapacity)
+		sh.history[key] = buf
+	}
+
+	if s.historyRetention > 0 {
+		buf.trimBefore(time.Now().Add(-s.historyRetention))
+	}
+	buf.push(models.Sample{TS: ts, Value: value})
+}
+
+// History возвращает историю значений метрики за период (реализация HistoryStore)
+func (s *MemStorage) History(_ context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	if mType != models.Gauge && mType != models.Counter {
+		return nil, fmt.Errorf("%q: %w", mType, ErrUnknownType)
+	}
+
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	buf, ok := sh.history[historyKey(mType, key)]
+	if !ok {
+		return nil, fmt.Errorf("%s %q: %w", mType, key, ErrMetricNotFound)
+	}
+
+	// Отбрасываем устаревшие отсчеты, даже если метрика давно не обновлялась
+	if s.historyRetention > 0 {
+		buf.trimBefore(time.Now().Add(-s.historyRetention))
+	}
+	return buf.rangeQuery(from, to, step), nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+func TestRingBuffer_PushOverwritesOldest(t *testing.T) {
+	base := time.Unix(1000, 0)
+	buf := newRingBuffer(3)
+	for i := 0; i < 5; i++ {
+		buf.push(models.Sample{TS: base.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	got := buf.rangeQuery(base, base.Add(time.Minute), 0)
+	want := []float64{2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i, s := range got {
+		if s.Value != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, s.Value, want[i])
+		}
+	}
+}
+
+func TestRingBuffer_RangeQuery(t *testing.T) {
+	base := time.Unix(1000, 0)
+	buf := newRingBuffer(10)
+	for i := 0; i < 10; i++ {
+		buf.push(models.Sample{TS: base.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		step time.Duration
+		want []float64
+	}{
+		{
+			name: "Full range",
+			from: base,
+			to:   base.Add(9 * time.Second),
+			want: []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		},
+		{
+			name: "Sub range",
+			from: base.Add(3 * time.Second),
+			to:   base.Add(5 * time.Second),
+			want: []float64{3, 4, 5},
+		},
+		{
+			name: "Step keeps last sample of each bucket",
+			from: base,
+			to:   base.Add(9 * time.Second),
+			step: 4 * time.Second,
+			want: []float64{3, 7, 9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buf.rangeQuery(tt.from, tt.to, tt.step)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d samples, want %d", len(got), len(tt.want))
+			}
+			for i, s := range got {
+				if s.Value != tt.want[i] {
+					t.Errorf("sample %d: got %v, want %v", i, s.Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRingBuffer_TrimBefore(t *testing.T) {
+	base := time.Unix(1000, 0)
+	buf := newRingBuffer(5)
+	for i := 0; i < 5; i++ {
+		buf.push(models.Sample{TS: base.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	buf.trimBefore(base.Add(3 * time.Second))
+	if buf.size != 2 {
+		t.Errorf("size after trim = %d, want 2", buf.size)
+	}
+}
+
+func TestMemStorage_History(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorageWithHistory(3, time.Hour)
+	for i := 1; i <= 4; i++ {
+		s.AddCounter("hits", 1)
+	}
+	s.AddGauge("temp", 21.5)
+
+	from := time.Now().Add(-time.Minute)
+	to := time.Now().Add(time.Minute)
+
+	samples, err := s.History(ctx, models.Counter, "hits", nil, from, to, 0)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	want := []float64{2, 3, 4}
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(samples), len(want))
+	}
+	for i, sample := range samples {
+		if sample.Value != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, sample.Value, want[i])
+		}
+	}
+
+	if _, err := s.History(ctx, models.Gauge, "missing", nil, from, to, 0); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("expected ErrMetricNotFound, got %v", err)
+	}
+
+	// Без настроенной истории отсчеты не сохраняются
+	plain := NewMemStorage()
+	plain.AddGauge("temp", 1)
+	if _, err := plain.History(ctx, models.Gauge, "temp", nil, from, to, 0); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("expected ErrMetricNotFound for storage without history, got %v", err)
+	}
+}
+
+func TestSamplePartitionName(t *testing.T) {
+	day := time.Date(2026, 10, 17, 23, 30, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	name := samplePartitionName(day)
+	if name != "metric_samples_p20261017" {
+		t.Fatalf("samplePartitionName() = %q, want metric_samples_p20261017", name)
+	}
+
+	parsed, ok := parseSamplePartition(name)
+	if !ok || !parsed.Equal(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("parseSamplePartition(%q) = %v, %v", name, parsed, ok)
+	}
+
+	for _, other := range []string{"metric_samples", "metric_samples_pdefault", "gauge_metrics"} {
+		if _, ok := parseSamplePartition(other); ok {
+			t.Errorf("parseSamplePartition(%q) accepted a foreign table", other)
+		}
+	}
+}
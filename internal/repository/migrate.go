@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Ошибки миграций
+var (
+	ErrSchemaAhead      = errors.New("database schema is newer than this binary") // в базе применены неизвестные бинарнику миграции
+	ErrChecksumMismatch = errors.New("applied migration was modified")            // файл примененной миграции изменился
+	ErrNoDownMigration  = errors.New("migration has no down script")              // откат невозможен
+)
+
+// migrationLockID - ключ advisory lock, чтобы несколько реплик не применяли миграции одновременно
+const migrationLockID = 7_240_001
+
+// Migration - версия схемы: SQL применения и отката
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 скрипта применения
+}
+
+// MigrationStatus - состояние миграции в базе
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // контрольная сумма в базе не совпадает с файлом
+}
+
+// LoadMigrations читает миграции из fsys и возвращает их в порядке версий
+// Файлы называются <версия>_<описание>.up.sql и <версия>_<описание>.down.sql
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q", file)
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", file, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration version %d has two names: %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %06d_%s has no up script", m.Version, m.Name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// appliedMigration - запись таблицы schema_migrations
+type appliedMigration struct {
+	version   int
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator применяет и откатывает миграции, отмечая версии в таблице schema_migrations
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// NewMigrator создает мигратор для миграций из fsys
+func NewMigrator(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// latestVersion возвращает последнюю известную бинарнику версию схемы
+func (m *Migrator) latestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// withLock выполняет fn на отдельном соединении под advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err := conn.Exec(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// applied возвращает примененные миграции по версиям
+func applied(ctx context.Context, conn *pgxpool.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.Query(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		result[a.version] = a
+	}
+	return result, rows.Err()
+}
+
+// checkApplied проверяет, что база не опережает бинарник и примененные миграции не менялись
+func (m *Migrator) checkApplied(done map[int]appliedMigration) error {
+	for version := range done {
+		if version > m.latestVersion() {
+			return fmt.Errorf("%w: database version %d, latest known %d", ErrSchemaAhead, version, m.latestVersion())
+		}
+	}
+	for _, mig := range m.migrations {
+		if a, ok := done[mig.Version]; ok && a.checksum != mig.Checksum {
+			return fmt.Errorf("%w: %06d_%s", ErrChecksumMismatch, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// run выполняет SQL миграции и изменение schema_migrations в одной транзакции
+func run(ctx context.Context, conn *pgxpool.Conn, script, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// Up применяет все неприменные миграции по порядку и возвращает примененные
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var result []Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkApplied(done); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			err := run(ctx, conn, mig.Up,
+				"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+				mig.Version, mig.Name, mig.Checksum)
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %06d_%s: %w", mig.Version, mig.Name, err)
+			}
+			result = append(result, mig)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Down откатывает steps последних примененных миграций и возвращает откаченные
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var result []Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkApplied(done); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(mig.Down) == "" {
+				return fmt.Errorf("%w: %06d_%s", ErrNoDownMigration, mig.Version, mig.Name)
+			}
+			err := run(ctx, conn, mig.Down, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+			if err != nil {
+				return fmt.Errorf("failed to roll back migration %06d_%s: %w", mig.Version, mig.Name, err)
+			}
+			result = append(result, mig)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Status возвращает состояние всех известных миграций
+// Версии, примененные в базе, но неизвестные бинарнику, приводят к ErrSchemaAhead
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			status := MigrationStatus{Migration: mig}
+			if a, ok := done[mig.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = a.appliedAt
+				status.Modified = a.checksum != mig.Checksum
+			}
+			result = append(result, status)
+		}
+
+		for version := range done {
+			if version > m.latestVersion() {
+				return fmt.Errorf("%w: database version %d, latest known %d", ErrSchemaAhead, version, m.latestVersion())
+			}
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tladugin/yaProject.git/migrations"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"000002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+				"000001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+				"000001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+				"000002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+			},
+			versions: []int{1, 2},
+		},
+		{
+			name:    "invalid direction",
+			fsys:    fstest.MapFS{"000001_first.sideways.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			fsys:    fstest.MapFS{"first.up.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: true,
+		},
+		{
+			name:    "down without up",
+			fsys:    fstest.MapFS{"000001_first.down.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: true,
+		},
+		{
+			name: "same version with two names",
+			fsys: fstest.MapFS{
+				"000001_first.up.sql": {Data: []byte("SELECT 1;")},
+				"000001_other.up.sql": {Data: []byte("SELECT 2;")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMigrations(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.versions) {
+				t.Fatalf("got %d migrations, want %d", len(got), len(tt.versions))
+			}
+			for i, m := range got {
+				if m.Version != tt.versions[i] {
+					t.Errorf("migration %d: version %d, want %d", i, m.Version, tt.versions[i])
+				}
+				if m.Checksum == "" || m.Down == "" {
+					t.Errorf("migration %d: checksum or down script is empty", m.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	got, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	for i, m := range got {
+		if m.Version != i+1 {
+			t.Errorf("migration %s: version %d, want %d", m.Name, m.Version, i+1)
+		}
+	}
+}
+
+func TestLoadMigrations_DropsLegacyTable(t *testing.T) {
+	// Таблица migrations прежней версии сервера удаляется после перехода на schema_migrations,
+	// а откат возвращает ее вместе с записью начальной миграции
+	got, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	for _, m := range got {
+		if !strings.Contains(m.Up, "DROP TABLE IF EXISTS migrations;") {
+			continue
+		}
+		if m.Version <= 1 {
+			t.Errorf("legacy table is dropped by migration %d, want after the initial one", m.Version)
+		}
+		if !strings.Contains(m.Down, "CREATE TABLE IF NOT EXISTS migrations") || !strings.Contains(m.Down, "000001_create_metrics_table") {
+			t.Errorf("migration %06d_%s does not restore the legacy table on rollback", m.Version, m.Name)
+		}
+		return
+	}
+	t.Error("no migration drops the legacy migrations table")
+}
+
+func TestMigrator_CheckApplied(t *testing.T) {
+	loaded, err := LoadMigrations(fstest.MapFS{
+		"000001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{migrations: loaded}
+
+	tests := []struct {
+		name string
+		done map[int]appliedMigration
+		want error
+	}{
+		{name: "empty database", done: map[int]appliedMigration{}},
+		{name: "up to date", done: map[int]appliedMigration{1: {version: 1, checksum: loaded[0].Checksum}}},
+		{name: "schema ahead", done: map[int]appliedMigration{
+			1: {version: 1, checksum: loaded[0].Checksum},
+			2: {version: 2},
+		}, want: ErrSchemaAhead},
+		{name: "modified", done: map[int]appliedMigration{1: {version: 1, checksum: "other"}}, want: ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.checkApplied(tt.done)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("checkApplied() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -6,104 +6,609 @@ import (
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tladugin/yaProject.git/internal/models"
+	"github.com/tladugin/yaProject.git/migrations"
 	"log"
-	"os"
 	"time"
 )
 
-// UpdateGauge обновляет или создает метрику типа gauge в PostgreSQL
-func UpdateGauge(pool *pgxpool.Pool, ctx context.Context, name string, value float64) error {
-	_, err := pool.Exec(ctx,
-		`INSERT INTO gauges (name, value)	
-		 VALUES ($1, $2)	
-		 ON CONFLICT (name) DO UPDATE 
-		 SET value = EXCLUDED.value, updated_at = NOW()`,
-		name, value)
-	return err
+// PostgresRepository - реализация MetricStore поверх таблиц gauge_metrics, counter_metrics,
+// histogram_metrics и summary_metrics
+// Репозиторий владеет пулом соединений и закрывает его в Close
+// Временные ошибки (обрыв соединения, конфликт сериализации) повторяются с паузами retryDelays
+type PostgresRepository struct {
+	pool        *pgxpool.Pool
+	staleTTL    time.Duration   // метрика без обновлений дольше staleTTL помечается устаревшей, 0 - никогда
+	retryDelays []time.Duration // паузы перед повторными попытками
 }
 
-// UpdateCounter обновляет или создает метрику типа counter в PostgreSQL
-// При конфликте имени увеличивает существующее значение
-func UpdateCounter(pool *pgxpool.Pool, ctx context.Context, name string, value int64) error {
-	_, err := pool.Exec(ctx,
-		`INSERT INTO counters (name, value) 
-		 VALUES ($1, $2) 
-		 ON CONFLICT (name) DO UPDATE 
-		 SET value = counters.value + EXCLUDED.value, updated_at = NOW()`,
-		name, value)
-	return err
+// NewPostgresRepository подключается к PostgreSQL, применяет миграции и возвращает репозиторий
+// Возвращает ErrSchemaAhead, если схема базы новее бинарника
+func NewPostgresRepository(databaseDSN string, cfg PoolConfig) (*PostgresRepository, error) {
+	pool, ctx, cancel, err := GetConnection(databaseDSN, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if err := applyMigrations(pool, ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	// Без секции на текущий день запись отсчетов истории завершится ошибкой
+	repo := NewPostgresRepositoryWithPool(pool)
+	if err := repo.EnsureSamplePartitions(ctx, time.Now()); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// NewPostgresRepositoryWithPool создает репозиторий поверх готового пула, не применяя миграции
+// Репозиторий становится владельцем пула
+func NewPostgresRepositoryWithPool(pool *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{pool: pool, retryDelays: defaultRetryDelays}
 }
 
-// GetGauge получает значение метрики типа gauge из PostgreSQL по имени
-func GetGauge(pool *pgxpool.Pool, ctx context.Context, name string) (float64, error) {
-	// Проверка входных параметров
-	if pool == nil {
-		return 0, fmt.Errorf("database pool is nil")
+// Close закрывает пул соединений
+func (p *PostgresRepository) Close() {
+	p.pool.Close()
+}
+
+// Ping проверяет доступность базы данных
+func (p *PostgresRepository) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// PoolStats - статистика пула соединений
+type PoolStats struct {
+	AcquiredConns           int32   `json:"acquired_conns"`             // соединения, занятые запросами
+	IdleConns               int32   `json:"idle_conns"`                 // свободные соединения
+	ConstructingConns       int32   `json:"constructing_conns"`         // соединения в процессе установки
+	TotalConns              int32   `json:"total_conns"`                // все соединения пула
+	MaxConns                int32   `json:"max_conns"`                  // предельный размер пула
+	AcquireCount            int64   `json:"acquire_count"`              // выдано соединений
+	AcquireDurationMs       float64 `json:"acquire_duration_ms"`        // суммарное время получения соединений
+	EmptyAcquireCount       int64   `json:"empty_acquire_count"`        // получения с ожиданием свободного соединения
+	CanceledAcquireCount    int64   `json:"canceled_acquire_count"`     // получения, прерванные отменой контекста
+	NewConnsCount           int64   `json:"new_conns_count"`            // открыто соединений
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"` // закрыто по MaxConnLifetime
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`     // закрыто по MaxConnIdleTime
+}
+
+// Stats возвращает статистику пула соединений
+func (p *PostgresRepository) Stats() PoolStats {
+	stat := p.pool.Stat()
+	return PoolStats{
+		AcquiredConns:           stat.AcquiredConns(),
+		IdleConns:               stat.IdleConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		TotalConns:              stat.TotalConns(),
+		MaxConns:                stat.MaxConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       float64(stat.AcquireDuration()) / float64(time.Millisecond),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
 	}
+}
+
+// SetStaleTTL задает срок, после которого не обновлявшаяся метрика помечается устаревшей (Stale)
+func (p *PostgresRepository) SetStaleTTL(ttl time.Duration) {
+	p.staleTTL = ttl
+}
+
+// metricTables - таблицы метрик по типу
+var metricTables = map[string]string{
+	models.Gauge:     "gauge_metrics",
+	models.Counter:   "counter_metrics",
+	models.Histogram: "histogram_metrics",
+	models.Summary:   "summary_metrics",
+}
 
-	if name == "" {
-		return 0, fmt.Errorf("metric name cannot be empty")
+// labelsParam возвращает метки для колонки labels JSONB NOT NULL (nil pgx передает как NULL)
+func labelsParam(labels models.Labels) models.Labels {
+	if labels == nil {
+		return models.Labels{}
 	}
+	return labels
+}
+
+// Get получает метрику из PostgreSQL по типу, имени и меткам
+func (p *PostgresRepository) Get(ctx context.Context, mType, name string, labels models.Labels) (models.Metrics, error) {
+	return retryValue(ctx, p.retryDelays, "get", func() (models.Metrics, error) {
+		return p.get(ctx, mType, name, labels)
+	})
+}
 
-	// Выполнение запроса
-	var value float64
-	err := pool.QueryRow(ctx, `SELECT value FROM gauges WHERE name = $1`, name).Scan(&value)
+// get выполняет одну попытку Get
+func (p *PostgresRepository) get(ctx context.Context, mType, name string, labels models.Labels) (models.Metrics, error) {
+	var (
+		err     error
+		updated time.Time
+	)
+	result := models.Metrics{ID: name, MType: mType, Labels: labels.Clone()}
+	lp := labelsParam(labels)
+
+	switch mType {
+	case models.Gauge:
+		var value float64
+		err = p.pool.QueryRow(ctx, `SELECT value, updated_at FROM gauge_metrics WHERE name = $1 AND labels = $2`, name, lp).Scan(&value, &updated)
+		result.Value = &value
+	case models.Counter:
+		var delta int64
+		err = p.pool.QueryRow(ctx, `SELECT value, updated_at FROM counter_metrics WHERE name = $1 AND labels = $2`, name, lp).Scan(&delta, &updated)
+		result.Delta = &delta
+	case models.Histogram:
+		var h models.HistogramValue
+		h, updated, err = scanHistogram(p.pool.QueryRow(ctx,
+			`SELECT bounds, counts, sum, count, updated_at FROM histogram_metrics WHERE name = $1 AND labels = $2`, name, lp))
+		result.Histogram = &h
+	case models.Summary:
+		var sm models.SummaryValue
+		sm, updated, err = scanSummary(p.pool.QueryRow(ctx,
+			`SELECT quantiles, quantile_values, sum, count, updated_at FROM summary_metrics WHERE name = $1 AND labels = $2`, name, lp))
+		result.Summary = &sm
+	default:
+		return models.Metrics{}, fmt.Errorf("%q: %w", mType, ErrUnknownType)
+	}
 
-	// Обработка ошибок
 	switch {
 	case errors.Is(err, pgx.ErrNoRows):
-		return 0, fmt.Errorf("gauge '%s' not found", name)
+		return models.Metrics{}, fmt.Errorf("%s %q: %w", mType, models.SeriesKey(name, labels), ErrMetricNotFound)
 	case err != nil:
-		return 0, fmt.Errorf("failed to get gauge '%s': %w", name, err)
+		return models.Metrics{}, fmt.Errorf("failed to get %s %q: %w", mType, models.SeriesKey(name, labels), err)
 	}
 
-	return value, nil
+	setUpdatedAt(&result, updated, p.staleTTL, time.Now())
+	return result, nil
+}
+
+// Set обновляет или создает метрику типа gauge
+func (p *PostgresRepository) Set(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return retry(ctx, p.retryDelays, "set", func() error {
+		return p.set(ctx, name, labels, value)
+	})
 }
 
-// GetCounter получает значение метрики типа counter из PostgreSQL по имени
-func GetCounter(pool *pgxpool.Pool, ctx context.Context, name string) (int64, error) {
-	if pool == nil {
-		return 0, fmt.Errorf("database pool is nil")
+// set выполняет одну попытку Set
+func (p *PostgresRepository) set(ctx context.Context, name string, labels models.Labels, value float64) error {
+	_, err := p.pool.Exec(ctx, withSamples(models.Gauge,
+		`INSERT INTO gauge_metrics (name, labels, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`),
+		name, labelsParam(labels), value)
+	if err != nil {
+		return fmt.Errorf("error updating gauge_metrics: %w", err)
 	}
+	return nil
+}
+
+// Add увеличивает значение метрики типа counter, создавая ее при отсутствии
+// Повтор выполняется, только если прошлая попытка точно не применилась (retryIncrement)
+func (p *PostgresRepository) Add(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return retryIncrement(ctx, p.retryDelays, "add", func() error {
+		return p.add(ctx, name, labels, delta)
+	})
+}
 
-	if name == "" {
-		return 0, fmt.Errorf("metric name cannot be empty")
+// add выполняет одну попытку Add
+func (p *PostgresRepository) add(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	_, err := p.pool.Exec(ctx, withSamples(models.Counter,
+		`INSERT INTO counter_metrics (name, labels, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (name, labels) DO UPDATE SET value = counter_metrics.value + EXCLUDED.value, updated_at = now()`),
+		name, labelsParam(labels), delta)
+	if err != nil {
+		return fmt.Errorf("error updating counter_metrics: %w", err)
 	}
+	return nil
+}
 
-	var value int64
-	err := pool.QueryRow(ctx, `SELECT value FROM counters WHERE name = $1`, name).Scan(&value)
+// List возвращает все метрики: gauge, counter, histogram и summary, каждые в порядке имен
+func (p *PostgresRepository) List(ctx context.Context) ([]models.Metrics, error) {
+	return retryValue(ctx, p.retryDelays, "list", func() ([]models.Metrics, error) {
+		return p.list(ctx)
+	})
+}
 
-	switch {
-	case errors.Is(err, pgx.ErrNoRows):
-		return 0, fmt.Errorf("counter '%s' not found", name)
-	case err != nil:
-		return 0, fmt.Errorf("failed to get counter '%s': %w", name, err)
+// list выполняет одну попытку List
+func (p *PostgresRepository) list(ctx context.Context) ([]models.Metrics, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT name, 'gauge', labels, value, NULL::BIGINT, updated_at FROM gauge_metrics
+		 UNION ALL
+		 SELECT name, 'counter', labels, NULL::DOUBLE PRECISION, value, updated_at FROM counter_metrics
+		 ORDER BY 2 DESC, 1, 3`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var result []models.Metrics
+	for rows.Next() {
+		var (
+			m       models.Metrics
+			updated time.Time
+		)
+		if err := rows.Scan(&m.ID, &m.MType, &m.Labels, &m.Value, &m.Delta, &updated); err != nil {
+			return nil, fmt.Errorf("failed to scan metric: %w", err)
+		}
+		m.Labels = m.Labels.Clone()
+		setUpdatedAt(&m, updated, p.staleTTL, now)
+		result = append(result, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+
+	histograms, err := p.listHistograms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := p.listSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, histograms...)
+	return append(result, summaries...), nil
+}
+
+// listHistograms возвращает все метрики типа histogram в порядке имен
+func (p *PostgresRepository) listHistograms(ctx context.Context) ([]models.Metrics, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT name, labels, bounds, counts, sum, count, updated_at FROM histogram_metrics ORDER BY name, labels`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list histograms: %w", err)
+	}
+	defer rows.Close()
+
+	var result []models.Metrics
+	for rows.Next() {
+		var (
+			name    string
+			labels  models.Labels
+			counts  []int64
+			h       models.HistogramValue
+			updated time.Time
+		)
+		if err := rows.Scan(&name, &labels, &h.Bounds, &counts, &h.Sum, &h.Count, &updated); err != nil {
+			return nil, fmt.Errorf("failed to scan histogram: %w", err)
+		}
+		h.Counts = toUint64s(counts)
+		m := models.Metrics{ID: name, MType: models.Histogram, Labels: labels.Clone(), Histogram: &h}
+		setUpdatedAt(&m, updated, p.staleTTL, time.Now())
+		result = append(result, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list histograms: %w", err)
+	}
+	return result, nil
+}
+
+// listSummaries возвращает все метрики типа summary в порядке имен
+func (p *PostgresRepository) listSummaries(ctx context.Context) ([]models.Metrics, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT name, labels, quantiles, quantile_values, sum, count, updated_at FROM summary_metrics ORDER BY name, labels`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list summaries: %w", err)
+	}
+	defer rows.Close()
+
+	var result []models.Metrics
+	for rows.Next() {
+		var (
+			name      string
+			labels    models.Labels
+			quantiles []float64
+			values    []float64
+			sm        models.SummaryValue
+			updated   time.Time
+		)
+		if err := rows.Scan(&name, &labels, &quantiles, &values, &sm.Sum, &sm.Count, &updated); err != nil {
+			return nil, fmt.Errorf("failed to scan summary: %w", err)
+		}
+		sm.Quantiles = joinQuantiles(quantiles, values)
+		m := models.Metrics{ID: name, MType: models.Summary, Labels: labels.Clone(), Summary: &sm}
+		setUpdatedAt(&m, updated, p.staleTTL, time.Now())
+		result = append(result, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list summaries: %w", err)
+	}
+	return result, nil
+}
+
+// scanHistogram читает гистограмму и время обновления из строки (bounds, counts, sum, count, updated_at)
+func scanHistogram(row pgx.Row) (models.HistogramValue, time.Time, error) {
+	var (
+		h       models.HistogramValue
+		counts  []int64
+		updated time.Time
+	)
+	if err := row.Scan(&h.Bounds, &counts, &h.Sum, &h.Count, &updated); err != nil {
+		return models.HistogramValue{}, time.Time{}, err
+	}
+	h.Counts = toUint64s(counts)
+	return h, updated, nil
+}
+
+// scanSummary читает summary и время обновления из строки (quantiles, quantile_values, sum, count, updated_at)
+func scanSummary(row pgx.Row) (models.SummaryValue, time.Time, error) {
+	var (
+		sm        models.SummaryValue
+		quantiles []float64
+		values    []float64
+		updated   time.Time
+	)
+	if err := row.Scan(&quantiles, &values, &sm.Sum, &sm.Count, &updated); err != nil {
+		return models.SummaryValue{}, time.Time{}, err
+	}
+	sm.Quantiles = joinQuantiles(quantiles, values)
+	return sm, updated, nil
+}
+
+// toInt64s преобразует счетчики корзин к типу колонки BIGINT[]
+func toInt64s(values []uint64) []int64 {
+	result := make([]int64, len(values))
+	for i, v := range values {
+		result[i] = int64(v)
+	}
+	return result
+}
+
+// toUint64s преобразует значения колонки BIGINT[] в счетчики корзин
+func toUint64s(values []int64) []uint64 {
+	result := make([]uint64, len(values))
+	for i, v := range values {
+		result[i] = uint64(v)
+	}
+	return result
+}
+
+// splitQuantiles раскладывает квантили summary по двум колонкам-массивам
+func splitQuantiles(quantiles []models.Quantile) ([]float64, []float64) {
+	qs := make([]float64, len(quantiles))
+	values := make([]float64, len(quantiles))
+	for i, q := range quantiles {
+		qs[i] = q.Q
+		values[i] = q.Value
+	}
+	return qs, values
+}
+
+// joinQuantiles собирает квантили summary из двух колонок-массивов
+func joinQuantiles(qs, values []float64) []models.Quantile {
+	result := make([]models.Quantile, 0, len(qs))
+	for i := range qs {
+		if i >= len(values) {
+			break
+		}
+		result = append(result, models.Quantile{Q: qs[i], Value: values[i]})
+	}
+	return result
+}
+
+// Delete удаляет перечисленные метрики в одной транзакции и возвращает количество удаленных
+func (p *PostgresRepository) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	return retryValue(ctx, p.retryDelays, "delete", func() (int, error) {
+		return p.deleteMetrics(ctx, metrics)
+	})
+}
+
+// deleteMetrics выполняет одну попытку Delete
+func (p *PostgresRepository) deleteMetrics(ctx context.Context, metrics []models.Metrics) (int, error) {
+	if err := validateDelete(metrics); err != nil {
+		return 0, err
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	deleted := 0
+	for _, m := range metrics {
+		tag, err := tx.Exec(ctx,
+			"DELETE FROM "+metricTables[m.MType]+" WHERE name = $1 AND labels = $2",
+			m.ID, labelsParam(m.Labels))
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete %s %q: %w", m.MType, m.ID, err)
+		}
+		deleted += int(tag.RowsAffected())
 	}
 
-	return value, nil
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted, nil
+}
+
+// EvictStale удаляет метрики, не обновлявшиеся с момента before (реализация ExpiringStore)
+func (p *PostgresRepository) EvictStale(ctx context.Context, before time.Time) (int, error) {
+	return retryValue(ctx, p.retryDelays, "evict_stale", func() (int, error) {
+		return p.evictStale(ctx, before)
+	})
+}
+
+// evictStale выполняет одну попытку EvictStale
+func (p *PostgresRepository) evictStale(ctx context.Context, before time.Time) (int, error) {
+	evicted := 0
+	for _, table := range []string{"gauge_metrics", "counter_metrics", "histogram_metrics", "summary_metrics"} {
+		tag, err := p.pool.Exec(ctx, "DELETE FROM "+table+" WHERE updated_at < $1", before)
+		if err != nil {
+			return evicted, fmt.Errorf("failed to evict stale metrics from %s: %w", table, err)
+		}
+		evicted += int(tag.RowsAffected())
+	}
+	return evicted, nil
+}
+
+// Backup заменяет содержимое таблиц метрик снимком metrics в одной транзакции
+// Счетчики записываются как есть, без сложения с текущими значениями в базе
+func (p *PostgresRepository) Backup(ctx context.Context, metrics []models.Metrics) error {
+	return retry(ctx, p.retryDelays, "backup", func() error {
+		return p.backup(ctx, metrics)
+	})
+}
+
+// backup выполняет одну попытку Backup
+func (p *PostgresRepository) backup(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "TRUNCATE TABLE gauge_metrics, counter_metrics, histogram_metrics, summary_metrics"); err != nil {
+		return fmt.Errorf("failed to truncate tables: %w", err)
+	}
+	if err := insertMetrics(ctx, tx, metrics); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// importLockID - ключ advisory-блокировки, под которой реплики импортируют бэкап в пустую базу
+const importLockID = 7_305_181_019
+
+// ImportIfEmpty записывает метрики в базу, только если в ней еще нет ни одной метрики.
+// Возвращает false, если база уже заполнена
+func (p *PostgresRepository) ImportIfEmpty(ctx context.Context, metrics []models.Metrics) (bool, error) {
+	return retryValue(ctx, p.retryDelays, "import", func() (bool, error) {
+		return p.importIfEmpty(ctx, metrics)
+	})
+}
+
+func (p *PostgresRepository) importIfEmpty(ctx context.Context, metrics []models.Metrics) (bool, error) {
+	if err := validateBatch(metrics); err != nil {
+		return false, err
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Реплики, запущенные одновременно, импортируют бэкап по очереди: вторая увидит заполненную базу
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", importLockID); err != nil {
+		return false, fmt.Errorf("failed to lock import: %w", err)
+	}
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM gauge_metrics)
+		OR EXISTS (SELECT 1 FROM counter_metrics)
+		OR EXISTS (SELECT 1 FROM histogram_metrics)
+		OR EXISTS (SELECT 1 FROM summary_metrics)`).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check existing metrics: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	if err := insertMetrics(ctx, tx, metrics); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// insertMetrics вставляет метрики с исходным временем обновления
+func insertMetrics(ctx context.Context, tx pgx.Tx, metrics []models.Metrics) error {
+	var err error
+	now := time.Now()
+	for _, m := range metrics {
+		labels := labelsParam(m.Labels)
+		updated := now
+		if m.UpdatedAt != nil {
+			updated = *m.UpdatedAt
+		}
+
+		switch m.MType {
+		case models.Gauge:
+			_, err = tx.Exec(ctx,
+				`INSERT INTO gauge_metrics (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)`,
+				m.ID, labels, *m.Value, updated)
+		case models.Counter:
+			_, err = tx.Exec(ctx,
+				`INSERT INTO counter_metrics (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)`,
+				m.ID, labels, *m.Delta, updated)
+		case models.Histogram:
+			h := m.Histogram
+			bounds := append([]float64{}, h.Bounds...)
+			_, err = tx.Exec(ctx,
+				`INSERT INTO histogram_metrics (name, labels, bounds, counts, sum, count, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				m.ID, labels, bounds, toInt64s(h.Counts), h.Sum, int64(h.Count), updated)
+		case models.Summary:
+			qs, values := splitQuantiles(m.Summary.Quantiles)
+			_, err = tx.Exec(ctx,
+				`INSERT INTO summary_metrics (name, labels, quantiles, quantile_values, sum, count, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				m.ID, labels, qs, values, m.Summary.Sum, int64(m.Summary.Count), updated)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to backup %s %q: %w", m.MType, m.ID, err)
+		}
+	}
+	return nil
+}
+
+// PoolConfig - параметры пула соединений с PostgreSQL
+// Нулевые значения оставляют настройки pgx по умолчанию
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+}
+
+// apply переносит заданные параметры в конфигурацию pgxpool
+func (c PoolConfig) apply(poolConfig *pgxpool.Config) {
+	if c.MaxConns > 0 {
+		poolConfig.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		poolConfig.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = c.HealthCheckPeriod
+	}
 }
 
 // GetConnection создает и настраивает пул соединений с PostgreSQL
-func GetConnection(databaseDSN string) (*pgxpool.Pool, context.Context, context.CancelFunc, error) {
+func GetConnection(databaseDSN string, cfg PoolConfig) (*pgxpool.Pool, context.Context, context.CancelFunc, error) {
 	// Создаем контекст с таймаутом для инициализации подключения
 	initCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	//initCtx := context.Background()
 
 	// Парсим конфигурацию пула соединений
 	poolConfig, err := pgxpool.ParseConfig(databaseDSN)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to parse PostgreSQL DSN: %w", err)
 	}
-
-	/*
-		// Пример настройки параметров пула соединений
-		poolConfig.MinConns = 2
-		poolConfig.MaxConns = 10
-		poolConfig.MaxConnLifetime = 1 * time.Hour
-		poolConfig.MaxConnIdleTime = 30 * time.Minute
-		poolConfig.HealthCheckPeriod = 1 * time.Minute
-	*/
+	cfg.apply(poolConfig)
+	if poolConfig.MinConns > poolConfig.MaxConns {
+		return nil, nil, nil, fmt.Errorf("min conns %d exceeds max conns %d", poolConfig.MinConns, poolConfig.MaxConns)
+	}
 
 	// Создаем пул соединений
 	pool, err := pgxpool.NewWithConfig(initCtx, poolConfig)
@@ -123,104 +628,55 @@ func GetConnection(databaseDSN string) (*pgxpool.Pool, context.Context, context.
 	return pool, ctx, cancelFunc, nil
 }
 
-// applyMigrations применяет миграции базы данных для создания необходимых таблиц
+// applyMigrations применяет встроенные миграции из пакета migrations
+// Возвращает ErrSchemaAhead, если схема базы новее бинарника
 func applyMigrations(db *pgxpool.Pool, ctx context.Context) error {
-	// Проверяем существование таблицы миграций
-	var exists bool
-	err := db.QueryRow(ctx,
-		`SELECT EXISTS (
-			SELECT FROM information_schema.tables 
-			WHERE table_name = 'migrations'
-		)`).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check migrations table: %w", err)
-	}
-
-	if !exists {
-		// Создаем таблицу для отслеживания примененных миграций
-		log.Println("creating migrations table")
-		_, err = db.Exec(ctx,
-			`CREATE TABLE migrations (
-				id SERIAL PRIMARY KEY, 
-				name VARCHAR(255) NOT NULL, 
-				applied_at TIMESTAMP NOT NULL DEFAULT NOW()
-			)`)
-		if err != nil {
-			return fmt.Errorf("failed to create migrations table: %w", err)
-		}
-		log.Println("migrations table created")
-	} else {
-		log.Println("migrations table already exists")
+	migrator, err := NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
 	}
 
-	// Применяем начальную миграцию для создания таблиц метрик
-	var applied bool
-	err = db.QueryRow(ctx,
-		"SELECT EXISTS (SELECT 1 FROM migrations WHERE name = '000001_create_metrics_table')").Scan(&applied)
+	done, err := migrator.Up(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check initial migration: %w", err)
+		return err
 	}
+	for _, m := range done {
+		log.Printf("migration %06d_%s applied", m.Version, m.Name)
+	}
+	return nil
+}
 
-	if !applied {
-		// Проверяем существование файла миграции
-		path := "migrations/000001_create_metrics_table.up.sql"
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return fmt.Errorf("файл миграции не найден: %s", path)
-		}
-
-		// Читаем SQL из файла миграции
-		migrationSQL, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read migration file: %w", err)
-		}
-
-		// Выполняем миграцию в транзакции для обеспечения атомарности
-		tx, err := db.Begin(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to begin transaction: %w", err)
-		}
-		defer func(tx pgx.Tx, ctx context.Context) {
-			err := tx.Rollback(ctx)
-			if err != nil {
-				log.Printf("failed to rollback transaction: %s", err)
-			}
-		}(tx, ctx)
-
-		// Выполняем SQL миграции
-		if _, err := tx.Exec(ctx, string(migrationSQL)); err != nil {
-			return fmt.Errorf("failed to execute migration: %w", err)
-		}
-
-		// Записываем факт применения миграции
-		if _, err := tx.Exec(ctx,
-			"INSERT INTO migrations (name) VALUES ('migrations/000001_create_metrics_table.up.sql')"); err != nil {
-			return fmt.Errorf("failed to record migration: %w", err)
-		}
+// metricsChannel - канал NOTIFY/LISTEN для уведомлений об изменении метрик
+const metricsChannel = "metrics_changed"
 
-		// Фиксируем транзакцию
-		if err := tx.Commit(ctx); err != nil {
-			return fmt.Errorf("failed to commit migration: %w", err)
-		}
+// Notify отправляет уведомление в канал metrics_changed (реализация Notifier)
+func (p *PostgresRepository) Notify(ctx context.Context, payload string) error {
+	if _, err := p.pool.Exec(ctx, "SELECT pg_notify($1, $2)", metricsChannel, payload); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", metricsChannel, err)
 	}
-	log.Println("migration commited")
 	return nil
 }
 
-// NewPostgresRepository создает новый репозиторий для работы с PostgreSQL
-// Инициализирует соединение и применяет необходимые миграции
-func NewPostgresRepository(databaseDSN string) (*pgxpool.Pool, context.Context, error) {
-	// Получаем соединение с базой данных
-	pool, ctx, cancel, err := GetConnection(databaseDSN)
+// Listen подписывается на канал metrics_changed на отдельном соединении (реализация Notifier)
+// Соединение изымается из пула и закрывается при выходе
+func (p *PostgresRepository) Listen(ctx context.Context, ready func(), handle func(payload string)) error {
+	conn, err := p.pool.Acquire(ctx)
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("failed to acquire listen connection: %w", err)
 	}
-	defer cancel()
-	defer pool.Close()
+	pgConn := conn.Hijack()
+	defer pgConn.Close(context.Background())
 
-	// Применяем миграции базы данных
-	if err := applyMigrations(pool, ctx); err != nil {
-		return nil, ctx, fmt.Errorf("failed to apply migrations: %w", err)
+	if _, err := pgConn.Exec(ctx, "LISTEN "+metricsChannel); err != nil {
+		return fmt.Errorf("failed to listen %s: %w", metricsChannel, err)
 	}
+	ready()
 
-	return pool, ctx, nil
+	for {
+		notification, err := pgConn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+		handle(notification.Payload)
+	}
 }
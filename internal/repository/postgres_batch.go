@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Запросы пакетной записи: gauge и counter передаются массивами и разворачиваются через unnest,
+// поэтому число обращений к базе не зависит от размера пачки; каждое обновление
+// gauge и counter также добавляет отсчет в metric_samples
+var (
+	upsertGaugesSQL = withSamples(models.Gauge, `INSERT INTO gauge_metrics (name, labels, value)
+		SELECT name, labels::jsonb, value FROM unnest($1::text[], $2::text[], $3::float8[]) AS t(name, labels, value)
+		ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`)
+
+	upsertCountersSQL = withSamples(models.Counter, `INSERT INTO counter_metrics (name, labels, value)
+		SELECT name, labels::jsonb, value FROM unnest($1::text[], $2::text[], $3::bigint[]) AS t(name, labels, value)
+		ON CONFLICT (name, labels) DO UPDATE SET value = counter_metrics.value + EXCLUDED.value, updated_at = now()`)
+
+	setCountersSQL = withSamples(models.Counter, `INSERT INTO counter_metrics (name, labels, value)
+		SELECT name, labels::jsonb, value FROM unnest($1::text[], $2::text[], $3::bigint[]) AS t(name, labels, value)
+		ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`)
+)
+
+const (
+	upsertHistogramSQL = `INSERT INTO histogram_metrics (name, labels, bounds, counts, sum, count) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name, labels) DO UPDATE SET bounds = EXCLUDED.bounds, counts = EXCLUDED.counts,
+		sum = EXCLUDED.sum, count = EXCLUDED.count, updated_at = now()`
+
+	upsertSummarySQL = `INSERT INTO summary_metrics (name, labels, quantiles, quantile_values, sum, count) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name, labels) DO UPDATE SET quantiles = EXCLUDED.quantiles, quantile_values = EXCLUDED.quantile_values,
+		sum = EXCLUDED.sum, count = EXCLUDED.count, updated_at = now()`
+)
+
+// columns - значения gauge или counter, разложенные по колонкам для unnest
+type columns[T float64 | int64] struct {
+	names  []string
+	labels []string // метки в JSON
+	values []T
+}
+
+// batchRows - пачка обновлений, сведенная к одной записи на серию
+type batchRows struct {
+	gauges     columns[float64]
+	counters   columns[int64]
+	histograms []models.Metrics
+	summaries  []models.Metrics
+	cumulative bool // значения counter устанавливаются, а не прибавляются
+}
+
+// aggregateBatch сводит пачку к одной записи на серию: дельты counter складываются
+// (при cumulative - побеждает последнее накопленное значение, как и для остальных типов)
+// Серии упорядочены по ключу, чтобы параллельные транзакции блокировали строки в одном порядке
+// (иначе возможен deadlock), а ON CONFLICT не встречал одну строку дважды
+func aggregateBatch(metrics []models.Metrics, cumulative bool) (batchRows, error) {
+	type entry struct {
+		key string
+		m   models.Metrics
+	}
+	merged := make(map[string]*entry, len(metrics))
+	for _, m := range metrics {
+		key := m.MType + ":" + models.SeriesKey(m.ID, m.Labels)
+		e, ok := merged[key]
+		if !ok {
+			merged[key] = &entry{key: key, m: m}
+			continue
+		}
+		if m.MType == models.Counter && !cumulative {
+			delta := *e.m.Delta + *m.Delta
+			e.m.Delta = &delta
+		} else {
+			e.m = m
+		}
+	}
+
+	entries := make([]*entry, 0, len(merged))
+	for _, e := range merged {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	rows := batchRows{cumulative: cumulative}
+	for _, e := range entries {
+		m := e.m
+		switch m.MType {
+		case models.Gauge:
+			if err := rows.gauges.add(m, *m.Value); err != nil {
+				return batchRows{}, err
+			}
+		case models.Counter:
+			if err := rows.counters.add(m, *m.Delta); err != nil {
+				return batchRows{}, err
+			}
+		case models.Histogram:
+			rows.histograms = append(rows.histograms, m)
+		case models.Summary:
+			rows.summaries = append(rows.summaries, m)
+		}
+	}
+	return rows, nil
+}
+
+// add добавляет серию и ее значение в колонки
+func (c *columns[T]) add(m models.Metrics, value T) error {
+	labels, err := json.Marshal(labelsParam(m.Labels))
+	if err != nil {
+		return fmt.Errorf("failed to encode labels of %q: %w", m.ID, err)
+	}
+	c.names = append(c.names, m.ID)
+	c.labels = append(c.labels, string(labels))
+	c.values = append(c.values, value)
+	return nil
+}
+
+// queue ставит в пачку pgx все запросы записи
+func (r batchRows) queue(b *pgx.Batch) {
+	if len(r.gauges.names) > 0 {
+		b.Queue(upsertGaugesSQL, r.gauges.names, r.gauges.labels, r.gauges.values)
+	}
+	if len(r.counters.names) > 0 {
+		countersSQL := upsertCountersSQL
+		if r.cumulative {
+			countersSQL = setCountersSQL
+		}
+		b.Queue(countersSQL, r.counters.names, r.counters.labels, r.counters.values)
+	}
+	for _, m := range r.histograms {
+		h := m.Histogram
+		// nil-срез pgx передает как NULL, а колонка bounds объявлена NOT NULL
+		bounds := append([]float64{}, h.Bounds...)
+		b.Queue(upsertHistogramSQL, m.ID, labelsParam(m.Labels), bounds, toInt64s(h.Counts), h.Sum, int64(h.Count))
+	}
+	for _, m := range r.summaries {
+		qs, values := splitQuantiles(m.Summary.Quantiles)
+		b.Queue(upsertSummarySQL, m.ID, labelsParam(m.Labels), qs, values, m.Summary.Sum, int64(m.Summary.Count))
+	}
+}
+
+// UpdateBatch применяет пачку обновлений в одной транзакции
+// Все запросы отправляются одной пачкой pgx, gauge и counter - по одному запросу на тип
+// Пачка с приращениями counter повторяется, только если прошлая попытка точно не применилась
+func (p *PostgresRepository) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	try := retry
+	if hasCounters(metrics) {
+		try = retryIncrement
+	}
+	return try(ctx, p.retryDelays, "update_batch", func() error {
+		return p.updateBatch(ctx, metrics, false)
+	})
+}
+
+// hasCounters проверяет, есть ли в пачке приращения counter
+func hasCounters(metrics []models.Metrics) bool {
+	for _, m := range metrics {
+		if m.MType == models.Counter {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateCumulative применяет пачку как UpdateBatch, но значения counter устанавливаются
+// (реализация CumulativeStore)
+func (p *PostgresRepository) UpdateCumulative(ctx context.Context, metrics []models.Metrics) error {
+	return retry(ctx, p.retryDelays, "update_cumulative", func() error {
+		return p.updateBatch(ctx, metrics, true)
+	})
+}
+
+// updateBatch выполняет одну попытку UpdateBatch или UpdateCumulative
+func (p *PostgresRepository) updateBatch(ctx context.Context, metrics []models.Metrics, cumulative bool) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	rows, err := aggregateBatch(metrics, cumulative)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	b := &pgx.Batch{}
+	rows.queue(b)
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return fmt.Errorf("failed to update metrics batch: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
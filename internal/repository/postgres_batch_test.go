@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+func TestAggregateBatch(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	d := func(v int64) *int64 { return &v }
+
+	metrics := []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: d(2)},
+		{ID: "cpu", MType: models.Gauge, Value: f(1.5)},
+		{ID: "requests", MType: models.Counter, Delta: d(3)},
+		{ID: "requests", MType: models.Counter, Labels: models.Labels{"host": "a"}, Delta: d(7)},
+		{ID: "cpu", MType: models.Gauge, Value: f(2.5)},
+		{ID: "latency", MType: models.Histogram, Histogram: &models.HistogramValue{Sum: 1, Count: 1}},
+		{ID: "latency", MType: models.Histogram, Histogram: &models.HistogramValue{Sum: 3, Count: 2}},
+	}
+
+	rows, err := aggregateBatch(metrics, false)
+	if err != nil {
+		t.Fatalf("aggregateBatch() error = %v", err)
+	}
+
+	wantGauges := columns[float64]{names: []string{"cpu"}, labels: []string{"{}"}, values: []float64{2.5}}
+	if !reflect.DeepEqual(rows.gauges, wantGauges) {
+		t.Errorf("gauges = %+v, want %+v", rows.gauges, wantGauges)
+	}
+
+	wantCounters := columns[int64]{
+		names:  []string{"requests", "requests"},
+		labels: []string{"{}", `{"host":"a"}`},
+		values: []int64{5, 7},
+	}
+	if !reflect.DeepEqual(rows.counters, wantCounters) {
+		t.Errorf("counters = %+v, want %+v", rows.counters, wantCounters)
+	}
+
+	if len(rows.histograms) != 1 || rows.histograms[0].Histogram.Count != 2 {
+		t.Errorf("histograms = %+v, want the last value only", rows.histograms)
+	}
+
+	// Исходная пачка не должна меняться при сложении дельт
+	if *metrics[0].Delta != 2 {
+		t.Errorf("input delta changed to %d", *metrics[0].Delta)
+	}
+}
+
+func TestAggregateBatch_Cumulative(t *testing.T) {
+	d := func(v int64) *int64 { return &v }
+	metrics := []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: d(10)},
+		{ID: "requests", MType: models.Counter, Delta: d(12)},
+	}
+
+	rows, err := aggregateBatch(metrics, true)
+	if err != nil {
+		t.Fatalf("aggregateBatch() error = %v", err)
+	}
+	want := columns[int64]{names: []string{"requests"}, labels: []string{"{}"}, values: []int64{12}}
+	if !reflect.DeepEqual(rows.counters, want) || !rows.cumulative {
+		t.Errorf("counters = %+v (cumulative %v), want the last value %+v", rows.counters, rows.cumulative, want)
+	}
+}
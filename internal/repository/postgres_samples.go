@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+const (
+	// samplePartitionPrefix - префикс имени дневной секции таблицы metric_samples
+	samplePartitionPrefix = "metric_samples_p"
+	// samplePartitionsAhead - сколько секций создается заранее, считая текущий день
+	samplePartitionsAhead = 3
+	// samplePartitionInterval - период проверки секций
+	samplePartitionInterval = time.Hour
+)
+
+// withSamples дополняет upsert метрики записью отсчета в metric_samples в том же запросе
+// upsert должен возвращать name, labels, value и updated_at
+func withSamples(mType, upsert string) string {
+	return `WITH upserted AS (` + upsert + ` RETURNING name, labels, value, updated_at)
+		INSERT INTO metric_samples (type, name, labels, ts, value)
+		SELECT '` + mType + `', name, labels, updated_at, value FROM upserted`
+}
+
+// samplePartitionName возвращает имя секции metric_samples для дня day (UTC)
+func samplePartitionName(day time.Time) string {
+	return samplePartitionPrefix + day.UTC().Format("20060102")
+}
+
+// parseSamplePartition возвращает день секции по ее имени
+func parseSamplePartition(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, samplePartitionPrefix) {
+		return time.Time{}, false
+	}
+	day, err := time.Parse("20060102", strings.TrimPrefix(name, samplePartitionPrefix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// EnsureSamplePartitions создает секции metric_samples на текущий день (UTC) и несколько дней вперед
+func (p *PostgresRepository) EnsureSamplePartitions(ctx context.Context, now time.Time) error {
+	day := now.UTC().Truncate(24 * time.Hour)
+	for i := 0; i < samplePartitionsAhead; i++ {
+		from := day.AddDate(0, 0, i)
+		to := from.AddDate(0, 0, 1)
+		_, err := p.pool.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF metric_samples FOR VALUES FROM ('%s') TO ('%s')`,
+			samplePartitionName(from), from.Format(time.RFC3339), to.Format(time.RFC3339)))
+		if err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", samplePartitionName(from), err)
+		}
+	}
+	return nil
+}
+
+// DropExpiredSamplePartitions удаляет секции metric_samples, все отсчеты которых старше before,
+// и возвращает количество удаленных секций
+func (p *PostgresRepository) DropExpiredSamplePartitions(ctx context.Context, before time.Time) (int, error) {
+	rows, err := p.pool.Query(ctx,
+		`SELECT c.relname FROM pg_inherits i
+		 JOIN pg_class c ON c.oid = i.inhrelid
+		 JOIN pg_class t ON t.oid = i.inhparent
+		 WHERE t.relname = 'metric_samples'`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	partitions, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	dropped := 0
+	for _, name := range partitions {
+		day, ok := parseSamplePartition(name)
+		if !ok || day.AddDate(0, 0, 1).After(before) {
+			continue
+		}
+		if _, err := p.pool.Exec(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return dropped, fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// History возвращает историю значений метрики из metric_samples (реализация HistoryStore)
+func (p *PostgresRepository) History(ctx context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	return retryValue(ctx, p.retryDelays, "history", func() ([]models.Sample, error) {
+		return p.history(ctx, mType, name, labels, from, to, step)
+	})
+}
+
+// history выполняет одну попытку History
+func (p *PostgresRepository) history(ctx context.Context, mType, name string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	if mType != models.Gauge && mType != models.Counter {
+		return nil, fmt.Errorf("%q: %w", mType, ErrUnknownType)
+	}
+	// Для отсутствующей серии возвращаем ErrMetricNotFound, а не пустую историю
+	if _, err := p.get(ctx, mType, name, labels); err != nil {
+		return nil, err
+	}
+
+	rows, err := p.pool.Query(ctx,
+		`SELECT ts, value FROM metric_samples
+		 WHERE type = $1 AND name = $2 AND labels = $3 AND ts BETWEEN $4 AND $5
+		 ORDER BY ts`,
+		mType, name, labelsParam(labels), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history of %s %q: %w", mType, models.SeriesKey(name, labels), err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Sample, 0)
+	for rows.Next() {
+		var sample models.Sample
+		if err := rows.Scan(&sample.TS, &sample.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan sample: %w", err)
+		}
+		result = appendSample(result, sample, from, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query history of %s %q: %w", mType, models.SeriesKey(name, labels), err)
+	}
+	return result, nil
+}
+
+// RunSamplePartitioningWithContext периодически создает секции metric_samples наперед
+// и удаляет секции старше retention (0 - история хранится бессрочно)
+func RunSamplePartitioningWithContext(ctx context.Context, repo *PostgresRepository, retention time.Duration) {
+	ticker := time.NewTicker(samplePartitionInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if err := repo.EnsureSamplePartitions(ctx, now); err != nil {
+			logger.Sugar.Errorw("Failed to create sample partitions", "error", err)
+		}
+		if retention > 0 {
+			dropped, err := repo.DropExpiredSamplePartitions(ctx, now.Add(-retention))
+			if err != nil {
+				logger.Sugar.Errorw("Failed to drop expired sample partitions", "error", err)
+			} else if dropped > 0 {
+				logger.Sugar.Infow("Expired sample partitions dropped", "count", dropped)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Sugar.Info("Sample partitioning stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -14,5 +14,11 @@ func (rs *gauge) Reset() {
 	}
 
 	rs.Name = ""
+	if resetter, ok := interface{}(&rs.Labels).(interface{ Reset() }); ok {
+		resetter.Reset()
+	}
 	rs.Value = 0
+	if resetter, ok := interface{}(&rs.UpdatedAt).(interface{ Reset() }); ok {
+		resetter.Reset()
+	}
 }
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Источники восстановления состояния сервера при запуске
+const (
+	RestoreSourceAuto     = "auto"     // PostgreSQL, если указана база, иначе файл бэкапа
+	RestoreSourceFile     = "file"     // файл бэкапа; в режиме PostgreSQL он заменяет содержимое базы
+	RestoreSourcePostgres = "postgres" // PostgreSQL; пустая база заполняется из файла бэкапа
+)
+
+// ErrEmptyBackup - бэкап без метрик не заменяет содержимое базы без явного подтверждения
+var ErrEmptyBackup = errors.New("backup contains no metrics")
+
+// BackupImporter - хранилище, в которое переносится состояние из файла бэкапа
+type BackupImporter interface {
+	// Backup заменяет содержимое хранилища метриками
+	Backup(ctx context.Context, metrics []models.Metrics) error
+	// ImportIfEmpty записывает метрики, только если хранилище пусто
+	ImportIfEmpty(ctx context.Context, metrics []models.Metrics) (bool, error)
+}
+
+// ResolveRestoreSource проверяет источник восстановления и раскрывает auto
+func ResolveRestoreSource(source string, database bool) (string, error) {
+	switch source {
+	case RestoreSourceAuto:
+		if database {
+			return RestoreSourcePostgres, nil
+		}
+		return RestoreSourceFile, nil
+	case RestoreSourceFile:
+		return source, nil
+	case RestoreSourcePostgres:
+		if !database {
+			return "", fmt.Errorf("restore source %q requires database_dsn", source)
+		}
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown restore source %q, expected auto, file or postgres", source)
+	}
+}
+
+// ImportBackup переносит состояние из файла бэкапа на момент at (нулевое время - последнее) в базу.
+// При replace содержимое базы заменяется, иначе бэкап импортируется только в пустую базу -
+// так состояние файлового режима переносится в PostgreSQL при первом запуске с базой.
+// Замена базы пустым бэкапом возвращает ErrEmptyBackup, если она не подтверждена force.
+// Возвращает количество импортированных метрик
+func ImportBackup(ctx context.Context, db BackupImporter, path string, at time.Time, replace, force bool) (int, error) {
+	storage := NewMemStorage()
+	if err := RestoreFromBackupAt(storage, path, at); err != nil {
+		return 0, err
+	}
+	metrics, err := storage.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list metrics: %w", err)
+	}
+
+	if replace {
+		if len(metrics) == 0 && !force {
+			return 0, ErrEmptyBackup
+		}
+		if err := db.Backup(ctx, metrics); err != nil {
+			return 0, fmt.Errorf("failed to import backup: %w", err)
+		}
+		return len(metrics), nil
+	}
+
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+	imported, err := db.ImportIfEmpty(ctx, metrics)
+	if err != nil {
+		return 0, fmt.Errorf("failed to import backup: %w", err)
+	}
+	if !imported {
+		return 0, nil
+	}
+	return len(metrics), nil
+}
+
+// RestoreFromPostgres заменяет содержимое хранилища в памяти состоянием базы
+func RestoreFromPostgres(ctx context.Context, storage *MemStorage, db MetricStore) (int, error) {
+	metrics, err := db.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list metrics: %w", err)
+	}
+
+	existing, err := storage.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list metrics: %w", err)
+	}
+	storage.Delete(ctx, existing)
+
+	for i := range metrics {
+		m := &metrics[i]
+		if m.MType == models.Counter && m.Delta != nil {
+			ts := time.Now()
+			if m.UpdatedAt != nil {
+				ts = *m.UpdatedAt
+			}
+			storage.setCounter(m.ID, m.Labels, *m.Delta, ts)
+			continue
+		}
+		restoreEvent(storage, m)
+	}
+	logger.Sugar.Infow("State restored from PostgreSQL", "metrics", len(metrics))
+	return len(metrics), nil
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+func TestResolveRestoreSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		database bool
+		want     string
+		wantErr  bool
+	}{
+		{source: "auto", database: true, want: RestoreSourcePostgres},
+		{source: "auto", database: false, want: RestoreSourceFile},
+		{source: "file", database: true, want: RestoreSourceFile},
+		{source: "postgres", database: true, want: RestoreSourcePostgres},
+		{source: "postgres", database: false, wantErr: true},
+		{source: "s3", database: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRestoreSource(tt.source, tt.database)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveRestoreSource(%q, %v) = %q, %v; want %q, error %v", tt.source, tt.database, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// fakeImporter - BackupImporter поверх хранилища в памяти
+type fakeImporter struct {
+	*MemStorage
+}
+
+func (f fakeImporter) Backup(ctx context.Context, metrics []models.Metrics) error {
+	_, err := RestoreFromPostgres(ctx, f.MemStorage, memStorageFrom(metrics))
+	return err
+}
+
+func (f fakeImporter) ImportIfEmpty(ctx context.Context, metrics []models.Metrics) (bool, error) {
+	if existing, _ := f.List(ctx); len(existing) > 0 {
+		return false, nil
+	}
+	return true, f.Backup(ctx, metrics)
+}
+
+// memStorageFrom создает хранилище с метриками metrics
+func memStorageFrom(metrics []models.Metrics) *MemStorage {
+	s := NewMemStorage()
+	s.UpdateBatch(context.Background(), metrics)
+	return s
+}
+
+func TestImportBackup(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	files := NewFileStorage(NewMemStorage(), producer)
+	files.Add(ctx, "hits", nil, 5)
+	files.Set(ctx, "cpu", nil, 0.5)
+
+	tests := []struct {
+		name     string
+		existing bool
+		replace  bool
+		want     int   // импортировано метрик
+		hits     int64 // значение counter в базе после импорта
+	}{
+		{name: "empty database", want: 2, hits: 5},
+		{name: "filled database is kept", existing: true, want: 0, hits: 100},
+		{name: "replace filled database", existing: true, replace: true, want: 2, hits: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakeImporter{NewMemStorage()}
+			if tt.existing {
+				db.Add(ctx, "hits", nil, 100)
+			}
+			imported, err := ImportBackup(ctx, db, path, time.Time{}, tt.replace, false)
+			if err != nil || imported != tt.want {
+				t.Fatalf("ImportBackup = %d, %v; want %d", imported, err, tt.want)
+			}
+			if m, err := db.Get(ctx, models.Counter, "hits", nil); err != nil || *m.Delta != tt.hits {
+				t.Errorf("hits = %v (err %v), want %d", m.Delta, err, tt.hits)
+			}
+		})
+	}
+}
+
+func TestImportBackup_EmptyBackup(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+
+	tests := []struct {
+		name    string
+		replace bool
+		force   bool
+		wantErr error
+		hits    bool // counter остался в базе
+	}{
+		{name: "replace is refused", replace: true, wantErr: ErrEmptyBackup, hits: true},
+		{name: "forced replace clears database", replace: true, force: true},
+		{name: "import into filled database", hits: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakeImporter{NewMemStorage()}
+			db.Add(ctx, "hits", nil, 100)
+
+			imported, err := ImportBackup(ctx, db, path, time.Time{}, tt.replace, tt.force)
+			if !errors.Is(err, tt.wantErr) || imported != 0 {
+				t.Fatalf("ImportBackup = %d, %v; want 0, %v", imported, err, tt.wantErr)
+			}
+			if _, err := db.Get(ctx, models.Counter, "hits", nil); (err == nil) != tt.hits {
+				t.Errorf("hits in database: %v, want %v", err == nil, tt.hits)
+			}
+		})
+	}
+}
+
+func TestRestoreFromPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemStorage()
+	db.Add(ctx, "hits", nil, 7)
+	db.Set(ctx, "cpu", models.Labels{"host": "a"}, 0.5)
+
+	storage := NewMemStorage()
+	storage.Add(ctx, "hits", nil, 100)
+	storage.Set(ctx, "orphan", nil, 1)
+
+	if n, err := RestoreFromPostgres(ctx, storage, db); err != nil || n != 2 {
+		t.Fatalf("RestoreFromPostgres = %d, %v; want 2", n, err)
+	}
+	if m, err := storage.Get(ctx, models.Counter, "hits", nil); err != nil || *m.Delta != 7 {
+		t.Errorf("hits = %v (err %v), want 7 from database", m.Delta, err)
+	}
+	if _, err := storage.Get(ctx, models.Gauge, "cpu", models.Labels{"host": "a"}); err != nil {
+		t.Errorf("labelled gauge not restored: %v", err)
+	}
+	if _, err := storage.Get(ctx, models.Gauge, "orphan", nil); err == nil {
+		t.Error("series absent from database should be removed")
+	}
+}
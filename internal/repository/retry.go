@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/tladugin/yaProject.git/internal/logger"
+)
+
+// defaultRetryDelays - паузы перед повторными попытками операций с PostgreSQL
+var defaultRetryDelays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+
+// isRetriablePgError проверяет, является ли ошибка PostgreSQL временной:
+// исключение соединения (класс 08), конфликт сериализации или взаимная блокировка,
+// а также сетевая ошибка, при которой запрос не был отправлен
+// Нарушения ограничений, синтаксические и прочие ошибки считаются постоянными
+func isRetriablePgError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgErr.Code == pgerrcode.SerializationFailure ||
+			pgErr.Code == pgerrcode.DeadlockDetected
+	}
+
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
+
+// isUncommittedPgError проверяет, что временная ошибка точно не оставила изменений в базе:
+// соединение не было установлено, запрос не был отправлен или сервер отменил транзакцию
+// (конфликт сериализации, взаимная блокировка). После исключения соединения (класс 08)
+// на отправленном запросе COMMIT мог выполниться, поэтому такая ошибка сюда не входит
+func isUncommittedPgError(err error) bool {
+	if !isRetriablePgError(err) {
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && !pgerrcode.IsConnectionException(pgErr.Code)
+}
+
+// pgErrorCode возвращает код ошибки PostgreSQL или пустую строку
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// retry выполняет fn, повторяя ее с паузами delays при временных ошибках PostgreSQL
+// Постоянные ошибки возвращаются сразу (ошибки PostgreSQL - с записью в лог), ожидание прерывается отменой ctx
+func retry(ctx context.Context, delays []time.Duration, op string, fn func() error) error {
+	return retryIf(ctx, delays, op, isRetriablePgError, fn)
+}
+
+// retryIncrement - retry для записей, которые нельзя применять дважды (приращения counter):
+// попытка повторяется, только если предыдущая точно не изменила базу (isUncommittedPgError)
+func retryIncrement(ctx context.Context, delays []time.Duration, op string, fn func() error) error {
+	return retryIf(ctx, delays, op, isUncommittedPgError, fn)
+}
+
+// retryIf выполняет fn, повторяя ее с паузами delays, пока ошибка удовлетворяет retriable
+func retryIf(ctx context.Context, delays []time.Duration, op string, retriable func(error) bool, fn func() error) error {
+	err := fn()
+	for attempt := 0; err != nil && attempt < len(delays) && retriable(err); attempt++ {
+		logger.Sugar.Warnw("Postgres operation failed, retrying",
+			"op", op, "attempt", attempt+1, "delay", delays[attempt], "code", pgErrorCode(err), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delays[attempt]):
+		}
+
+		if err = fn(); err == nil {
+			logger.Sugar.Infow("Postgres operation succeeded after retry", "op", op, "retries", attempt+1)
+		}
+	}
+
+	switch {
+	case err == nil:
+	case retriable(err):
+		logger.Sugar.Errorw("Postgres operation failed after retries",
+			"op", op, "retries", len(delays), "code", pgErrorCode(err), "error", err)
+	case isRetriablePgError(err):
+		logger.Sugar.Errorw("Postgres write failed and may have been committed, not retrying",
+			"op", op, "code", pgErrorCode(err), "error", err)
+	case pgErrorCode(err) != "":
+		// Ошибки приложения (например, ErrMetricNotFound) и отмена не логируются
+		logger.Sugar.Errorw("Postgres operation failed with permanent error, not retrying",
+			"op", op, "code", pgErrorCode(err), "error", err)
+	}
+	return err
+}
+
+// retryValue - retry для операций, возвращающих значение
+func retryValue[T any](ctx context.Context, delays []time.Duration, op string, fn func() (T, error)) (T, error) {
+	var result T
+	err := retry(ctx, delays, op, func() error {
+		var err error
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
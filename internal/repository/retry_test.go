@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs подменяет логгер на время теста и возвращает записанные сообщения
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	prev := logger.Sugar
+	logger.Sugar = zap.New(core).Sugar()
+	t.Cleanup(func() { logger.Sugar = prev })
+	return logs
+}
+
+func TestIsRetriablePgError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection failure", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, want: true},
+		{name: "admin shutdown is permanent", err: &pgconn.PgError{Code: pgerrcode.AdminShutdown}, want: false},
+		{name: "serialization failure", err: &pgconn.PgError{Code: pgerrcode.SerializationFailure}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, want: true},
+		{name: "wrapped connection exception", err: fmt.Errorf("failed to update: %w", &pgconn.PgError{Code: pgerrcode.ConnectionException}), want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, want: false},
+		{name: "undefined table", err: &pgconn.PgError{Code: pgerrcode.UndefinedTable}, want: false},
+		{name: "not found", err: ErrMetricNotFound, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetriablePgError(tt.err); got != tt.want {
+				t.Errorf("isRetriablePgError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsUncommittedPgError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "request not sent", err: fmt.Errorf("failed to update: %w", unsentError{}), want: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: pgerrcode.SerializationFailure}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, want: true},
+		{name: "connection failure after send", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, want: false},
+		{name: "unique violation", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUncommittedPgError(tt.err); got != tt.want {
+				t.Errorf("isUncommittedPgError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryIncrement(t *testing.T) {
+	delays := []time.Duration{0, 0, 0}
+
+	t.Run("retries when the request was not sent", func(t *testing.T) {
+		calls := 0
+		err := retryIncrement(context.Background(), delays, "add", func() error {
+			calls++
+			if calls < 2 {
+				return unsentError{}
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("retryIncrement() = %v after %d calls, want nil after 2", err, calls)
+		}
+	})
+
+	t.Run("does not retry after connection failure", func(t *testing.T) {
+		logs := observeLogs(t)
+		calls := 0
+		lost := &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+		err := retryIncrement(context.Background(), delays, "add", func() error {
+			calls++
+			return lost
+		})
+		if !errors.Is(err, lost) || calls != 1 {
+			t.Errorf("retryIncrement() = %v after %d calls, want connection failure after 1", err, calls)
+		}
+		if n := logs.FilterMessageSnippet("may have been committed").Len(); n != 1 {
+			t.Errorf("got %d log entries about unknown outcome, want 1", n)
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	delays := []time.Duration{0, 0, 0}
+	transient := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		calls := 0
+		err := retry(context.Background(), delays, "test", func() error {
+			calls++
+			if calls < 3 {
+				return transient
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("retry() = %v after %d calls, want nil after 3", err, calls)
+		}
+	})
+
+	t.Run("gives up after all delays", func(t *testing.T) {
+		calls := 0
+		err := retry(context.Background(), delays, "test", func() error {
+			calls++
+			return transient
+		})
+		if !errors.Is(err, transient) || calls != len(delays)+1 {
+			t.Errorf("retry() = %v after %d calls, want transient error after %d", err, calls, len(delays)+1)
+		}
+	})
+
+	t.Run("fails fast on permanent error", func(t *testing.T) {
+		calls := 0
+		permanent := &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+		err := retry(context.Background(), delays, "test", func() error {
+			calls++
+			return permanent
+		})
+		if !errors.Is(err, permanent) || calls != 1 {
+			t.Errorf("retry() = %v after %d calls, want permanent error after 1", err, calls)
+		}
+	})
+
+	t.Run("logs permanent error with code", func(t *testing.T) {
+		logs := observeLogs(t)
+		permanent := fmt.Errorf("failed to update: %w", &pgconn.PgError{Code: pgerrcode.NumericValueOutOfRange})
+		if err := retry(context.Background(), delays, "update_batch", func() error { return permanent }); err == nil {
+			t.Fatal("retry() = nil, want permanent error")
+		}
+
+		entries := logs.FilterMessageSnippet("permanent error").All()
+		if len(entries) != 1 {
+			t.Fatalf("got %d permanent error log entries, want 1", len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["op"] != "update_batch" || fields["code"] != pgerrcode.NumericValueOutOfRange {
+			t.Errorf("log fields = %v, want op=update_batch code=%s", fields, pgerrcode.NumericValueOutOfRange)
+		}
+		if n := logs.FilterMessageSnippet("failed, retrying").Len(); n != 0 {
+			t.Errorf("permanent error logged %d retry attempts, want 0", n)
+		}
+	})
+
+	t.Run("does not log application errors", func(t *testing.T) {
+		logs := observeLogs(t)
+		retry(context.Background(), delays, "get", func() error { return ErrMetricNotFound })
+		if logs.Len() != 0 {
+			t.Errorf("got %d log entries for ErrMetricNotFound, want 0", logs.Len())
+		}
+	})
+
+	t.Run("stops waiting on cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := retry(ctx, []time.Duration{time.Hour}, "test", func() error { return transient })
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("retry() = %v, want context.Canceled", err)
+		}
+	})
+}
@@ -1,87 +1,540 @@
 package repository
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
 )
 
 // Типы метрик
 // generate:reset
 // gauge представляет метрику типа "gauge" (значение произвольное)
 type gauge struct {
-	Name  string
-	Value float64
+	Name      string
+	Labels    models.Labels
+	Value     float64
+	UpdatedAt time.Time
 }
 
 // counter представляет метрику типа "counter" (значение накапливается)
 type counter struct {
-	Name  string
-	Value int64
+	Name      string
+	Labels    models.Labels
+	Value     int64
+	UpdatedAt time.Time
+}
+
+// histogram представляет метрику типа "histogram" (распределение по корзинам)
+type histogram struct {
+	Name      string
+	Labels    models.Labels
+	Value     models.HistogramValue
+	UpdatedAt time.Time
+}
+
+// summary представляет метрику типа "summary" (квантили распределения)
+type summary struct {
+	Name      string
+	Labels    models.Labels
+	Value     models.SummaryValue
+	UpdatedAt time.Time
+}
+
+// seriesID - имя и метки серии, хранимой под ключом models.SeriesKey
+type seriesID struct {
+	name   string
+	labels models.Labels
+}
+
+// less задает порядок серий в снимках: по имени, затем по меткам
+func (id seriesID) less(other seriesID) bool {
+	if id.name != other.name {
+		return id.name < other.name
+	}
+	return id.labels.String() < other.labels.String()
+}
+
+// shardCount - количество сегментов хранилища, степень двойки
+const shardCount = 32
+
+// shard - сегмент хранилища со своей блокировкой и индексами по ключу серии
+type shard struct {
+	mu         sync.RWMutex
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]models.HistogramValue
+	summaries  map[string]models.SummaryValue
+	series     map[string]seriesID    // имя и метки серии по ее ключу
+	updated    map[string]time.Time   // время последнего обновления по ключу historyKey
+	history    map[string]*ringBuffer // история значений по ключу historyKey
+}
+
+// register запоминает имя и метки серии; вызывается под блокировкой сегмента
+func (sh *shard) register(key, name string, labels models.Labels) {
+	if _, ok := sh.series[key]; ok {
+		return
+	}
+	if sh.series == nil {
+		sh.series = make(map[string]seriesID)
+	}
+	sh.series[key] = seriesID{name: name, labels: labels.Clone()}
+}
+
+// touch запоминает время обновления серии; вызывается под блокировкой сегмента
+func (sh *shard) touch(mType, key string, ts time.Time) {
+	if sh.updated == nil {
+		sh.updated = make(map[string]time.Time)
+	}
+	sh.updated[historyKey(mType, key)] = ts
+}
+
+// remove удаляет серию указанного типа вместе с историей; вызывается под блокировкой сегмента
+// Возвращает false, если серии не было
+func (sh *shard) remove(mType, key string) bool {
+	var ok bool
+	switch mType {
+	case models.Gauge:
+		_, ok = sh.gauges[key]
+		delete(sh.gauges, key)
+	case models.Counter:
+		_, ok = sh.counters[key]
+		delete(sh.counters, key)
+	case models.Histogram:
+		_, ok = sh.histograms[key]
+		delete(sh.histograms, key)
+	case models.Summary:
+		_, ok = sh.summaries[key]
+		delete(sh.summaries, key)
+	}
+	if !ok {
+		return false
+	}
+
+	delete(sh.updated, historyKey(mType, key))
+	delete(sh.history, historyKey(mType, key))
+
+	// Имя и метки нужны, пока серия есть хотя бы в одном типе
+	_, g := sh.gauges[key]
+	_, c := sh.counters[key]
+	_, h := sh.histograms[key]
+	_, sm := sh.summaries[key]
+	if !g && !c && !h && !sm {
+		delete(sh.series, key)
+	}
+	return true
 }
 
 // MemStorage - хранилище метрик в памяти
+// Метрики распределены по сегментам по хешу имени, каждый сегмент защищен своим мьютексом,
+// поэтому обновления разных метрик не конкурируют за одну блокировку
+// Все серии одного имени (с разными метками) лежат в одном сегменте
+// Нулевое значение MemStorage готово к использованию, копировать MemStorage нельзя
 type MemStorage struct {
-	counterSlice []counter // Слайс для хранения метрик типа counter
-	gaugeSlice   []gauge   // Слайс для хранения метрик типа gauge
+	shards [shardCount]shard
+
+	historyCapacity  int           // количество хранимых отсчетов на метрику, 0 - история отключена
+	historyRetention time.Duration // максимальный возраст отсчета, 0 - без ограничения
+	staleTTL         time.Duration // метрика без обновлений дольше staleTTL помечается устаревшей, 0 - никогда
+}
+
+// NewMemStorage создает новый экземпляр MemStorage без истории значений
+func NewMemStorage() *MemStorage {
+	return &MemStorage{}
+}
+
+// SetStaleTTL задает срок, после которого не обновлявшаяся метрика помечается устаревшей (Stale)
+// Вызывается до начала работы с хранилищем
+func (s *MemStorage) SetStaleTTL(ttl time.Duration) {
+	s.staleTTL = ttl
+}
+
+// shardFor возвращает сегмент, в котором хранится метрика с указанным именем
+func (s *MemStorage) shardFor(name string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &s.shards[h.Sum32()&(shardCount-1)]
 }
 
-// GaugeSlice возвращает слайс всех метрик типа gauge
+// GaugeSlice возвращает снимок всех метрик типа gauge, отсортированный по имени и меткам
+// Снимок является копией и не меняется при последующих обновлениях хранилища
 func (s *MemStorage) GaugeSlice() []gauge {
-	return s.gaugeSlice
+	result := make([]gauge, 0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, value := range sh.gauges {
+			id := sh.series[key]
+			result = append(result, gauge{
+				Name:      id.name,
+				Labels:    id.labels.Clone(),
+				Value:     value,
+				UpdatedAt: sh.updated[historyKey(models.Gauge, key)],
+			})
+		}
+		sh.mu.RUnlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesID{result[i].Name, result[i].Labels}.less(seriesID{result[j].Name, result[j].Labels})
+	})
+	return result
 }
 
-// CounterSlice возвращает слайс всех метрик типа counter
+// CounterSlice возвращает снимок всех метрик типа counter, отсортированный по имени и меткам
+// Снимок является копией и не меняется при последующих обновлениях хранилища
 func (s *MemStorage) CounterSlice() []counter {
-	return s.counterSlice
+	result := make([]counter, 0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, value := range sh.counters {
+			id := sh.series[key]
+			result = append(result, counter{
+				Name:      id.name,
+				Labels:    id.labels.Clone(),
+				Value:     value,
+				UpdatedAt: sh.updated[historyKey(models.Counter, key)],
+			})
+		}
+		sh.mu.RUnlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesID{result[i].Name, result[i].Labels}.less(seriesID{result[j].Name, result[j].Labels})
+	})
+	return result
 }
 
-// NewMemStorage создает новый экземпляр MemStorage с инициализированными слайсами
-func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		counterSlice: make([]counter, 0), // Инициализация пустого слайса для counter
-		gaugeSlice:   make([]gauge, 0),   // Инициализация пустого слайса для gauge
+// HistogramSlice возвращает снимок всех метрик типа histogram, отсортированный по имени и меткам
+func (s *MemStorage) HistogramSlice() []histogram {
+	result := make([]histogram, 0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, value := range sh.histograms {
+			id := sh.series[key]
+			result = append(result, histogram{
+				Name:      id.name,
+				Labels:    id.labels.Clone(),
+				Value:     value.Clone(),
+				UpdatedAt: sh.updated[historyKey(models.Histogram, key)],
+			})
+		}
+		sh.mu.RUnlock()
 	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesID{result[i].Name, result[i].Labels}.less(seriesID{result[j].Name, result[j].Labels})
+	})
+	return result
 }
 
-// mutex для обеспечения потокобезопасности операций с хранилищем
-var mutex sync.Mutex
+// SummarySlice возвращает снимок всех метрик типа summary, отсортированный по имени и меткам
+func (s *MemStorage) SummarySlice() []summary {
+	result := make([]summary, 0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, value := range sh.summaries {
+			id := sh.series[key]
+			result = append(result, summary{
+				Name:      id.name,
+				Labels:    id.labels.Clone(),
+				Value:     value.Clone(),
+				UpdatedAt: sh.updated[historyKey(models.Summary, key)],
+			})
+		}
+		sh.mu.RUnlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesID{result[i].Name, result[i].Labels}.less(seriesID{result[j].Name, result[j].Labels})
+	})
+	return result
+}
 
-// AddGauge добавляет или обновляет метрику типа gauge
+// AddGauge добавляет или обновляет метрику типа gauge без меток
 // Если метрика с таким именем уже существует - обновляет ее значение
 // Если не существует - добавляет новую метрику
 func (s *MemStorage) AddGauge(name string, value float64) {
-	//fmt.Println(name, value)
-	mutex.Lock()         // Блокируем мьютекс для обеспечения потокобезопасности
-	defer mutex.Unlock() // Гарантируем разблокировку при выходе из функции
-
-	// Поиск существующей метрики по имени
-	for i, m := range s.gaugeSlice {
-		if m.Name == name {
-			// Если метрика найдена - обновляем ее значение
-			s.gaugeSlice[i].Value = value
-			return
-		}
-	}
-
-	// Если метрика не найдена - добавляем новую
-	s.gaugeSlice = append(s.gaugeSlice, gauge{Name: name, Value: value})
+	s.setGauge(name, nil, value, time.Now())
 }
 
-// AddCounter добавляет или обновляет метрику типа counter
+// AddCounter добавляет или обновляет метрику типа counter без меток
 // Если метрика с таким именем уже существует - увеличивает ее значение
 // Если не существует - добавляет новую метрику с переданным значением
 func (s *MemStorage) AddCounter(name string, value int64) {
-	mutex.Lock()         // Блокируем мьютекс для обеспечения потокобезопасности
-	defer mutex.Unlock() // Гарантируем разблокировку при выходе из функции
-
-	// Поиск существующей метрики по имени
-	for i, m := range s.counterSlice {
-		if m.Name == name {
-			// Если метрика найдена - увеличиваем ее значение (накапливаем)
-			s.counterSlice[i].Value += value
-			return
+	s.addCounter(name, nil, value, time.Now())
+}
+
+// SetHistogram сохраняет накопленное значение метрики типа histogram без меток
+// Агенты присылают гистограмму целиком, поэтому новое значение заменяет предыдущее
+func (s *MemStorage) SetHistogram(name string, value models.HistogramValue) {
+	s.setHistogram(name, nil, value, time.Now())
+}
+
+// SetSummary сохраняет значение метрики типа summary без меток, заменяя предыдущее
+func (s *MemStorage) SetSummary(name string, value models.SummaryValue) {
+	s.setSummary(name, nil, value, time.Now())
+}
+
+// setGauge устанавливает значение серии gauge, обновленной в момент ts
+func (s *MemStorage) setGauge(name string, labels models.Labels, value float64, ts time.Time) {
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	if sh.gauges == nil {
+		sh.gauges = make(map[string]float64)
+	}
+	sh.register(key, name, labels)
+	sh.gauges[key] = value
+	sh.touch(models.Gauge, key, ts)
+	s.recordSample(sh, models.Gauge, key, value, ts)
+}
+
+// addCounter увеличивает значение серии counter, обновленной в момент ts
+func (s *MemStorage) addCounter(name string, labels models.Labels, delta int64, ts time.Time) {
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	if sh.counters == nil {
+		sh.counters = make(map[string]int64)
+	}
+	sh.register(key, name, labels)
+	sh.counters[key] += delta
+	sh.touch(models.Counter, key, ts)
+	s.recordSample(sh, models.Counter, key, float64(sh.counters[key]), ts)
+}
+
+// setCounter устанавливает абсолютное значение серии counter, обновленной в момент ts
+// (используется при загрузке состояния из внешнего источника)
+func (s *MemStorage) setCounter(name string, labels models.Labels, value int64, ts time.Time) {
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	if sh.counters == nil {
+		sh.counters = make(map[string]int64)
+	}
+	sh.register(key, name, labels)
+	sh.counters[key] = value
+	sh.touch(models.Counter, key, ts)
+	s.recordSample(sh, models.Counter, key, float64(value), ts)
+}
+
+// setHistogram заменяет значение серии histogram, обновленной в момент ts
+func (s *MemStorage) setHistogram(name string, labels models.Labels, value models.HistogramValue, ts time.Time) {
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	if sh.histograms == nil {
+		sh.histograms = make(map[string]models.HistogramValue)
+	}
+	sh.register(key, name, labels)
+	sh.histograms[key] = value.Clone()
+	sh.touch(models.Histogram, key, ts)
+}
+
+// setSummary заменяет значение серии summary, обновленной в момент ts
+func (s *MemStorage) setSummary(name string, labels models.Labels, value models.SummaryValue, ts time.Time) {
+	sh := s.shardFor(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	key := models.SeriesKey(name, labels)
+	if sh.summaries == nil {
+		sh.summaries = make(map[string]models.SummaryValue)
+	}
+	sh.register(key, name, labels)
+	sh.summaries[key] = value.Clone()
+	sh.touch(models.Summary, key, ts)
+}
+
+// Get возвращает метрику по типу, имени и меткам
+func (s *MemStorage) Get(_ context.Context, mType, name string, labels models.Labels) (models.Metrics, error) {
+	sh := s.shardFor(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	key := models.SeriesKey(name, labels)
+	result := models.Metrics{ID: name, MType: mType, Labels: labels.Clone()}
+	if updated, ok := sh.updated[historyKey(mType, key)]; ok {
+		setUpdatedAt(&result, updated, s.staleTTL, time.Now())
+	}
+	switch mType {
+	case models.Gauge:
+		if value, ok := sh.gauges[key]; ok {
+			result.Value = &value
+			return result, nil
 		}
+	case models.Counter:
+		if delta, ok := sh.counters[key]; ok {
+			result.Delta = &delta
+			return result, nil
+		}
+	case models.Histogram:
+		if value, ok := sh.histograms[key]; ok {
+			h := value.Clone()
+			result.Histogram = &h
+			return result, nil
+		}
+	case models.Summary:
+		if value, ok := sh.summaries[key]; ok {
+			sm := value.Clone()
+			result.Summary = &sm
+			return result, nil
+		}
+	default:
+		return models.Metrics{}, fmt.Errorf("%q: %w", mType, ErrUnknownType)
 	}
 
-	// Если метрика не найдена - добавляем новую
-	s.counterSlice = append(s.counterSlice, counter{Name: name, Value: value})
+	return models.Metrics{}, fmt.Errorf("%s %q: %w", mType, key, ErrMetricNotFound)
+}
+
+// Set устанавливает значение метрики типа gauge (реализация MetricStore)
+func (s *MemStorage) Set(_ context.Context, name string, labels models.Labels, value float64) error {
+	s.setGauge(name, labels, value, time.Now())
+	return nil
+}
+
+// Add увеличивает значение метрики типа counter (реализация MetricStore)
+func (s *MemStorage) Add(_ context.Context, name string, labels models.Labels, delta int64) error {
+	s.addCounter(name, labels, delta, time.Now())
+	return nil
+}
+
+// List возвращает снимок всех метрик хранилища, сгруппированных по типу
+// (gauge, counter, histogram, summary) и отсортированных по имени и меткам
+func (s *MemStorage) List(_ context.Context) ([]models.Metrics, error) {
+	gauges := s.GaugeSlice()
+	counters := s.CounterSlice()
+	histograms := s.HistogramSlice()
+	summaries := s.SummarySlice()
+
+	now := time.Now()
+	result := make([]models.Metrics, 0, len(gauges)+len(counters)+len(histograms)+len(summaries))
+	for i := range gauges {
+		m := models.Metrics{ID: gauges[i].Name, MType: models.Gauge, Labels: gauges[i].Labels, Value: &gauges[i].Value}
+		setUpdatedAt(&m, gauges[i].UpdatedAt, s.staleTTL, now)
+		result = append(result, m)
+	}
+	for i := range counters {
+		m := models.Metrics{ID: counters[i].Name, MType: models.Counter, Labels: counters[i].Labels, Delta: &counters[i].Value}
+		setUpdatedAt(&m, counters[i].UpdatedAt, s.staleTTL, now)
+		result = append(result, m)
+	}
+	for i := range histograms {
+		m := models.Metrics{ID: histograms[i].Name, MType: models.Histogram, Labels: histograms[i].Labels, Histogram: &histograms[i].Value}
+		setUpdatedAt(&m, histograms[i].UpdatedAt, s.staleTTL, now)
+		result = append(result, m)
+	}
+	for i := range summaries {
+		m := models.Metrics{ID: summaries[i].Name, MType: models.Summary, Labels: summaries[i].Labels, Summary: &summaries[i].Value}
+		setUpdatedAt(&m, summaries[i].UpdatedAt, s.staleTTL, now)
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// UpdateBatch применяет пачку обновлений после проверки всех метрик
+func (s *MemStorage) UpdateBatch(_ context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, m := range metrics {
+		s.apply(m, now)
+	}
+	return nil
+}
+
+// UpdateCumulative применяет пачку как UpdateBatch, но значения counter устанавливаются
+// (реализация CumulativeStore)
+func (s *MemStorage) UpdateCumulative(_ context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, m := range metrics {
+		if m.MType == models.Counter {
+			s.setCounter(m.ID, m.Labels, *m.Delta, now)
+			continue
+		}
+		s.apply(m, now)
+	}
+	return nil
+}
+
+// apply применяет одно проверенное обновление, выполненное в момент ts
+func (s *MemStorage) apply(m models.Metrics, ts time.Time) {
+	switch m.MType {
+	case models.Gauge:
+		s.setGauge(m.ID, m.Labels, *m.Value, ts)
+	case models.Counter:
+		s.addCounter(m.ID, m.Labels, *m.Delta, ts)
+	case models.Histogram:
+		s.setHistogram(m.ID, m.Labels, *m.Histogram, ts)
+	case models.Summary:
+		s.setSummary(m.ID, m.Labels, *m.Summary, ts)
+	}
+}
+
+// Delete удаляет перечисленные метрики (тип, имя и метки) и возвращает количество удаленных
+// Отсутствующие метрики пропускаются
+func (s *MemStorage) Delete(_ context.Context, metrics []models.Metrics) (int, error) {
+	if err := validateDelete(metrics); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, m := range metrics {
+		sh := s.shardFor(m.ID)
+		sh.mu.Lock()
+		if sh.remove(m.MType, models.SeriesKey(m.ID, m.Labels)) {
+			deleted++
+		}
+		sh.mu.Unlock()
+	}
+	return deleted, nil
+}
+
+// EvictStale удаляет метрики, не обновлявшиеся с момента before (реализация ExpiringStore)
+func (s *MemStorage) EvictStale(_ context.Context, before time.Time) (int, error) {
+	return len(s.evictStale(before)), nil
+}
+
+// evictStale удаляет устаревшие метрики и возвращает их идентификаторы (тип, имя, метки)
+func (s *MemStorage) evictStale(before time.Time) []models.Metrics {
+	var evicted []models.Metrics
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, id := range sh.series {
+			for _, mType := range []string{models.Gauge, models.Counter, models.Histogram, models.Summary} {
+				updated, ok := sh.updated[historyKey(mType, key)]
+				if !ok || !updated.Before(before) {
+					continue
+				}
+				if sh.remove(mType, key) {
+					evicted = append(evicted, models.Metrics{ID: id.name, MType: mType, Labels: id.labels.Clone()})
+				}
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return evicted
 }
@@ -1,8 +1,16 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
 )
 
 func TestMemStorage_AddCounter(t *testing.T) {
@@ -65,16 +73,18 @@ func TestMemStorage_AddCounter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &MemStorage{
-				counterSlice: tt.initial,
+			s := NewMemStorage()
+			for _, c := range tt.initial {
+				s.AddCounter(c.Name, c.Value)
 			}
 			s.AddCounter(tt.inputName, tt.inputVal)
-			if len(s.counterSlice) != tt.wantLen {
-				t.Errorf("got length %d, want %d", len(s.counterSlice), tt.wantLen)
+			got := s.CounterSlice()
+			if len(got) != tt.wantLen {
+				t.Errorf("got length %d, want %d", len(got), tt.wantLen)
 			}
 
-			// Проверка содержимого слайса
-			for i, c := range s.counterSlice {
+			// Проверка содержимого снимка
+			for i, c := range got {
 				if c.Name != tt.want[i].Name || c.Value != tt.want[i].Value {
 					t.Errorf("at index %d: got {Name: %s, Value: %d}, want {Name: %s, Value: %d}",
 						i, c.Name, c.Value, tt.want[i].Name, tt.want[i].Value)
@@ -142,14 +152,16 @@ func TestMemStorage_AddGauge(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &MemStorage{
-				gaugeSlice: tt.initial,
+			s := NewMemStorage()
+			for _, g := range tt.initial {
+				s.AddGauge(g.Name, g.Value)
 			}
 			s.AddGauge(tt.inputName, tt.inputVal)
-			if len(s.gaugeSlice) != tt.wantLen {
-				t.Errorf("got slice length %d, want %d", len(s.gaugeSlice), tt.wantLen)
+			got := s.GaugeSlice()
+			if len(got) != tt.wantLen {
+				t.Errorf("got slice length %d, want %d", len(got), tt.wantLen)
 			}
-			for i, g := range s.gaugeSlice {
+			for i, g := range got {
 				if g.Name != tt.want[i].Name || g.Value != tt.want[i].Value {
 					t.Errorf("at index %d: got {Name: %s, Value: %f}, want {Name: %s, Value: %f}",
 						i, g.Name, g.Value, tt.want[i].Name, tt.want[i].Value)
@@ -160,24 +172,37 @@ func TestMemStorage_AddGauge(t *testing.T) {
 }
 
 func TestMemStorage_CounterSlice(t *testing.T) {
-	type fields struct {
-		counterSlice []counter
-		gaugeSlice   []gauge
-	}
 	tests := []struct {
-		name   string
-		fields fields
-		want   []counter
+		name    string
+		initial []counter
+		want    []counter
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "Empty storage",
+			initial: nil,
+			want:    []counter{},
+		},
+		{
+			name:    "Snapshot is sorted by name",
+			initial: []counter{{Name: "b", Value: 2}, {Name: "a", Value: 1}},
+			want:    []counter{{Name: "a", Value: 1}, {Name: "b", Value: 2}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &MemStorage{
-				counterSlice: tt.fields.counterSlice,
-				gaugeSlice:   tt.fields.gaugeSlice,
+			s := NewMemStorage()
+			for _, c := range tt.initial {
+				s.AddCounter(c.Name, c.Value)
 			}
-			if got := s.CounterSlice(); !reflect.DeepEqual(got, tt.want) {
+			got := s.CounterSlice()
+			// Время обновления проставляет хранилище, сравниваем его отдельно
+			for i := range got {
+				if got[i].UpdatedAt.IsZero() {
+					t.Errorf("CounterSlice()[%d] has no UpdatedAt", i)
+				}
+				got[i].UpdatedAt = time.Time{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("CounterSlice() = %v, want %v", got, tt.want)
 			}
 		})
@@ -185,24 +210,37 @@ func TestMemStorage_CounterSlice(t *testing.T) {
 }
 
 func TestMemStorage_GaugeSlice(t *testing.T) {
-	type fields struct {
-		counterSlice []counter
-		gaugeSlice   []gauge
-	}
 	tests := []struct {
-		name   string
-		fields fields
-		want   []gauge
+		name    string
+		initial []gauge
+		want    []gauge
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "Empty storage",
+			initial: nil,
+			want:    []gauge{},
+		},
+		{
+			name:    "Snapshot is sorted by name",
+			initial: []gauge{{Name: "b", Value: 2.5}, {Name: "a", Value: 1.5}},
+			want:    []gauge{{Name: "a", Value: 1.5}, {Name: "b", Value: 2.5}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &MemStorage{
-				counterSlice: tt.fields.counterSlice,
-				gaugeSlice:   tt.fields.gaugeSlice,
+			s := NewMemStorage()
+			for _, g := range tt.initial {
+				s.AddGauge(g.Name, g.Value)
+			}
+			got := s.GaugeSlice()
+			// Время обновления проставляет хранилище, сравниваем его отдельно
+			for i := range got {
+				if got[i].UpdatedAt.IsZero() {
+					t.Errorf("GaugeSlice()[%d] has no UpdatedAt", i)
+				}
+				got[i].UpdatedAt = time.Time{}
 			}
-			if got := s.GaugeSlice(); !reflect.DeepEqual(got, tt.want) {
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GaugeSlice() = %v, want %v", got, tt.want)
 			}
 		})
@@ -224,3 +262,356 @@ func TestNewMemStorage(t *testing.T) {
 		})
 	}
 }
+
+func TestMemStorage_GetAndList(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	s.AddGauge("g", 1.5)
+	s.AddCounter("c", 3)
+
+	m, err := s.Get(ctx, models.Gauge, "g", nil)
+	if err != nil || *m.Value != 1.5 {
+		t.Fatalf("Get gauge: got %v, %v", m, err)
+	}
+
+	if _, err := s.Get(ctx, models.Counter, "missing", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("expected ErrMetricNotFound, got %v", err)
+	}
+
+	if _, err := s.Get(ctx, "unknown", "g", nil); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("List returned %d metrics, want 2", len(list))
+	}
+}
+
+func TestFileStorage_WritesEvents(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_file_storage")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	producer, err := NewProducer(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("NewProducer failed: %v", err)
+	}
+
+	ctx := context.Background()
+	fs := NewFileStorage(NewMemStorage(), producer)
+	if err := fs.Set(ctx, "g", nil, 2.5); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := fs.Add(ctx, "c", nil, 4); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	producer.Close()
+
+	restored := NewMemStorage()
+	RestoreFromBackup(restored, tmpfile.Name())
+
+	if m, err := restored.Get(ctx, models.Counter, "c", nil); err != nil || *m.Delta != 4 {
+		t.Errorf("counter not restored from sync backup: %v, %v", m, err)
+	}
+}
+
+func TestMemStorage_SnapshotIsolation(t *testing.T) {
+	s := NewMemStorage()
+	s.AddGauge("g", 1)
+
+	snapshot := s.GaugeSlice()
+	s.AddGauge("g", 2)
+	s.AddGauge("h", 3)
+
+	if len(snapshot) != 1 || snapshot[0].Value != 1 {
+		t.Errorf("snapshot changed after update: %v", snapshot)
+	}
+}
+
+func TestMemStorage_ConcurrentAccess(t *testing.T) {
+	s := NewMemStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.AddCounter("hits", 1)
+				s.AddGauge(fmt.Sprintf("g%d_%d", i, j%10), float64(j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = s.GaugeSlice()
+				_ = s.CounterSlice()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := s.CounterSlice(); len(got) != 1 || got[0].Value != 8000 {
+		t.Errorf("counter = %v, want hits=8000", got)
+	}
+	if got := len(s.GaugeSlice()); got != 80 {
+		t.Errorf("gauge count = %d, want 80", got)
+	}
+}
+
+func TestValidateMetric_Distributions(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  models.Metrics
+		wantErr error
+	}{
+		{
+			name: "Valid histogram",
+			metric: models.Metrics{ID: "h", MType: models.Histogram, Histogram: &models.HistogramValue{
+				Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 2}, Sum: 7, Count: 3,
+			}},
+		},
+		{
+			name:    "Histogram without value",
+			metric:  models.Metrics{ID: "h", MType: models.Histogram},
+			wantErr: ErrMissingValue,
+		},
+		{
+			name: "Histogram with wrong bucket count",
+			metric: models.Metrics{ID: "h", MType: models.Histogram, Histogram: &models.HistogramValue{
+				Bounds: []float64{1, 2}, Counts: []uint64{1, 2}, Count: 3,
+			}},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name: "Histogram with unsorted bounds",
+			metric: models.Metrics{ID: "h", MType: models.Histogram, Histogram: &models.HistogramValue{
+				Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0},
+			}},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name: "Histogram count mismatch",
+			metric: models.Metrics{ID: "h", MType: models.Histogram, Histogram: &models.HistogramValue{
+				Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 5,
+			}},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name: "Valid summary",
+			metric: models.Metrics{ID: "s", MType: models.Summary, Summary: &models.SummaryValue{
+				Quantiles: []models.Quantile{{Q: 0.5, Value: 1}, {Q: 0.99, Value: 3}}, Sum: 10, Count: 4,
+			}},
+		},
+		{
+			name: "Summary quantile out of range",
+			metric: models.Metrics{ID: "s", MType: models.Summary, Summary: &models.SummaryValue{
+				Quantiles: []models.Quantile{{Q: 1.5, Value: 1}},
+			}},
+			wantErr: ErrInvalidValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetric(tt.metric)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMemStorage_Distributions(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+
+	h := models.HistogramValue{Bounds: []float64{1}, Counts: []uint64{2, 1}, Sum: 3.5, Count: 3}
+	sm := models.SummaryValue{Quantiles: []models.Quantile{{Q: 0.5, Value: 1}}, Sum: 3.5, Count: 3}
+	err := s.UpdateBatch(ctx, []models.Metrics{
+		{ID: "latency", MType: models.Histogram, Histogram: &h},
+		{ID: "latency", MType: models.Summary, Summary: &sm},
+	})
+	if err != nil {
+		t.Fatalf("UpdateBatch failed: %v", err)
+	}
+
+	// Изменение исходного значения не должно влиять на сохраненную копию
+	h.Counts[0] = 100
+
+	m, err := s.Get(ctx, models.Histogram, "latency", nil)
+	if err != nil {
+		t.Fatalf("Get histogram failed: %v", err)
+	}
+	if m.Histogram.Counts[0] != 2 || m.Histogram.Count != 3 {
+		t.Errorf("unexpected histogram: %+v", m.Histogram)
+	}
+
+	m, err = s.Get(ctx, models.Summary, "latency", nil)
+	if err != nil || len(m.Summary.Quantiles) != 1 {
+		t.Fatalf("Get summary: got %+v, %v", m.Summary, err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].MType != models.Histogram || list[1].MType != models.Summary {
+		t.Errorf("unexpected list: %+v", list)
+	}
+}
+
+func TestMemStorage_Labels(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+
+	hostA := models.Labels{"host": "a"}
+	hostB := models.Labels{"host": "b"}
+	if err := s.Set(ctx, "CPUutilization1", hostA, 10); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := s.Set(ctx, "CPUutilization1", hostB, 20); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	s.AddGauge("CPUutilization1", 30)
+
+	// Серии с разными метками не перезаписывают друг друга
+	for _, tt := range []struct {
+		labels models.Labels
+		want   float64
+	}{
+		{hostA, 10},
+		{hostB, 20},
+		{nil, 30},
+	} {
+		m, err := s.Get(ctx, models.Gauge, "CPUutilization1", tt.labels)
+		if err != nil {
+			t.Fatalf("Get %v failed: %v", tt.labels, err)
+		}
+		if *m.Value != tt.want {
+			t.Errorf("Get %v = %v, want %v", tt.labels, *m.Value, tt.want)
+		}
+	}
+
+	if _, err := s.Get(ctx, models.Gauge, "CPUutilization1", models.Labels{"host": "c"}); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("expected ErrMetricNotFound, got %v", err)
+	}
+
+	gauges := s.GaugeSlice()
+	if len(gauges) != 3 || gauges[0].Labels != nil || gauges[1].Labels["host"] != "a" {
+		t.Errorf("unexpected snapshot: %+v", gauges)
+	}
+
+	// Имя, совпадающее с записью серии с метками, - отдельная серия
+	if err := s.Set(ctx, `CPUutilization1{host="a"}`, nil, 40); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if m, err := s.Get(ctx, models.Gauge, "CPUutilization1", hostA); err != nil || *m.Value != 10 {
+		t.Errorf("labelled series overwritten by name with labels syntax: %+v, %v", m, err)
+	}
+}
+
+func TestMemStorage_Delete(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorageWithHistory(10, 0)
+	s.AddGauge("g", 1)
+	s.AddCounter("c", 2)
+	s.Set(ctx, "g", models.Labels{"host": "a"}, 3)
+
+	deleted, err := s.Delete(ctx, []models.Metrics{
+		{ID: "g", MType: models.Gauge},
+		{ID: "c", MType: models.Counter},
+		{ID: "missing", MType: models.Gauge},
+	})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Delete returned %d, want 2", deleted)
+	}
+
+	if _, err := s.Get(ctx, models.Gauge, "g", nil); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("expected ErrMetricNotFound after delete, got %v", err)
+	}
+	if _, err := s.Get(ctx, models.Gauge, "g", models.Labels{"host": "a"}); err != nil {
+		t.Errorf("labelled series should survive delete of unlabelled one: %v", err)
+	}
+	if _, err := s.History(ctx, models.Counter, "c", nil, time.Time{}, time.Now(), 0); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("history should be removed with metric, got %v", err)
+	}
+
+	if _, err := s.Delete(ctx, []models.Metrics{{ID: "g", MType: "unknown"}}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestMemStorage_Staleness(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	s.SetStaleTTL(time.Minute)
+
+	old := time.Now().Add(-time.Hour)
+	s.setGauge("old", nil, 1, old)
+	s.AddGauge("fresh", 2)
+
+	m, err := s.Get(ctx, models.Gauge, "old", nil)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !m.Stale || m.UpdatedAt == nil || !m.UpdatedAt.Equal(old) {
+		t.Errorf("old gauge should be stale with updated_at %v: %+v", old, m)
+	}
+	if m, _ := s.Get(ctx, models.Gauge, "fresh", nil); m.Stale || m.UpdatedAt == nil {
+		t.Errorf("fresh gauge should not be stale: %+v", m)
+	}
+
+	evicted, err := s.EvictStale(ctx, time.Now().Add(-time.Minute))
+	if err != nil || evicted != 1 {
+		t.Fatalf("EvictStale = %d, %v, want 1", evicted, err)
+	}
+	list, _ := s.List(ctx)
+	if len(list) != 1 || list[0].ID != "fresh" {
+		t.Errorf("unexpected metrics after eviction: %+v", list)
+	}
+}
+
+func TestFileStorage_DeleteSurvivesRestore(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_file_storage_delete")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	producer, err := NewProducer(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("NewProducer failed: %v", err)
+	}
+
+	ctx := context.Background()
+	fs := NewFileStorage(NewMemStorage(), producer)
+	fs.Set(ctx, "decommissioned", models.Labels{"host": "old"}, 1)
+	fs.Set(ctx, "alive", nil, 2)
+	if _, err := fs.Delete(ctx, []models.Metrics{{ID: "decommissioned", MType: models.Gauge, Labels: models.Labels{"host": "old"}}}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	producer.Close()
+
+	restored := NewMemStorage()
+	RestoreFromBackup(restored, tmpfile.Name())
+
+	gauges := restored.GaugeSlice()
+	if len(gauges) != 1 || gauges[0].Name != "alive" {
+		t.Errorf("deleted metric came back after restore: %+v", gauges)
+	}
+}
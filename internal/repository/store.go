@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Ошибки хранилища метрик
+var (
+	ErrMetricNotFound = errors.New("metric not found")    // метрика с таким именем и типом отсутствует
+	ErrUnknownType    = errors.New("unknown metric type") // тип метрики не поддерживается
+	ErrMissingValue   = errors.New("metric value is required")
+	ErrInvalidValue   = errors.New("invalid metric value") // значение histogram или summary некорректно
+)
+
+// MetricStore описывает хранилище метрик, через которое работают все HTTP обработчики
+// Метрика идентифицируется типом, именем и набором меток (nil - метрика без меток)
+// Реализации: MemStorage (в памяти), FileStorage (в памяти с синхронной записью в файл)
+// и PostgresRepository (PostgreSQL)
+type MetricStore interface {
+	// Get возвращает метрику по типу, имени и меткам либо ErrMetricNotFound
+	Get(ctx context.Context, mType, name string, labels models.Labels) (models.Metrics, error)
+	// Set устанавливает значение метрики типа gauge
+	Set(ctx context.Context, name string, labels models.Labels, value float64) error
+	// Add увеличивает значение метрики типа counter на delta
+	Add(ctx context.Context, name string, labels models.Labels, delta int64) error
+	// List возвращает все метрики хранилища
+	List(ctx context.Context) ([]models.Metrics, error)
+	// UpdateBatch применяет пачку обновлений: counter накапливаются,
+	// gauge, histogram и summary перезаписываются
+	UpdateBatch(ctx context.Context, metrics []models.Metrics) error
+	// Delete удаляет метрики по типу, имени и меткам (значения игнорируются)
+	// и возвращает количество удаленных; отсутствующие метрики пропускаются
+	Delete(ctx context.Context, metrics []models.Metrics) (int, error)
+}
+
+// CumulativeStore описывает хранилище, умеющее записывать накопленные значения counter
+// (например, из Prometheus remote-write): значение counter устанавливается равным Delta, а не
+// увеличивается на него. Повторная запись того же значения ничего не меняет, поэтому повторы
+// запроса и запись одного счетчика через разные реплики не удваивают его
+type CumulativeStore interface {
+	// UpdateCumulative применяет пачку как UpdateBatch, но значения counter устанавливаются
+	UpdateCumulative(ctx context.Context, metrics []models.Metrics) error
+}
+
+// validateMetric проверяет, что метрика имеет известный тип и заполненное значение
+func validateMetric(m models.Metrics) error {
+	switch m.MType {
+	case models.Gauge:
+		if m.Value == nil {
+			return fmt.Errorf("gauge %q: %w", m.ID, ErrMissingValue)
+		}
+	case models.Counter:
+		if m.Delta == nil {
+			return fmt.Errorf("counter %q: %w", m.ID, ErrMissingValue)
+		}
+	case models.Histogram:
+		if m.Histogram == nil {
+			return fmt.Errorf("histogram %q: %w", m.ID, ErrMissingValue)
+		}
+		if err := validateHistogram(*m.Histogram); err != nil {
+			return fmt.Errorf("histogram %q: %w", m.ID, err)
+		}
+	case models.Summary:
+		if m.Summary == nil {
+			return fmt.Errorf("summary %q: %w", m.ID, ErrMissingValue)
+		}
+		if err := validateSummary(*m.Summary); err != nil {
+			return fmt.Errorf("summary %q: %w", m.ID, err)
+		}
+	default:
+		return fmt.Errorf("%q: %w", m.MType, ErrUnknownType)
+	}
+	return nil
+}
+
+// validateHistogram проверяет согласованность границ и счетчиков корзин
+func validateHistogram(h models.HistogramValue) error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d bucket counts, got %d", ErrInvalidValue, len(h.Bounds)+1, len(h.Counts))
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i] <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bucket bounds must be strictly increasing", ErrInvalidValue)
+		}
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("%w: bucket counts sum to %d, count is %d", ErrInvalidValue, total, h.Count)
+	}
+	return nil
+}
+
+// validateSummary проверяет, что квантили лежат в [0, 1] и упорядочены по возрастанию
+func validateSummary(s models.SummaryValue) error {
+	for i, q := range s.Quantiles {
+		if q.Q < 0 || q.Q > 1 {
+			return fmt.Errorf("%w: quantile %v out of range [0, 1]", ErrInvalidValue, q.Q)
+		}
+		if i > 0 && q.Q <= s.Quantiles[i-1].Q {
+			return fmt.Errorf("%w: quantiles must be strictly increasing", ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// validateDelete проверяет идентификаторы удаляемых метрик
+func validateDelete(metrics []models.Metrics) error {
+	for _, m := range metrics {
+		switch m.MType {
+		case models.Gauge, models.Counter, models.Histogram, models.Summary:
+		default:
+			return fmt.Errorf("%q: %w", m.MType, ErrUnknownType)
+		}
+	}
+	return nil
+}
+
+// validateBatch проверяет все метрики пачки до начала записи
+func validateBatch(metrics []models.Metrics) error {
+	for _, m := range metrics {
+		if err := validateMetric(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileStorage - хранилище в памяти, синхронно записывающее каждое обновление в файл бэкапа
+type FileStorage struct {
+	*MemStorage
+	producer *Producer
+}
+
+// NewFileStorage создает хранилище с синхронной записью обновлений через producer
+func NewFileStorage(s *MemStorage, p *Producer) *FileStorage {
+	return &FileStorage{
+		MemStorage: s,
+		producer:   p,
+	}
+}
+
+// Set обновляет gauge в памяти и записывает событие в журнал
+func (f *FileStorage) Set(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return f.producer.Log(func() ([]models.Metrics, error) {
+		if err := f.MemStorage.Set(ctx, name, labels, value); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		return []models.Metrics{{ID: name, MType: models.Gauge, Labels: labels, Value: &value, UpdatedAt: &now}}, nil
+	})
+}
+
+// Add обновляет counter в памяти и записывает событие в журнал
+func (f *FileStorage) Add(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return f.producer.Log(func() ([]models.Metrics, error) {
+		if err := f.MemStorage.Add(ctx, name, labels, delta); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		return []models.Metrics{{ID: name, MType: models.Counter, Labels: labels, Delta: &delta, UpdatedAt: &now}}, nil
+	})
+}
+
+// UpdateBatch применяет пачку обновлений в памяти и записывает их в журнал одним сбросом буфера
+func (f *FileStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	return f.producer.Log(func() ([]models.Metrics, error) {
+		if err := f.MemStorage.UpdateBatch(ctx, metrics); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		events := make([]models.Metrics, len(metrics))
+		for i := range metrics {
+			events[i] = metrics[i]
+			events[i].UpdatedAt = &now
+		}
+		return events, nil
+	})
+}
+
+// UpdateCumulative применяет пачку в памяти и записывает ее в журнал (реализация CumulativeStore)
+// Журнал хранит приращения counter, поэтому для counter записывается разница с текущим значением
+func (f *FileStorage) UpdateCumulative(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateBatch(metrics); err != nil {
+		return err
+	}
+	return f.producer.Log(func() ([]models.Metrics, error) {
+		now := time.Now()
+		events := make([]models.Metrics, len(metrics))
+		values := make(map[string]int64) // значения counter с учетом предыдущих записей пачки
+		for i, m := range metrics {
+			events[i] = m
+			events[i].UpdatedAt = &now
+			if m.MType != models.Counter {
+				continue
+			}
+			key := models.SeriesKey(m.ID, m.Labels)
+			current, ok := values[key]
+			if !ok {
+				stored, err := f.MemStorage.Get(ctx, models.Counter, m.ID, m.Labels)
+				switch {
+				case err == nil:
+					current = *stored.Delta
+				case !errors.Is(err, ErrMetricNotFound):
+					return nil, err
+				}
+			}
+			delta := *m.Delta - current
+			events[i].Delta = &delta
+			values[key] = *m.Delta
+		}
+		if err := f.MemStorage.UpdateCumulative(ctx, metrics); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// tombstones возвращает события удаления метрик (события без значения)
+func tombstones(metrics []models.Metrics) []models.Metrics {
+	now := time.Now()
+	events := make([]models.Metrics, len(metrics))
+	for i, m := range metrics {
+		events[i] = models.Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels, UpdatedAt: &now}
+	}
+	return events
+}
+
+// Delete удаляет метрики из памяти и записывает события удаления в журнал,
+// чтобы метрики не вернулись при восстановлении из бэкапа
+func (f *FileStorage) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	var deleted int
+	err := f.producer.Log(func() ([]models.Metrics, error) {
+		var err error
+		if deleted, err = f.MemStorage.Delete(ctx, metrics); err != nil {
+			return nil, err
+		}
+		return tombstones(metrics), nil
+	})
+	return deleted, err
+}
+
+// EvictStale удаляет устаревшие метрики из памяти и записывает события удаления в журнал
+func (f *FileStorage) EvictStale(_ context.Context, before time.Time) (int, error) {
+	var evicted int
+	err := f.producer.Log(func() ([]models.Metrics, error) {
+		metrics := f.MemStorage.evictStale(before)
+		evicted = len(metrics)
+		return tombstones(metrics), nil
+	})
+	return evicted, err
+}
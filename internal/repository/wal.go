@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// Журнал обновлений (WAL) файлового хранилища: в синхронном режиме каждое обновление
+// дописывается в <файл бэкапа>.wal строкой {"seq":N,"id":...,"type":...,"delta":...}.
+// Снимок (performBackup) хранит в заголовке номер последней учтенной записи, а текущий
+// сегмент журнала переименовывается в <файл бэкапа>.wal.<номер последней записи сегмента>.
+// При восстановлении к снимку применяются только записи с большим номером,
+// поэтому counter не учитываются дважды
+
+// walRecord - запись журнала: событие метрики с порядковым номером
+type walRecord struct {
+	Seq uint64 `json:"seq"`
+	models.Metrics
+}
+
+// walSegment - архивный сегмент журнала и номер его последней записи
+type walSegment struct {
+	path    string
+	lastSeq uint64
+}
+
+// walPath возвращает имя текущего сегмента журнала для файла бэкапа path
+func walPath(path string) string {
+	return path + ".wal"
+}
+
+// OpenWAL открывает журнал обновлений файла бэкапа path для дописывания.
+// Нумерация записей продолжается после последней записи журнала или снимков
+func OpenWAL(path string) (*Producer, error) {
+	seq, err := lastWALSeq(path)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := NewProducer(walPath(path))
+	if err != nil {
+		return nil, err
+	}
+	producer.seq = seq
+	if err := producer.terminateTail(); err != nil {
+		producer.Close()
+		return nil, fmt.Errorf("failed to repair WAL: %w", err)
+	}
+	return producer, nil
+}
+
+// terminateTail завершает переводом строки недописанную при сбое последнюю строку журнала,
+// чтобы новые записи не склеились с ней
+func (p *Producer) terminateTail() error {
+	info, err := p.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	f, err := os.Open(p.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = p.file.Write([]byte{'\n'})
+	return err
+}
+
+// archive переименовывает непустой текущий сегмент журнала в архивный
+// и открывает новый сегмент; вызывается под p.mu
+func (p *Producer) archive() error {
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(p.name, fmt.Sprintf("%s.%020d", p.name, p.seq)); err != nil {
+		return err
+	}
+	if err := p.reopen(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(p.name))
+}
+
+// listWALSegments возвращает архивные сегменты журнала по возрастанию номеров
+func listWALSegments(name string) ([]walSegment, error) {
+	matches, err := filepath.Glob(name + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL segments: %w", err)
+	}
+
+	var segments []walSegment
+	for _, m := range matches {
+		lastSeq, err := strconv.ParseUint(strings.TrimPrefix(m, name+"."), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{path: m, lastSeq: lastSeq})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].lastSeq < segments[j].lastSeq
+	})
+	return segments, nil
+}
+
+// readWAL возвращает записи всех сегментов журнала файла бэкапа path с номером больше after.
+// Поврежденные строки (например, недописанная при сбое последняя) пропускаются с предупреждением
+func readWAL(path string, after uint64) ([]walRecord, error) {
+	name := walPath(path)
+	segments, err := listWALSegments(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []walRecord
+	for _, file := range append(segments, walSegment{path: name}) {
+		if file.lastSeq != 0 && file.lastSeq <= after {
+			continue
+		}
+		segment, err := readWALFile(file.path, after)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, segment...)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+	return records, nil
+}
+
+// readWALFile читает записи одного сегмента журнала с номером больше after
+func readWALFile(path string, after uint64) ([]walRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var records []walRecord
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read WAL: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			record, parseErr := parseWALLine(line)
+			switch {
+			case parseErr != nil:
+				logger.Sugar.Warnw("Skipping corrupt WAL line", "file", path, "line", lineNo, "error", parseErr)
+			case record.Seq > after:
+				records = append(records, record)
+			}
+		}
+		if err != nil {
+			return records, nil
+		}
+	}
+}
+
+// parseWALLine расшифровывает, разбирает и проверяет запись журнала
+func parseWALLine(line []byte) (walRecord, error) {
+	line, err := openLine(line)
+	if err != nil {
+		return walRecord{}, err
+	}
+	var record walRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return walRecord{}, err
+	}
+	if record.Seq == 0 {
+		return walRecord{}, errors.New("missing sequence number")
+	}
+	metric, err := parseBackupRecord(line)
+	if err != nil {
+		return walRecord{}, err
+	}
+	record.Metrics = metric
+	return record, nil
+}
+
+// lastWALSeq возвращает номер последней записи журнала файла бэкапа path
+// с учетом номеров, сохраненных в заголовках снимков
+func lastWALSeq(path string) (uint64, error) {
+	var last uint64
+
+	generations, err := listGenerations(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range generations {
+		last = max(last, g.walSeq)
+	}
+
+	records, err := readWAL(path, last)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) > 0 {
+		last = max(last, records[len(records)-1].Seq)
+	}
+	return last, nil
+}
+
+// pruneWAL удаляет архивные сегменты журнала, полностью покрытые всеми оставшимися снимками
+func pruneWAL(path, name string) error {
+	generations, err := listGenerations(path)
+	if err != nil {
+		return err
+	}
+	if len(generations) == 0 {
+		return nil
+	}
+	covered := generations[0].walSeq
+	for _, g := range generations {
+		covered = min(covered, g.walSeq)
+	}
+
+	segments, err := listWALSegments(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s.lastSeq > covered {
+			break
+		}
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+		logger.Sugar.Debugw("WAL segment removed", "file", s.path)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/models"
+)
+
+// restoredCounter восстанавливает хранилище из бэкапа path и возвращает значение counter
+func restoredCounter(t *testing.T, path, name string) int64 {
+	t.Helper()
+	storage := NewMemStorage()
+	if err := RestoreFromBackup(storage, path); err != nil {
+		t.Fatalf("RestoreFromBackup failed: %v", err)
+	}
+	m, err := storage.Get(context.Background(), models.Counter, name, nil)
+	if err != nil {
+		t.Fatalf("counter %q not restored: %v", name, err)
+	}
+	return *m.Delta
+}
+
+func TestWAL_CountersAreNotDoubleCounted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStorage(NewMemStorage(), producer)
+
+	// Только журнал, снимков еще нет
+	store.Add(ctx, "hits", nil, 2)
+	if got := restoredCounter(t, path, "hits"); got != 2 {
+		t.Errorf("restored from WAL only = %d, want 2", got)
+	}
+
+	// Снимок и хвост журнала после него
+	store.Add(ctx, "hits", nil, 3)
+	if err := performBackup(store.MemStorage, producer, path, RetentionPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	store.Add(ctx, "hits", nil, 10)
+	if got := restoredCounter(t, path, "hits"); got != 15 {
+		t.Errorf("restored snapshot plus WAL tail = %d, want 15", got)
+	}
+
+	// После перезапуска нумерация продолжается, а не начинается заново
+	seq := producer.seq
+	producer.Close()
+	if producer, err = OpenWAL(path); err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	if producer.seq != seq {
+		t.Errorf("reopened WAL seq = %d, want %d", producer.seq, seq)
+	}
+	store = NewFileStorage(NewMemStorage(), producer)
+	if err := RestoreFromBackup(store.MemStorage, path); err != nil {
+		t.Fatal(err)
+	}
+	store.Add(ctx, "hits", nil, 1)
+	if got := restoredCounter(t, path, "hits"); got != 16 {
+		t.Errorf("restored after restart = %d, want 16", got)
+	}
+}
+
+func TestWAL_CumulativeCounters(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	store := NewFileStorage(NewMemStorage(), producer)
+	d := func(v int64) *int64 { return &v }
+
+	store.Add(ctx, "hits", nil, 4)
+	// Журнал хранит приращения: 4 -> 10 -> 10 -> 3 (сброс) и 7 в одной пачке
+	writes := [][]models.Metrics{
+		{{ID: "hits", MType: models.Counter, Delta: d(10)}},
+		{{ID: "hits", MType: models.Counter, Delta: d(10)}},
+		{{ID: "hits", MType: models.Counter, Delta: d(3)}, {ID: "hits", MType: models.Counter, Delta: d(7)}},
+	}
+	for _, w := range writes {
+		if err := store.UpdateCumulative(ctx, w); err != nil {
+			t.Fatalf("UpdateCumulative() error = %v", err)
+		}
+	}
+	m, err := store.Get(ctx, models.Counter, "hits", nil)
+	if err != nil || *m.Delta != 7 {
+		t.Errorf("hits = %+v, %v; want 7", m, err)
+	}
+	if got := restoredCounter(t, path, "hits"); got != 7 {
+		t.Errorf("restored from WAL = %d, want 7", got)
+	}
+}
+
+func TestWAL_CompactedWithGenerations(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	store := NewFileStorage(NewMemStorage(), producer)
+
+	for i := 0; i < 3; i++ {
+		store.Add(ctx, "hits", nil, 1)
+		if err := performBackup(store.MemStorage, producer, path, RetentionPolicy{KeepLast: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Остаются текущий снимок, одно поколение и сегмент журнала между ними
+	segments, err := listWALSegments(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0].lastSeq != 3 {
+		t.Errorf("segments = %+v, want only the segment ending at 3", segments)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 { // снимок, поколение, архивный и текущий сегменты журнала
+		t.Errorf("got %d files in backup directory, want 4", len(entries))
+	}
+	if got := restoredCounter(t, path, "hits"); got != 3 {
+		t.Errorf("restored = %d, want 3", got)
+	}
+}
+
+func TestWAL_TornTailIsTerminated(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup")
+	producer, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewFileStorage(NewMemStorage(), producer).Add(ctx, "hits", nil, 2)
+	producer.Close()
+
+	// Сбой посреди записи оставляет строку без перевода строки
+	f, err := os.OpenFile(walPath(path), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"id":"hits","ty`)
+	f.Close()
+
+	if producer, err = OpenWAL(path); err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	NewFileStorage(NewMemStorage(), producer).Add(ctx, "hits", nil, 3)
+
+	if got := restoredCounter(t, path, "hits"); got != 5 {
+		t.Errorf("restored = %d, want 5", got)
+	}
+}
@@ -34,14 +34,14 @@ type Observer interface {
 // FileObserver записывает события в файл
 type FileObserver struct {
 	file *os.File
-	mu   *sync.Mutex
+	mu   sync.Mutex
 }
 
 // HTTPObserver отправляет события по HTTP
 type HTTPObserver struct {
 	url    string
 	client *http.Client
-	mu     *sync.Mutex
+	mu     sync.Mutex
 }
 
 // AuditManager управляет наблюдателями
@@ -69,7 +69,6 @@ func NewFileObserver(filename string) (*FileObserver, error) {
 
 	return &FileObserver{
 		file: file,
-		mu:   &sync.Mutex{}, // Инициализированный указатель
 	}, nil
 }
 
@@ -78,7 +77,6 @@ func NewHTTPObserver(url string) *HTTPObserver {
 	return &HTTPObserver{
 		url:    url,
 		client: &http.Client{},
-		mu:     &sync.Mutex{}, // Инициализированный указатель
 	}
 }
 
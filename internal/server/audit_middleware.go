@@ -4,7 +4,6 @@ import (
 	"github.com/tladugin/yaProject.git/internal/handler"
 	"github.com/tladugin/yaProject.git/internal/logger"
 	"net/http"
-	"time"
 )
 
 // AuditMiddleware создает middleware для аудита запросов
@@ -39,18 +38,8 @@ func AuditMiddleware(auditManager *AuditManager) func(http.Handler) http.Handler
 					logger.Sugar.Debugf("Audit data retrieved - Metrics: %v, IP: %s", metrics, ip)
 
 					if len(metrics) > 0 { // Отправляем только если есть метрики
-						// Создаем событие аудита
-						event := AuditEvent{
-							TS:        time.Now().Unix(),
-							Metrics:   metrics,
-							IPAddress: ip,
-						}
-
-						logger.Sugar.Infof("Sending audit event: %+v", event)
-
 						// Отправляем событие наблюдателям
-						auditManager.NotifyAll(event)
-						logger.Sugar.Infof("Audit event sent successfully: %d metrics from %s", len(metrics), ip)
+						auditManager.NotifyMetrics(metrics, ip)
 					} else {
 						logger.Sugar.Warn("No metrics found for audit - event will not be sent")
 					}
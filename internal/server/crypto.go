@@ -46,6 +46,11 @@ func LoadPrivateKey(keyPath string) error {
 	return nil
 }
 
+// PrivateKey возвращает загруженный приватный ключ сервера (nil, если ключ не загружен)
+func PrivateKey() *rsa.PrivateKey {
+	return privateKey
+}
+
 // DecryptData расшифровывает данные с помощью приватного ключа
 func DecryptData(encryptedData []byte) ([]byte, error) {
 	if privateKey == nil {
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/tladugin/yaProject.git/internal/handler"
+	"github.com/tladugin/yaProject.git/internal/logger"
+	"github.com/tladugin/yaProject.git/internal/models"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsService - gRPC сервис метрик поверх того же хранилища, подписи и аудита, что и HTTP обработчики
+type MetricsService struct {
+	pb.UnimplementedMetricsServer
+
+	storage      repository.MetricStore
+	key          *string
+	auditManager *AuditManager
+}
+
+// NewMetricsService создает gRPC сервис метрик
+func NewMetricsService(storage repository.MetricStore, key *string, auditManager *AuditManager) *MetricsService {
+	return &MetricsService{
+		storage:      storage,
+		key:          key,
+		auditManager: auditManager,
+	}
+}
+
+// RunGRPCServer запускает gRPC сервер на listener и останавливает его при отмене контекста
+// Listener открывает вызывающий, чтобы ошибка адреса останавливала запуск сервера
+func RunGRPCServer(ctx context.Context, wg *sync.WaitGroup, listener net.Listener, service *MetricsService) {
+	defer wg.Done()
+
+	srv := grpc.NewServer()
+	pb.RegisterMetricsServer(srv, service)
+
+	go func() {
+		<-ctx.Done()
+		logger.Sugar.Info("Shutting down gRPC server...")
+		srv.GracefulStop()
+	}()
+
+	logger.Sugar.Infof("Starting gRPC server on %s", listener.Addr())
+	if err := srv.Serve(listener); err != nil {
+		logger.Sugar.Error("gRPC server failed: ", err)
+	}
+}
+
+// storeError преобразует ошибку хранилища в статус gRPC
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrMetricNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrUnknownType),
+		errors.Is(err, repository.ErrMissingValue),
+		errors.Is(err, repository.ErrInvalidValue):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// peerAddress возвращает адрес клиента для аудита: X-Real-IP из метаданных или адрес соединения
+func peerAddress(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ip := md.Get("x-real-ip"); len(ip) > 0 && ip[0] != "" {
+			return ip[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// audit отправляет событие аудита об обновлении метрик до ответа клиенту,
+// поэтому при остановке сервера события не теряются при закрытии наблюдателей
+func (s *MetricsService) audit(ctx context.Context, metrics []models.Metrics) {
+	if !s.auditManager.IsEnabled() || len(metrics) == 0 {
+		return
+	}
+	names := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, m.ID)
+	}
+	s.auditManager.NotifyMetrics(names, peerAddress(ctx))
+}
+
+// checkHash проверяет подпись пачки, если на сервере задан ключ и пачка подписана
+func (s *MetricsService) checkHash(req *pb.UpdateBatchRequest) error {
+	if req.GetHashSha256() == "" || s.key == nil || *s.key == "" {
+		return nil
+	}
+	payload, err := pb.BatchPayload(req)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if handler.Sign(*s.key, payload) != req.GetHashSha256() {
+		return status.Error(codes.InvalidArgument, "invalid hash")
+	}
+	return nil
+}
+
+// Update обновляет одну метрику
+func (s *MetricsService) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	m := pb.ToModel(req.GetMetric())
+	if m.ID == "" {
+		return nil, status.Error(codes.InvalidArgument, "wrong metric ID")
+	}
+
+	var err error
+	switch m.MType {
+	case models.Gauge:
+		if m.Value == nil {
+			return nil, status.Error(codes.InvalidArgument, "no gauge value")
+		}
+		err = s.storage.Set(ctx, m.ID, m.Labels, *m.Value)
+	case models.Counter:
+		if m.Delta == nil {
+			return nil, status.Error(codes.InvalidArgument, "no counter delta")
+		}
+		err = s.storage.Add(ctx, m.ID, m.Labels, *m.Delta)
+	case models.Histogram, models.Summary:
+		err = s.storage.UpdateBatch(ctx, []models.Metrics{m})
+	default:
+		return nil, status.Error(codes.InvalidArgument, "wrong metric type")
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	s.audit(ctx, []models.Metrics{m})
+	return &pb.UpdateResponse{Metric: req.GetMetric()}, nil
+}
+
+// UpdateBatch обновляет пачку метрик
+func (s *MetricsService) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	if _, err := s.updateBatch(ctx, req); err != nil {
+		return nil, err
+	}
+	return &pb.UpdateBatchResponse{}, nil
+}
+
+// updateBatch проверяет подпись пачки, записывает ее и возвращает количество метрик
+func (s *MetricsService) updateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (int, error) {
+	if err := s.checkHash(req); err != nil {
+		return 0, err
+	}
+	metrics := pb.ToModels(req.GetMetrics())
+	if err := s.storage.UpdateBatch(ctx, metrics); err != nil {
+		return 0, storeError(err)
+	}
+	s.audit(ctx, metrics)
+	return len(metrics), nil
+}
+
+// StreamUpdates принимает поток пачек; пачки записываются по мере получения,
+// первая ошибочная пачка завершает поток
+func (s *MetricsService) StreamUpdates(stream pb.Metrics_StreamUpdatesServer) error {
+	var result pb.StreamUpdatesResponse
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&result)
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err := s.updateBatch(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		result.Batches++
+		result.Metrics += int64(n)
+	}
+}
+
+// GetValue возвращает метрику по типу, имени и меткам
+func (s *MetricsService) GetValue(ctx context.Context, req *pb.GetValueRequest) (*pb.GetValueResponse, error) {
+	var labels models.Labels
+	if len(req.GetLabels()) > 0 {
+		labels = models.Labels(req.GetLabels())
+	}
+	m, err := s.storage.Get(ctx, req.GetType().ModelType(), req.GetId(), labels)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &pb.GetValueResponse{Metric: pb.FromModel(m)}, nil
+}
+
+// List возвращает метрики, подходящие под фильтры
+func (s *MetricsService) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	var matchers []models.LabelMatcher
+	for _, value := range req.GetMatch() {
+		m, err := models.ParseLabelMatcher(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		matchers = append(matchers, m)
+	}
+	mType := req.GetType().ModelType()
+
+	metrics, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var resp pb.ListResponse
+	for _, m := range metrics {
+		if mType != "" && m.MType != mType {
+			continue
+		}
+		if !strings.HasPrefix(m.ID, req.GetPrefix()) || !m.Labels.Matches(matchers) {
+			continue
+		}
+		resp.Metrics = append(resp.Metrics, pb.FromModel(m))
+	}
+	return &resp, nil
+}
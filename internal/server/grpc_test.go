@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/tladugin/yaProject.git/internal/agent"
+	"github.com/tladugin/yaProject.git/internal/handler"
+	"github.com/tladugin/yaProject.git/internal/models"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPC запускает gRPC сервис метрик с ключом key на свободном порту и возвращает его адрес
+func startGRPC(t *testing.T, storage repository.MetricStore, key string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterMetricsServer(srv, NewMetricsService(storage, &key, NewAuditManager(false)))
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+	return listener.Addr().String()
+}
+
+// grpcClient возвращает клиент сервиса метрик по адресу addr
+func grpcClient(t *testing.T, addr string) pb.MetricsClient {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+// signBatch подписывает пачку ключом key
+func signBatch(t *testing.T, req *pb.UpdateBatchRequest, key string) {
+	t.Helper()
+	payload, err := pb.BatchPayload(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.HashSha256 = handler.Sign(key, payload)
+}
+
+func gaugeMetric(id string, value float64, labels models.Labels) *pb.Metric {
+	return pb.FromModel(models.Metrics{ID: id, MType: models.Gauge, Value: &value, Labels: labels})
+}
+
+func counterMetric(id string, delta int64) *pb.Metric {
+	return pb.FromModel(models.Metrics{ID: id, MType: models.Counter, Delta: &delta})
+}
+
+func TestMetricsService_Update(t *testing.T) {
+	client := grpcClient(t, startGRPC(t, repository.NewMemStorage(), ""))
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		metric *pb.Metric
+		code   codes.Code
+	}{
+		{"gauge", gaugeMetric("Alloc", 1.5, nil), codes.OK},
+		{"counter", counterMetric("PollCount", 3), codes.OK},
+		{"empty id", gaugeMetric("", 1, nil), codes.InvalidArgument},
+		{"no value", &pb.Metric{Id: "Alloc", Type: pb.MetricType_METRIC_TYPE_GAUGE}, codes.InvalidArgument},
+		{"unspecified type", &pb.Metric{Id: "Alloc"}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Update(ctx, &pb.UpdateRequest{Metric: tt.metric})
+			if got := status.Code(err); got != tt.code {
+				t.Errorf("code = %v, want %v (%v)", got, tt.code, err)
+			}
+		})
+	}
+
+	resp, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "PollCount", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	if err != nil {
+		t.Fatalf("GetValue: %v", err)
+	}
+	if resp.GetMetric().GetDelta() != 3 {
+		t.Errorf("PollCount = %d, want 3", resp.GetMetric().GetDelta())
+	}
+
+	_, err = client.GetValue(ctx, &pb.GetValueRequest{Id: "Missing", Type: pb.MetricType_METRIC_TYPE_GAUGE})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("missing metric code = %v, want NotFound", status.Code(err))
+	}
+}
+
+func TestMetricsService_UpdateBatchHash(t *testing.T) {
+	const key = "secret"
+	client := grpcClient(t, startGRPC(t, repository.NewMemStorage(), key))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		sign string
+		code codes.Code
+	}{
+		{"valid hash", key, codes.OK},
+		{"wrong key", "other", codes.InvalidArgument},
+		{"unsigned", "", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &pb.UpdateBatchRequest{Metrics: []*pb.Metric{gaugeMetric("Alloc", 1, nil), counterMetric("PollCount", 1)}}
+			if tt.sign != "" {
+				signBatch(t, req, tt.sign)
+			}
+			_, err := client.UpdateBatch(ctx, req)
+			if got := status.Code(err); got != tt.code {
+				t.Errorf("code = %v, want %v (%v)", got, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestMetricsService_List(t *testing.T) {
+	client := grpcClient(t, startGRPC(t, repository.NewMemStorage(), ""))
+	ctx := context.Background()
+
+	_, err := client.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: []*pb.Metric{
+		gaugeMetric("HeapAlloc", 1, models.Labels{"host": "a"}),
+		gaugeMetric("HeapSys", 2, models.Labels{"host": "b"}),
+		gaugeMetric("Sys", 3, nil),
+		counterMetric("PollCount", 1),
+	}})
+	if err != nil {
+		t.Fatalf("UpdateBatch: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.ListRequest
+		want int
+		code codes.Code
+	}{
+		{"all", &pb.ListRequest{}, 4, codes.OK},
+		{"type", &pb.ListRequest{Type: pb.MetricType_METRIC_TYPE_COUNTER}, 1, codes.OK},
+		{"prefix", &pb.ListRequest{Prefix: "Heap"}, 2, codes.OK},
+		{"matcher", &pb.ListRequest{Prefix: "Heap", Match: []string{"host=a"}}, 1, codes.OK},
+		{"bad matcher", &pb.ListRequest{Match: []string{"host"}}, 0, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.List(ctx, tt.req)
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", got, tt.code, err)
+			}
+			if got := len(resp.GetMetrics()); got != tt.want {
+				t.Errorf("len = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsService_StreamUpdates(t *testing.T) {
+	const key = "secret"
+	client := grpcClient(t, startGRPC(t, repository.NewMemStorage(), key))
+
+	stream, err := client.StreamUpdates(context.Background())
+	if err != nil {
+		t.Fatalf("StreamUpdates: %v", err)
+	}
+	batches := []*pb.UpdateBatchRequest{
+		{Metrics: []*pb.Metric{gaugeMetric("Alloc", 1, nil), gaugeMetric("Sys", 2, nil)}},
+		{Metrics: []*pb.Metric{counterMetric("PollCount", 5)}},
+	}
+	for _, req := range batches {
+		signBatch(t, req, key)
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if resp.GetBatches() != 2 || resp.GetMetrics() != 3 {
+		t.Errorf("got %d batches, %d metrics; want 2, 3", resp.GetBatches(), resp.GetMetrics())
+	}
+}
+
+// TestGRPCSender проверяет отправку метрик агентом по gRPC
+func TestGRPCSender(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverKey string
+		agentKey  string
+		wantErr   bool
+	}{
+		{"no key", "", "", false},
+		{"same key", "secret", "secret", false},
+		{"wrong key", "secret", "other", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemStorage()
+			sender, err := agent.NewGRPCSender(startGRPC(t, store, tt.serverKey), tt.agentKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sender.Close()
+
+			source := repository.NewMemStorage()
+			source.AddGauge("Alloc", 42)
+			source.AddCounter("PollCount", 0)
+			err = sender.Send(context.Background(), source, 7, models.Labels{"host": "a"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			ctx := context.Background()
+			m, err := store.Get(ctx, models.Gauge, "Alloc", models.Labels{"host": "a"})
+			if err != nil || m.Value == nil || *m.Value != 42 {
+				t.Errorf("Alloc = %+v, %v; want 42", m, err)
+			}
+			m, err = store.Get(ctx, models.Counter, "PollCount", models.Labels{"host": "a"})
+			if err != nil || m.Delta == nil || *m.Delta != 7 {
+				t.Errorf("PollCount = %+v, %v; want 7", m, err)
+			}
+		})
+	}
+}
+
+// recordingObserver запоминает полученные события аудита
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (o *recordingObserver) Notify(event AuditEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *recordingObserver) Close() error { return nil }
+
+func TestMetricsService_AuditBeforeResponse(t *testing.T) {
+	observer := &recordingObserver{}
+	manager := NewAuditManager(true)
+	manager.AddObserver(observer)
+	key := ""
+	s := NewMetricsService(repository.NewMemStorage(), &key, manager)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-real-ip", "10.0.0.1"))
+	if _, err := s.Update(ctx, &pb.UpdateRequest{Metric: gaugeMetric("Alloc", 1.5, nil)}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	// Close сразу после ответа, как при остановке сервера: событие уже доставлено
+	manager.Close()
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if len(observer.events) != 1 || observer.events[0].IPAddress != "10.0.0.1" || observer.events[0].Metrics[0] != "Alloc" {
+		t.Errorf("audit events = %+v, want one event for Alloc from 10.0.0.1", observer.events)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/tladugin/yaProject.git/internal/logger"
 	"log"
 	"sync"
+	"time"
 )
 
 // initAuditObservers инициализирует наблюдатели на основе конфигурации
@@ -66,6 +67,19 @@ func (m *AuditManager) NotifyAll(event AuditEvent) {
 	wg.Wait()
 }
 
+// NotifyMetrics уведомляет наблюдателей об обновлении метрик клиентом с адресом ip
+func (m *AuditManager) NotifyMetrics(metrics []string, ip string) {
+	event := AuditEvent{
+		TS:        time.Now().Unix(),
+		Metrics:   metrics,
+		IPAddress: ip,
+	}
+
+	logger.Sugar.Infof("Sending audit event: %+v", event)
+	m.NotifyAll(event)
+	logger.Sugar.Infof("Audit event sent successfully: %d metrics from %s", len(metrics), ip)
+}
+
 // Close закрывает всех наблюдателей
 func (m *AuditManager) Close() {
 	m.mu.Lock()
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/snappy"
+	"github.com/tladugin/yaProject.git/internal/handler"
+	pb "github.com/tladugin/yaProject.git/internal/proto"
+	"github.com/tladugin/yaProject.git/internal/repository"
+	"google.golang.org/protobuf/proto"
+)
+
+// openAPISpec - контракт HTTP API
+const openAPISpec = "../../api/openapi.yaml"
+
+// loadOpenAPI загружает и проверяет спецификацию
+func loadOpenAPI(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromFile(openAPISpec)
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI spec: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+	return doc
+}
+
+// testRouter возвращает маршрутизатор сервера поверх хранилища в памяти с ключом подписи key
+func testRouter(storage repository.MetricStore, key string) chi.Router {
+	return newRouter(handler.NewServerWithKey(storage, &key), handler.NewServerPingDB(nil), NewAuditManager(false))
+}
+
+// TestOpenAPI_RoutesDocumented проверяет, что маршруты сервера и операции спецификации совпадают
+func TestOpenAPI_RoutesDocumented(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	registered := make(map[string]bool)
+	err := chi.Walk(testRouter(repository.NewMemStorage(), ""), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// Альтернативные пути с завершающим слешем обрабатываются так же, как основные
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		registered[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var undocumented, missing []string
+	for route := range registered {
+		if !documented[route] {
+			undocumented = append(undocumented, route)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			missing = append(missing, op)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(missing)
+	if len(undocumented) > 0 {
+		t.Errorf("routes missing from %s: %v", openAPISpec, undocumented)
+	}
+	if len(missing) > 0 {
+		t.Errorf("documented operations without route: %v", missing)
+	}
+}
+
+// TestOpenAPI_Conformance проверяет запросы и ответы обработчиков по спецификации
+func TestOpenAPI_Conformance(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Главная страница - HTML, проверяется как строка
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.RegisteredBodyDecoder("text/plain"))
+	defer openapi3filter.UnregisterBodyDecoder("text/html")
+	// Тело remote-write - двоичные данные
+	openapi3filter.RegisterBodyDecoder("application/x-protobuf", openapi3filter.FileBodyDecoder)
+	defer openapi3filter.UnregisterBodyDecoder("application/x-protobuf")
+
+	const key = "secret"
+	sign := func(body string) string {
+		hash := sha256.Sum256(append([]byte(key), body...))
+		return hex.EncodeToString(hash[:])
+	}
+	batch := `[{"id":"Alloc","type":"gauge","value":2},{"id":"PollCount","type":"counter","delta":3}]`
+	write := remoteWriteBody(t, &pb.WriteRequest{Timeseries: []*pb.TimeSeries{{
+		Labels:  []*pb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "job", Value: "api"}},
+		Samples: []*pb.Sample{{Value: 10, Timestamp: 1}},
+	}}})
+	remote := map[string]string{"Content-Encoding": "snappy", "X-Prometheus-Remote-Write-Version": "0.1.0"}
+	signedRemote := map[string]string{"Content-Encoding": "snappy", "HashSHA256": sign(write)}
+
+	// Случаи выполняются по порядку на общем хранилище
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		headers     map[string]string
+		gzip        bool   // тело сжимается, проверяются только заголовки запроса
+		contentType string // тип не-JSON тела
+		wantStatus  int
+	}{
+		{name: "Update gauge", method: http.MethodPost, url: "/update", body: `{"id":"Alloc","type":"gauge","value":1.5}`, wantStatus: http.StatusOK},
+		{name: "Update counter", method: http.MethodPost, url: "/update", body: `{"id":"PollCount","type":"counter","delta":1}`, wantStatus: http.StatusOK},
+		{name: "Update histogram", method: http.MethodPost, url: "/update", body: `{"id":"latency","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}}`, wantStatus: http.StatusOK},
+		{name: "Update unknown type", method: http.MethodPost, url: "/update", body: `{"id":"x","type":"unknown"}`, wantStatus: http.StatusNotAcceptable},
+		{name: "Update by URL", method: http.MethodPost, url: "/update/gauge/Sys/7?match=host=a", wantStatus: http.StatusOK},
+		{name: "Update by URL invalid value", method: http.MethodPost, url: "/update/counter/PollCount/abc", wantStatus: http.StatusBadRequest},
+		{name: "Updates signed", method: http.MethodPost, url: "/updates", body: batch, headers: map[string]string{"HashSHA256": sign(batch)}, wantStatus: http.StatusOK},
+		{name: "Updates signed gzip", method: http.MethodPost, url: "/updates", body: batch, gzip: true, headers: map[string]string{"HashSHA256": sign(batch)}, wantStatus: http.StatusOK},
+		{name: "Updates wrong signature", method: http.MethodPost, url: "/updates", body: batch, headers: map[string]string{"HashSHA256": sign("other")}, wantStatus: http.StatusBadRequest},
+		{name: "Remote write", method: http.MethodPost, url: "/api/v1/write", body: write, contentType: "application/x-protobuf", headers: remote, wantStatus: http.StatusNoContent},
+		{name: "Remote write signed", method: http.MethodPost, url: "/api/v1/write", body: write, contentType: "application/x-protobuf", headers: signedRemote, wantStatus: http.StatusNoContent},
+		{name: "Remote write not snappy", method: http.MethodPost, url: "/api/v1/write", body: "plain", contentType: "application/x-protobuf", headers: remote, wantStatus: http.StatusBadRequest},
+		{name: "Value gauge", method: http.MethodPost, url: "/value", body: `{"id":"Alloc","type":"gauge"}`, wantStatus: http.StatusOK},
+		{name: "Value not found", method: http.MethodPost, url: "/value", body: `{"id":"missing","type":"gauge"}`, wantStatus: http.StatusNotFound},
+		{name: "Get gauge", method: http.MethodGet, url: "/value/gauge/Alloc", wantStatus: http.StatusOK},
+		{name: "Get histogram", method: http.MethodGet, url: "/value/histogram/latency", wantStatus: http.StatusOK},
+		{name: "Get labelled series", method: http.MethodGet, url: "/value/gauge/Sys?match=host=a", wantStatus: http.StatusOK},
+		{name: "Get not found", method: http.MethodGet, url: "/value/counter/missing", wantStatus: http.StatusNotFound},
+		{name: "List values", method: http.MethodGet, url: "/values?sort=value&order=desc&limit=2", wantStatus: http.StatusOK},
+		{name: "Count values", method: http.MethodGet, url: "/values?type=gauge&count=true", wantStatus: http.StatusOK},
+		{name: "History not recorded", method: http.MethodGet, url: "/history/gauge/Alloc", wantStatus: http.StatusNotFound},
+		{name: "Prometheus metrics", method: http.MethodGet, url: "/metrics", wantStatus: http.StatusOK},
+		{name: "Main page", method: http.MethodGet, url: "/", wantStatus: http.StatusOK},
+		{name: "Ping without database", method: http.MethodGet, url: "/ping", wantStatus: http.StatusInternalServerError},
+		{name: "Delete", method: http.MethodDelete, url: "/value/gauge/Alloc", wantStatus: http.StatusOK},
+		{name: "Delete batch", method: http.MethodPost, url: "/delete", body: `[{"id":"PollCount","type":"counter"}]`, wantStatus: http.StatusOK},
+	}
+
+	srv := testRouter(repository.NewMemStorage(), key)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(tt.body)
+			if tt.gzip {
+				buf, err := repository.CompressData(body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = buf.Bytes()
+			}
+			newRequest := func() *http.Request {
+				req := httptest.NewRequest(tt.method, tt.url, bytes.NewReader(body))
+				switch {
+				case tt.contentType != "":
+					req.Header.Set("Content-Type", tt.contentType)
+				case tt.body != "":
+					req.Header.Set("Content-Type", "application/json")
+				}
+				if tt.gzip {
+					req.Header.Set("Content-Encoding", "gzip")
+				}
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				return req
+			}
+
+			req := newRequest()
+			route, pathParams, err := router.FindRoute(req)
+			if err != nil {
+				t.Fatalf("request is not documented: %v", err)
+			}
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+				Options: &openapi3filter.Options{
+					ExcludeRequestBody:    tt.gzip,
+					IncludeResponseStatus: true,
+				},
+			}
+			if err := openapi3filter.ValidateRequest(context.Background(), input); err != nil {
+				t.Fatalf("request does not match spec: %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, newRequest())
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+
+			err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 rr.Code,
+				Header:                 rr.Header(),
+				Body:                   io.NopCloser(bytes.NewReader(rr.Body.Bytes())),
+				Options:                input.Options,
+			})
+			if err != nil {
+				t.Errorf("response does not match spec: %v", err)
+			}
+		})
+	}
+}
+
+// remoteWriteBody возвращает тело запроса remote-write: WriteRequest, сжатый snappy
+func remoteWriteBody(t *testing.T, req *pb.WriteRequest) string {
+	t.Helper()
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(snappy.Encode(nil, data))
+}
@@ -2,14 +2,17 @@ package server
 
 import (
 	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/tladugin/yaProject.git/internal/handler"
 	"github.com/tladugin/yaProject.git/internal/logger"
 	"github.com/tladugin/yaProject.git/internal/repository"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // Глобальные переменные для информации о сборке
@@ -19,18 +22,41 @@ var (
 	buildCommit  string
 )
 
+// Config - параметры HTTP сервера, собранные из конфигурации в main
+type Config struct {
+	Address       string // адрес HTTP сервера
+	GRPCAddress   string // адрес gRPC сервера, пустой - gRPC отключен
+	StoreInterval int    // период бэкапа в секундах, 0 - синхронная запись в файл
+	StoreFile     string // файл бэкапа
+	DatabaseDSN   string // строка подключения к PostgreSQL, пустая - хранение в памяти и файле
+	Key           string // ключ подписи HashSHA256
+	AuditFile     string // файл аудита
+	AuditURL      string // URL получателя аудита
+
+	MetricTTL  time.Duration // время без обновлений, после которого метрика устаревает
+	EvictStale bool          // удалять устаревшие метрики вместо пометки
+
+	GroupCommitSize    int                   // размер группы коммита, 0 - без групп
+	GroupCommitWait    time.Duration         // ожидание группы коммита
+	SamplesRetention   time.Duration         // срок хранения истории в PostgreSQL
+	CacheFlushInterval time.Duration         // период записи кэша в PostgreSQL, 0 - без кэша
+	CacheFlushSize     int                   // размер очереди кэша, при котором запись начинается раньше
+	Pool               repository.PoolConfig // параметры пула соединений с PostgreSQL
+
+	RestoreSource string    // источник восстановления, пустой - без восстановления
+	RestorePoint  time.Time // момент восстановления, нулевой - последний бэкап
+	RestoreForce  bool      // разрешить замену базы пустым бэкапом
+
+	DebugMux *http.ServeMux // отладочные маршруты (listener pprof), nil - /debug/db не публикуется
+}
+
 // RunHTTPServer запускает HTTP сервер для работы с метриками
 func RunHTTPServer(
 	storage *repository.MemStorage,
 	producer *repository.Producer,
 	ctx context.Context, // ИЗМЕНЕНО: контекст вместо канала
 	wg *sync.WaitGroup,
-	flagStoreInterval int,
-	flagRunAddr *string,
-	flagDatabaseDSN *string,
-	flagKey *string,
-	flagAuditFile *string,
-	flagAuditURL *string,
+	cfg Config,
 ) {
 	defer wg.Done()
 
@@ -39,87 +65,129 @@ func RunHTTPServer(
 	defer auditManager.Close()
 
 	// Инициализируем наблюдатели аудита (файловый и/или HTTP)
-	initAuditObservers(auditManager, flagAuditFile, flagAuditURL)
-
-	// Создаем обработчики для разных режимов работы
-	s := handler.NewServer(storage)                   // Базовый обработчик
-	sSync := handler.NewServerSync(storage, producer) // Синхронный обработчик с бэкапом
+	initAuditObservers(auditManager, &cfg.AuditFile, &cfg.AuditURL)
 
-	var db *handler.ServerDB     // Обработчик для работы с БД
-	var ping *handler.ServerPing // Обработчик для проверки соединения
+	// Выбор реализации хранилища в зависимости от конфигурации
+	var store repository.MetricStore = storage // Асинхронный бэкап (по расписанию)
+	synchronous := true                        // каждое обновление пишется в базу или файл до ответа
+	var db *repository.PostgresRepository      // общий пул для хранилища, /ping и /debug/db
 
 	// Инициализация работы с PostgreSQL если указан DSN
-	if *flagDatabaseDSN != "" {
-		// Проверка и применение миграций базы данных
-		p, _, err := repository.NewPostgresRepository(*flagDatabaseDSN)
+	if cfg.DatabaseDSN != "" {
+		// Подключение и применение миграций базы данных
+		// Сервер не запускается, если схема базы новее бинарника или миграции не применились
+		pgStore, err := repository.NewPostgresRepository(cfg.DatabaseDSN, cfg.Pool)
 		if err != nil {
-			logger.Sugar.Error("Failed to initialize storage: %v", err.Error())
+			logger.Sugar.Fatalw("Failed to initialize storage", "error", err)
 		}
-		defer p.Close()
+		defer pgStore.Close()
+		db = pgStore
 
-		// Установка соединения с базой данных
-		pool, _, _, err := repository.GetConnection(*flagDatabaseDSN)
-		if err != nil {
-			logger.Sugar.Error("Failed to get connection!: %v", err.Error())
+		logger.Sugar.Info("Running with PostgreSQL storage")
+		pgStore.SetStaleTTL(cfg.MetricTTL)
+		store = pgStore
+
+		// Секции истории metric_samples: создание наперед и удаление устаревших
+		go repository.RunSamplePartitioningWithContext(ctx, pgStore, cfg.SamplesRetention)
+
+		// Восстановление: файл бэкапа переносится в базу (в пустую или с заменой для источника file);
+		// память кэша заполняется состоянием базы при Warm
+		if cfg.RestoreSource != "" {
+			replace := cfg.RestoreSource == repository.RestoreSourceFile
+			imported, err := repository.ImportBackup(ctx, pgStore, cfg.StoreFile, cfg.RestorePoint, replace, cfg.RestoreForce)
+			switch {
+			case errors.Is(err, repository.ErrEmptyBackup):
+				logger.Sugar.Fatalw("Refusing to replace database with empty backup, use --restore_force to allow it", "file", cfg.StoreFile)
+			case err != nil && replace:
+				logger.Sugar.Fatalw("Failed to restore from backup", "error", err)
+			case err != nil:
+				logger.Sugar.Errorw("Failed to import backup into PostgreSQL", "error", err)
+			case imported > 0:
+				logger.Sugar.Infow("Backup imported into PostgreSQL", "file", cfg.StoreFile, "metrics", imported, "replace", replace)
+			}
 		}
-		defer pool.Close()
 
-		// Создание обработчиков для работы с БД
-		ping = handler.NewServerPingDB(storage, flagDatabaseDSN) // Обработчик проверки доступности БД
-		db = handler.NewServerDB(storage, pool, flagKey)         // Основной обработчик операций с БД
+		// Кэш в памяти перед базой: чтение из памяти, запись в базу отложенная
+		if cfg.CacheFlushInterval > 0 {
+			cached := repository.NewCachedStore(storage, pgStore, cfg.CacheFlushInterval, cfg.CacheFlushSize)
+			if err := cached.Warm(ctx); err != nil {
+				logger.Sugar.Fatalw("Failed to initialize storage", "error", err)
+			}
+
+			// Согласование кэшей реплик через NOTIFY/LISTEN
+			if err := cached.EnableNotifications(pgStore); err != nil {
+				logger.Sugar.Fatalw("Failed to initialize storage", "error", err)
+			}
+			go cached.Listen(ctx)
+			logger.Sugar.Infow("PostgreSQL cache enabled", "flush_interval", cfg.CacheFlushInterval, "flush_size", cfg.CacheFlushSize)
+
+			cacheStopped := make(chan struct{})
+			go func() {
+				cached.Run(ctx)
+				close(cacheStopped)
+			}()
+			// Остаток очереди записывается после остановки HTTP сервера и до закрытия пула
+			defer func() {
+				<-cacheStopped
+				if err := cached.Flush(context.Background()); err != nil {
+					logger.Sugar.Errorw("Final cache flush failed", "error", err)
+				}
+			}()
+
+			store = cached
+			synchronous = false
+		}
+	} else if cfg.StoreInterval == 0 {
+		logger.Sugar.Info("Running in sync backup mode")
+		store = repository.NewFileStorage(storage, producer) // Синхронный бэкап после каждого обновления
+	} else {
+		logger.Sugar.Info("Running in async backup mode")
+		synchronous = false
 	}
 
-	// Настройка маршрутизатора
-	r := chi.NewRouter()
+	// Удаление метрик, не обновлявшихся дольше TTL
+	if cfg.EvictStale && cfg.MetricTTL > 0 {
+		if expiring, ok := store.(repository.ExpiringStore); ok {
+			logger.Sugar.Infow("Stale metrics eviction enabled", "ttl", cfg.MetricTTL)
+			go repository.RunEvictionWithContext(ctx, expiring, cfg.MetricTTL)
+		}
+	}
 
-	// Регистрация middleware компонентов
-	r.Use(
-		DecryptMiddleware,                   // Расшифровывание запросов
-		repository.GzipMiddleware,           // Сжатие ответов
-		logger.LoggingAnswer(logger.Sugar),  // Логирование ответов
-		logger.LoggingRequest(logger.Sugar), // Логирование запросов
-		AuditMiddleware(auditManager),       // Аудит операций
-	)
+	// Групповой коммит: обновления параллельных запросов записываются вместе
+	if synchronous && cfg.GroupCommitSize > 0 {
+		logger.Sugar.Infow("Group commit enabled", "size", cfg.GroupCommitSize, "wait", cfg.GroupCommitWait)
+		grouped := repository.NewGroupCommitStore(store, cfg.GroupCommitSize, cfg.GroupCommitWait)
+		go grouped.Run(ctx)
+		store = grouped
+	}
 
-	// Определение маршрутов приложения
-	r.Route("/", func(r chi.Router) {
-		// Маршруты для работы с базой данных (если подключена)
-		if *flagDatabaseDSN != "" {
-			r.Get("/ping", ping.GetPing)                       // Проверка доступности БД
-			r.Post("/update", db.PostUpdatePostgres)           // Обновление метрик
-			r.Post("/update/", db.PostUpdatePostgres)          // Альтернативный путь обновления
-			r.Post("/value", db.PostValue)                     // Получение значения метрики
-			r.Post("/value/", db.PostValue)                    // Альтернативный путь получения
-			r.Post("/updates", db.UpdatesGaugesBatchPostgres)  // Пакетное обновление метрик
-			r.Post("/updates/", db.UpdatesGaugesBatchPostgres) // Альтернативный путь пакетного обновления
-
-		} else {
-			// Маршруты для работы с in-memory хранилищем
-			r.Get("/", s.MainPage)                                   // Главная страница
-			r.Get("/value/{metric}/{name}", s.GetHandler)            // Получение метрики через URL параметры
-			r.Post("/update/{metric}/{name}/{value}", s.PostHandler) // Обновление через URL параметры
-			r.Post("/updates", s.UpdatesGaugesBatch)                 // Пакетное обновление gauge метрик
-			r.Post("/updates/", s.UpdatesGaugesBatch)                // Альтернативный путь пакетного обновления
-
-			// Выбор режима бэкапа в зависимости от интервала
-			if flagStoreInterval == 0 {
-				logger.Sugar.Info("Running in sync backup mode")
-				r.Post("/update", sSync.PostUpdateSyncBackup)  // Синхронный бэкап после каждого обновления
-				r.Post("/update/", sSync.PostUpdateSyncBackup) // Альтернативный путь синхронного обновления
-			} else {
-				logger.Sugar.Info("Running in async backup mode")
-				r.Post("/update", s.PostUpdate)  // Асинхронный бэкап (по расписанию)
-				r.Post("/update/", s.PostUpdate) // Альтернативный путь асинхронного обновления
-			}
+	// Единый набор обработчиков для любого хранилища
+	s := handler.NewServerWithKey(store, &cfg.Key)
+	ping := handler.NewServerPingDB(db)
+
+	// Статистика пула раскрывает нагрузку на базу, поэтому доступна только на отладочном listener
+	if cfg.DebugMux != nil {
+		cfg.DebugMux.HandleFunc("/debug/db", ping.GetDBStats)
+	}
 
-			r.Post("/value", s.PostValue)  // Получение значения через POST
-			r.Post("/value/", s.PostValue) // Альтернативный путь получения
+	// gRPC сервер рядом с HTTP: то же хранилище, подпись и аудит
+	if cfg.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			logger.Sugar.Fatalw("gRPC server failed to listen", "address", cfg.GRPCAddress, "error", err)
 		}
-	})
+		var grpcWG sync.WaitGroup
+		grpcWG.Add(1)
+		go RunGRPCServer(ctx, &grpcWG, listener, NewMetricsService(store, &cfg.Key, auditManager))
+		defer grpcWG.Wait() // gRPC сервер останавливается до закрытия хранилища
+	}
+
+	// Настройка маршрутизатора
+	r := newRouter(s, ping, auditManager)
 
 	// Настройка HTTP сервера
 	server := &http.Server{
-		Addr:    *flagRunAddr,
+		Addr:    cfg.Address,
 		Handler: r,
 	}
 
@@ -133,7 +201,7 @@ func RunHTTPServer(
 	}()
 
 	// Запуск сервера
-	logger.Sugar.Infof("Starting server on %s", *flagRunAddr)
+	logger.Sugar.Infof("Starting server on %s", cfg.Address)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Sugar.Error("Server failed: ", err)
 	}
@@ -157,3 +225,38 @@ func PrintBuildInfo() {
 	log.Printf("Build date: %s", buildDate)
 	log.Printf("Build commit: %s", buildCommit)
 }
+
+// newRouter создает маршрутизатор с middleware и маршрутами HTTP API (контракт - api/openapi.yaml)
+func newRouter(s *handler.Server, ping *handler.ServerPing, auditManager *AuditManager) chi.Router {
+	r := chi.NewRouter()
+
+	// Регистрация middleware компонентов
+	r.Use(
+		DecryptMiddleware,                   // Расшифровывание запросов
+		repository.GzipMiddleware,           // Сжатие ответов
+		logger.LoggingAnswer(logger.Sugar),  // Логирование ответов
+		logger.LoggingRequest(logger.Sugar), // Логирование запросов
+		AuditMiddleware(auditManager),       // Аудит операций
+	)
+
+	// Определение маршрутов приложения
+	r.Route("/", func(r chi.Router) {
+		r.Get("/", s.MainPage)                                   // Главная страница
+		r.Get("/ping", ping.GetPing)                             // Проверка доступности БД
+		r.Get("/value/{metric}/{name}", s.GetHandler)            // Получение метрики через URL параметры
+		r.Get("/values", s.ListValues)                           // Список метрик с фильтрами и пагинацией
+		r.Get("/history/{metric}/{name}", s.GetHistory)          // История значений метрики
+		r.Get("/metrics", s.PrometheusMetrics)                   // Экспозиция метрик для Prometheus
+		r.Post("/update/{metric}/{name}/{value}", s.PostHandler) // Обновление через URL параметры
+		r.Post("/update", s.PostUpdate)                          // Обновление метрик
+		r.Post("/update/", s.PostUpdate)                         // Альтернативный путь обновления
+		r.Post("/updates", s.UpdatesGaugesBatch)                 // Пакетное обновление метрик
+		r.Post("/updates/", s.UpdatesGaugesBatch)                // Альтернативный путь пакетного обновления
+		r.Post("/value", s.PostValue)                            // Получение значения через POST
+		r.Post("/value/", s.PostValue)                           // Альтернативный путь получения
+		r.Delete("/value/{metric}/{name}", s.DeleteHandler)      // Удаление метрики
+		r.Post("/delete", s.DeleteBatch)                         // Пакетное удаление метрик
+		r.Post("/api/v1/write", s.RemoteWrite)                   // Prometheus remote-write
+	})
+	return r
+}
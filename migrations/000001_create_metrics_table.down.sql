@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS counter_metrics;
+DROP TABLE IF EXISTS gauge_metrics;
@@ -0,0 +1,21 @@
+-- Создание таблицы для метрик histogram
+CREATE TABLE IF NOT EXISTS histogram_metrics (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    bounds DOUBLE PRECISION[] NOT NULL,
+    counts BIGINT[] NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL,
+    UNIQUE (name)
+);
+
+-- Создание таблицы для метрик summary
+CREATE TABLE IF NOT EXISTS summary_metrics (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    quantiles DOUBLE PRECISION[] NOT NULL,
+    quantile_values DOUBLE PRECISION[] NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL,
+    UNIQUE (name)
+);
@@ -0,0 +1,20 @@
+-- Серии с метками удаляются: без меток они не уникальны по имени
+DELETE FROM summary_metrics WHERE labels <> '{}';
+ALTER TABLE summary_metrics DROP CONSTRAINT IF EXISTS summary_metrics_name_labels_key;
+ALTER TABLE summary_metrics ADD CONSTRAINT summary_metrics_name_key UNIQUE (name);
+ALTER TABLE summary_metrics DROP COLUMN IF EXISTS labels;
+
+DELETE FROM histogram_metrics WHERE labels <> '{}';
+ALTER TABLE histogram_metrics DROP CONSTRAINT IF EXISTS histogram_metrics_name_labels_key;
+ALTER TABLE histogram_metrics ADD CONSTRAINT histogram_metrics_name_key UNIQUE (name);
+ALTER TABLE histogram_metrics DROP COLUMN IF EXISTS labels;
+
+DELETE FROM counter_metrics WHERE labels <> '{}';
+ALTER TABLE counter_metrics DROP CONSTRAINT IF EXISTS counter_metrics_name_labels_key;
+ALTER TABLE counter_metrics ADD CONSTRAINT counter_metrics_name_key UNIQUE (name);
+ALTER TABLE counter_metrics DROP COLUMN IF EXISTS labels;
+
+DELETE FROM gauge_metrics WHERE labels <> '{}';
+ALTER TABLE gauge_metrics DROP CONSTRAINT IF EXISTS gauge_metrics_name_labels_key;
+ALTER TABLE gauge_metrics ADD CONSTRAINT gauge_metrics_name_key UNIQUE (name);
+ALTER TABLE gauge_metrics DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,21 @@
+-- Метки входят в идентичность метрики: уникальность по паре (name, labels)
+-- Миграция идемпотентна: ограничения пересоздаются
+ALTER TABLE gauge_metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE gauge_metrics DROP CONSTRAINT IF EXISTS gauge_metrics_name_key;
+ALTER TABLE gauge_metrics DROP CONSTRAINT IF EXISTS gauge_metrics_name_labels_key;
+ALTER TABLE gauge_metrics ADD CONSTRAINT gauge_metrics_name_labels_key UNIQUE (name, labels);
+
+ALTER TABLE counter_metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE counter_metrics DROP CONSTRAINT IF EXISTS counter_metrics_name_key;
+ALTER TABLE counter_metrics DROP CONSTRAINT IF EXISTS counter_metrics_name_labels_key;
+ALTER TABLE counter_metrics ADD CONSTRAINT counter_metrics_name_labels_key UNIQUE (name, labels);
+
+ALTER TABLE histogram_metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE histogram_metrics DROP CONSTRAINT IF EXISTS histogram_metrics_name_key;
+ALTER TABLE histogram_metrics DROP CONSTRAINT IF EXISTS histogram_metrics_name_labels_key;
+ALTER TABLE histogram_metrics ADD CONSTRAINT histogram_metrics_name_labels_key UNIQUE (name, labels);
+
+ALTER TABLE summary_metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE summary_metrics DROP CONSTRAINT IF EXISTS summary_metrics_name_key;
+ALTER TABLE summary_metrics DROP CONSTRAINT IF EXISTS summary_metrics_name_labels_key;
+ALTER TABLE summary_metrics ADD CONSTRAINT summary_metrics_name_labels_key UNIQUE (name, labels);
@@ -0,0 +1,11 @@
+-- История значений gauge и counter, секционированная по дням
+-- Секции metric_samples_pYYYYMMDD создает и удаляет сервер по настройке samples_retention
+CREATE TABLE IF NOT EXISTS metric_samples (
+    type   TEXT NOT NULL,
+    name   TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts     TIMESTAMPTZ NOT NULL,
+    value  DOUBLE PRECISION NOT NULL
+) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_ts_idx ON metric_samples (type, name, labels, ts);
@@ -0,0 +1,9 @@
+-- Прежняя версия сервера считает начальную миграцию примененной по записи в этой таблице
+CREATE TABLE IF NOT EXISTS migrations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+INSERT INTO migrations (name)
+SELECT '000001_create_metrics_table'
+WHERE NOT EXISTS (SELECT 1 FROM migrations WHERE name = '000001_create_metrics_table');
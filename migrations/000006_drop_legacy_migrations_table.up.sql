@@ -0,0 +1,3 @@
+-- Таблица учета миграций прежней версии сервера: версии схемы хранит schema_migrations,
+-- а единственная запись старой таблицы (000001_create_metrics_table) уже отмечена в ней
+DROP TABLE IF EXISTS migrations;
@@ -0,0 +1,10 @@
+// Package migrations содержит SQL миграции схемы базы данных, встроенные в бинарный файл
+// Имена файлов: <версия>_<описание>.up.sql и <версия>_<описание>.down.sql
+package migrations
+
+import "embed"
+
+// FS - файлы миграций
+//
+//go:embed *.sql
+var FS embed.FS